@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/server"
+)
+
+func createMerchant(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		name, err := argument(args)
+		if err != nil {
+			return err
+		}
+
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			m, keys, err := c.CreateMerchant(ctx, name)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Created merchant %v (%v)\n", m.Name, m.ID)
+			for mode, key := range keys {
+				fmt.Printf("%v key: %v\n", mode, key)
+			}
+			return nil
+		})
+	}
+}
+
+func createAdmin(fs *flag.FlagSet) runner {
+	role := fs.String("role", database.RoleAdmin, "Role of the admin: viewer, operator or admin")
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		name, err := argument(args)
+		if err != nil {
+			return err
+		}
+
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			token, a, err := c.CreateAdmin(ctx, name, *role)
+			if err != nil {
+				return err
+			}
+
+			fmt.Printf("Created %v %v (%v)\n", a.Role, a.Name, a.ID)
+			fmt.Printf("token: %v\n", token)
+			return nil
+		})
+	}
+}
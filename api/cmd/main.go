@@ -2,18 +2,157 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/Aran404/Forwarder/api/config"
 	"github.com/Aran404/Forwarder/api/server"
-	"github.com/Aran404/Forwarder/api/types"
 )
 
+type (
+	// runner carries out a command once its flags are parsed, args are what is left after them
+	runner func(ctx context.Context, cfg *config.Config, args []string) error
+
+	// command is one task of the forwarder binary, selected by the words of its name
+	command struct {
+		name  string
+		args  string // Positional arguments, for the usage line
+		help  string
+		setup func(fs *flag.FlagSet) runner // Registers the command's flags and returns what runs it
+	}
+)
+
+var (
+	path      = config.DefaultPath
+	overrides config.Overrides
+
+	errUsage = errors.New("usage")
+)
+
+var commands = []*command{
+	{name: "serve", help: "Serve the API, the default without a command", setup: serve},
+	{name: "migrate", help: "Apply pending database migrations", setup: migrate},
+	{name: "config validate", help: "Load and validate the config, reporting every problem", setup: validateConfig},
+	{name: "payments list", help: "List payments, newest first", setup: listPayments},
+	{name: "payments show", args: "<id>", help: "Show a payment with its transactions and webhooks", setup: showPayment},
+	{name: "payments recheck", args: "<id>", help: "Look a payment's deposit address up on chain and process anything missed", setup: recheckPayment},
+	{name: "webhooks replay", args: "<payment id>", help: "Resend every webhook recorded for a payment", setup: replayWebhooks},
+	{name: "wallets list", help: "List deposit wallets with their balance and payment", setup: listWallets},
+	{name: "wallets sweep", help: "Forward the funds of every paid payment", setup: sweepWallets},
+	{name: "keys generate", help: "Generate a wallet file, such as a fee payer", setup: generateKey},
+	{name: "keys encrypt", args: "[file...]", help: "Encrypt wallet files with wallet.passphrase, every deposit wallet and the fee payer by default", setup: encryptKeys},
+	{name: "reconcile", help: "Compare deposit wallets with payments and recheck those holding missed funds", setup: reconcile},
+	{name: "merchants create", args: "<name>", help: "Create a merchant and print its API keys", setup: createMerchant},
+	{name: "admins create", args: "<name>", help: "Create an admin and print its token", setup: createAdmin},
+}
+
+// globalFlags are accepted before the command and after it, given after they add to those before
+func globalFlags(fs *flag.FlagSet) {
+	fs.StringVar(&path, "config", path, "Path of the JSON config file")
+	fs.Var(&overrides, "set", "Override a config value as key=value, e.g. -set detection.mode=poll (repeatable)")
+}
+
+// find returns the command named by the leading args and the args after its name
+func find(args []string) (*command, []string) {
+	for _, cmd := range commands {
+		words := strings.Fields(cmd.name)
+		if len(args) >= len(words) && strings.Join(args[:len(words)], " ") == cmd.name {
+			return cmd, args[len(words):]
+		}
+	}
+	return nil, nil
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: forwarder [-config path] [-set key=value]... <command> [flags] [args]\n\nCommands:\n")
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %v %v\t%v\n", cmd.name, cmd.args, cmd.help)
+	}
+	w.Flush()
+
+	fmt.Fprintf(out, "\nRun forwarder <command> -h for the flags of a command.\n")
+}
+
 func main() {
-	types.Clear()
-	log.Println("Listening on port 3443")
+	globalFlags(flag.CommandLine)
+	flag.Usage = usage
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	cmd, rest := find(args)
+	if cmd == nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command %q\n\n", strings.Join(args, " "))
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: forwarder %v [flags] %v\n\n%v\n\nFlags:\n", cmd.name, cmd.args, cmd.help)
+		fs.PrintDefaults()
+	}
+	globalFlags(fs)
+	run := cmd.setup(fs)
+	fs.Parse(rest)
+
+	cfg, err := config.Load(path, overrides...)
+	if err != nil {
+		log.Fatalf("Invalid config, Error: %v", err)
+	}
+
+	if err := run(context.Background(), cfg, fs.Args()); errors.Is(err, errUsage) {
+		fs.Usage()
+		os.Exit(2)
+	} else if err != nil {
+		log.Fatalf("%v failed, Error: %v", cmd.name, err)
+	}
+}
 
-	ctx := context.Background()
-	c := server.NewClient(ctx)
+// withClient runs fn with a client that is closed afterwards
+// Background work such as confirmation tracking is waited for before it is closed
+func withClient(ctx context.Context, cfg *config.Config, fn func(c *server.Client) error) error {
+	c, err := server.NewClient(ctx, cfg)
+	if err != nil {
+		return err
+	}
 	defer c.Close(ctx)
-	c.Listen()
+	defer c.Wait()
+	return fn(c)
+}
+
+// argument returns the only positional argument, or errUsage
+func argument(args []string) (string, error) {
+	if len(args) != 1 || args[0] == "" {
+		return "", errUsage
+	}
+	return args[0], nil
+}
+
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// printTable writes rows under the header with aligned columns
+func printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
 }
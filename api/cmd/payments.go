@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/server"
+)
+
+func listPayments(fs *flag.FlagSet) runner {
+	filters := map[string]*string{
+		"status":      fs.String("status", "", "Only payments with the status"),
+		"merchant_id": fs.String("merchant", "", "Only payments of the merchant ID"),
+		"address":     fs.String("address", "", "Only the payment of the deposit address"),
+		"mode":        fs.String("mode", "", "Only live or test payments"),
+		"signature":   fs.String("signature", "", "Only the payment credited with the transaction"),
+		"sender":      fs.String("sender", "", "Only payments sent from the address"),
+	}
+	limit := fs.Int64("limit", 20, "Payments per page, at most 100")
+	cursor := fs.String("cursor", "", "Next cursor printed with the previous page")
+	ascending := fs.Bool("asc", false, "List oldest first")
+	asJSON := fs.Bool("json", false, "Print the page as JSON")
+
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		values := make(map[string]string, len(filters))
+		for key, v := range filters {
+			values[key] = *v
+		}
+
+		if *limit <= 0 || *limit > server.MaxAdminListLimit {
+			*limit = server.MaxAdminListLimit
+		}
+		query := server.PaymentQuery(values).Limit(*limit).After(*cursor).OrderBy(database.PaymentCreatedAt, *ascending)
+
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			page, err := c.ListPayments(ctx, query)
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				return printJSON(page)
+			}
+
+			rows := make([][]string, 0, len(page.Items))
+			for _, p := range page.Items {
+				rows = append(rows, []string{
+					p.ID,
+					p.Status,
+					strconv.FormatFloat(p.Amount, 'f', -1, 64),
+					strconv.FormatFloat(p.AmountReceived, 'f', -1, 64),
+					p.Address,
+					time.Unix(int64(p.CreatedAt), 0).UTC().Format(time.RFC3339),
+				})
+			}
+			if err := printTable([]string{"ID", "STATUS", "AMOUNT", "RECEIVED", "ADDRESS", "CREATED"}, rows); err != nil {
+				return err
+			}
+			if page.Next != "" {
+				fmt.Printf("\nMore with -cursor %v\n", page.Next)
+			}
+			return nil
+		})
+	}
+}
+
+func showPayment(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		id, err := argument(args)
+		if err != nil {
+			return err
+		}
+
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			details, err := c.PaymentDetails(ctx, id)
+			if err != nil {
+				return err
+			}
+			return printJSON(details)
+		})
+	}
+}
+
+func recheckPayment(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		id, err := argument(args)
+		if err != nil {
+			return err
+		}
+
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			details, err := c.PaymentDetails(ctx, id)
+			if err != nil {
+				return err
+			}
+			if err := c.Recheck(ctx, details.Payment); err != nil {
+				return err
+			}
+
+			// A transaction found now is tracked until it is finalized, which the payment shown has to wait for
+			c.Wait()
+			if details, err = c.PaymentDetails(ctx, id); err != nil {
+				return err
+			}
+			return printJSON(details.Payment)
+		})
+	}
+}
+
+func replayWebhooks(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		id, err := argument(args)
+		if err != nil {
+			return err
+		}
+
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			details, err := c.PaymentDetails(ctx, id)
+			if err != nil {
+				return err
+			}
+
+			sent, err := c.ReplayWebhooks(ctx, details.Payment)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Sent %v webhooks to %v\n", sent, details.Payment.CallbackURI)
+			return nil
+		})
+	}
+}
+
+func reconcile(fs *flag.FlagSet) runner {
+	asJSON := fs.Bool("json", false, "Print the report as JSON")
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			report, err := c.Reconcile(ctx)
+			if err != nil {
+				return err
+			}
+
+			c.Wait()
+			if *asJSON {
+				return printJSON(report)
+			}
+
+			fmt.Printf("Checked %v wallets\n", report.Wallets)
+			sections := []struct {
+				title string
+				ids   []string
+			}{
+				{"Recovered, credited with a missed transaction", report.Recovered},
+				{"Unmatched, holding funds no transaction accounts for", report.Unmatched},
+				{"Unforwarded, paid and waiting to be swept", report.Unforwarded},
+				{"Leftover, funds still in the wallet after forwarding or refunding", report.Leftover},
+				{"Orphaned wallets without a payment", report.Orphaned},
+				{"Missing wallet files", report.Missing},
+			}
+			for _, s := range sections {
+				if len(s.ids) == 0 {
+					continue
+				}
+				fmt.Printf("\n%v:\n", s.title)
+				for _, id := range s.ids {
+					fmt.Printf("  %v\n", id)
+				}
+			}
+
+			if len(report.Failed) > 0 {
+				fmt.Println("\nCould not check:")
+				for id, reason := range report.Failed {
+					fmt.Printf("  %v: %v\n", id, reason)
+				}
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/server"
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+func serve(fs *flag.FlagSet) runner {
+	// Kept with the other overrides, so a reload does not move the address back
+	fs.Func("listen", "Address to listen on, short for -set server.address=...", func(v string) error {
+		return overrides.Set("server.address=" + v)
+	})
+
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		c, err := server.NewClient(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		// A second signal is not caught, so it kills a shutdown that hangs
+		stopping, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		context.AfterFunc(stopping, stop)
+
+		types.Clear()
+		log.Printf("Listening on %v", cfg.Server.Address)
+		err = c.Listen(stopping)
+
+		// Closed before returning, a failed shutdown exits through log.Fatalf which skips deferred calls
+		c.Close(ctx)
+		if err == nil {
+			log.Println("Shut down")
+		}
+		return err
+	}
+}
+
+func migrate(fs *flag.FlagSet) runner {
+	dryRun := fs.Bool("dry-run", false, "List the pending migrations without applying them")
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		db, err := database.Open(ctx, cfg.Database)
+		if err != nil {
+			return err
+		}
+		defer db.Close(ctx)
+
+		if *dryRun {
+			pending, err := db.Pending(ctx)
+			if err != nil {
+				return err
+			}
+			for _, m := range pending {
+				fmt.Printf("%v: %v\n", m.Version, m.Name)
+			}
+			fmt.Printf("%v migrations are pending\n", len(pending))
+			return nil
+		}
+
+		if err := server.Migrate(ctx, db); err != nil {
+			return err
+		}
+		log.Println("Database is up to date")
+		return nil
+	}
+}
+
+// validateConfig has nothing left to do, an invalid config stops the binary before any command runs
+func validateConfig(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		fmt.Printf("%v is valid\n", path)
+		return nil
+	}
+}
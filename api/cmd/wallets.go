@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/server"
+	"github.com/Aran404/Forwarder/api/solana"
+)
+
+func listWallets(fs *flag.FlagSet) runner {
+	asJSON := fs.Bool("json", false, "Print the wallets as JSON")
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			wallets, err := c.Wallets(ctx)
+			if err != nil {
+				return err
+			}
+			if *asJSON {
+				return printJSON(wallets)
+			}
+
+			rows := make([][]string, 0, len(wallets))
+			for _, w := range wallets {
+				balance := strconv.FormatFloat(w.Balance, 'f', -1, 64)
+				if w.Error != "" {
+					balance = w.Error
+				}
+				rows = append(rows, []string{w.Address, balance, w.PaymentID, w.Status})
+			}
+			return printTable([]string{"ADDRESS", "BALANCE", "PAYMENT", "STATUS"}, rows)
+		})
+	}
+}
+
+func sweepWallets(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		return withClient(ctx, cfg, func(c *server.Client) error {
+			swept, err := c.Sweep(ctx)
+			if err != nil {
+				return err
+			}
+
+			for _, id := range swept.Swept {
+				fmt.Printf("Swept %v\n", id)
+			}
+			for id, reason := range swept.Failed {
+				fmt.Printf("Could not sweep %v: %v\n", id, reason)
+			}
+			if len(swept.Failed) > 0 {
+				return fmt.Errorf("%v of %v payments failed", len(swept.Failed), len(swept.Failed)+len(swept.Swept))
+			}
+			return nil
+		})
+	}
+}
+
+func generateKey(fs *flag.FlagSet) runner {
+	out := fs.String("out", "", "File to write the wallet to, <address>.dat when unset")
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		sol, err := solana.NewClient(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		w := sol.CreateWallet()
+		file := *out
+		if file == "" {
+			file = w.PublicKey.String() + ".dat"
+		}
+		if _, err := os.Stat(file); !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("%v already exists", file)
+		}
+
+		if err := sol.WriteWallet(w, file); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote %v to %v", w.PublicKey, file)
+		if cfg.Wallet.Passphrase != "" {
+			fmt.Print(", encrypted")
+		}
+		fmt.Println()
+		return nil
+	}
+}
+
+func encryptKeys(fs *flag.FlagSet) runner {
+	return func(ctx context.Context, cfg *config.Config, args []string) error {
+		files := args
+		if len(files) == 0 {
+			var err error
+			if files, err = filepath.Glob(filepath.Join("wal", "*.dat")); err != nil {
+				return err
+			}
+			if cfg.Forwarder.FeePayer != "" {
+				files = append(files, cfg.Forwarder.FeePayer)
+			}
+		}
+
+		sol, err := solana.NewClient(ctx, cfg)
+		if err != nil {
+			return err
+		}
+
+		var encrypted int
+		for _, file := range files {
+			done, err := sol.EncryptFile(file)
+			if err != nil {
+				return err
+			}
+			if done {
+				encrypted++
+			}
+		}
+		fmt.Printf("Encrypted %v of %v wallet files, the rest already were\n", encrypted, len(files))
+		return nil
+	}
+}
@@ -0,0 +1,165 @@
+package config
+
+const (
+	DefaultPath    = "config.json"
+	DefaultEnvFile = ".env"
+	EnvPrefix      = "FORWARDER_" // Environment variables such as FORWARDER_DATABASE_URI override the file
+)
+
+type (
+	// Config is everything the forwarder is configured with
+	// It is layered from Default, the config file, the environment and flags, then validated
+	Config struct {
+		RatelimitEvery int         `json:"ratelimit_every"` // Seconds between requests to an RPC endpoint
+		RatelimitReset int         `json:"ratelimit_reset"` // Requests an RPC endpoint may burst
+		KeyRatelimit   int         `json:"key_ratelimit"`   // Default requests per minute for each API key
+		Server         Server      `json:"server"`
+		Database       Database    `json:"database"`
+		Forwarder      Forwarder   `json:"forwarder"`
+		RPC            RPC         `json:"rpc"`
+		Detection      Detection   `json:"detection"`
+		Watcher        Watcher     `json:"watcher"`
+		PriorityFee    PriorityFee `json:"priority_fee"`
+		Sweep          Sweep       `json:"sweep"`
+		LookupTable    LookupTable `json:"lookup_table"`
+		Wallet         Wallet      `json:"wallet"`
+		Test           Test        `json:"test"`
+
+		path      string   // File it was loaded from, read again by Reload
+		overrides []string // Flags it was loaded with, applied again by Reload
+	}
+
+	Server struct {
+		Address         string `json:"address"`          // Address the API listens on
+		PaymentDeadline int    `json:"payment_deadline"` // Seconds a payment waits for funds before it expires
+		AllowLocalhost  bool   `json:"allow_localhost"`  // Accept callback uris on localhost, for development
+		ShutdownTimeout int    `json:"shutdown_timeout"` // Seconds requests and forwards in flight get to finish on shutdown
+		TLS             TLS    `json:"tls"`
+	}
+
+	TLS struct {
+		Cert       string `json:"cert"`        // PEM certificate chain, the API is served over plain HTTP when empty
+		Key        string `json:"key"`         // PEM private key of the certificate
+		ClientCA   string `json:"client_ca"`   // PEM CAs that sign the client certificates of merchant backends, enables mTLS
+		ClientAuth string `json:"client_auth"` // require, or optional to also accept clients without a certificate
+		Redirect   string `json:"redirect"`    // Plain HTTP address redirecting to HTTPS, such as :80
+	}
+
+	Database struct {
+		Driver           string `json:"driver"`            // mongo, sqlite, postgres or memory
+		URI              string `json:"uri"`               // Connection string, or the file path for sqlite
+		Name             string `json:"name"`              // Database name, only used by mongo
+		Migrate          bool   `json:"migrate"`           // Apply pending migrations on startup, otherwise run the migrate command
+		WebhookRetention int    `json:"webhook_retention"` // Days sent webhooks are kept, 0 keeps them forever
+	}
+
+	Forwarder struct {
+		ForwardAddress       string  `json:"foward_address"`
+		MinForward           float64 `json:"min_forward"`           // Minimum amount of a payment in SOL
+		TransactionThreshold float64 `json:"transaction_threshold"` // Share of the amount a payment may fall short by
+		FeePayer             string  `json:"fee_payer"`             // Path to the wallet file that pays network fees
+	}
+
+	// RPCEndpoint is a Solana node the client can fail over between
+	RPCEndpoint struct {
+		HTTP   string `json:"http"`
+		WS     string `json:"ws"`
+		Weight int    `json:"weight"` // Relative share of requests, 1 when unset
+	}
+
+	RPC struct {
+		Endpoints        []RPCEndpoint `json:"endpoints"`         // Falls back to SOLANA_NET_HTTP and SOLANA_NET_WS when empty
+		HealthInterval   int           `json:"health_interval"`   // Seconds between health and slot checks
+		MaxSlotLag       uint64        `json:"max_slot_lag"`      // Slots an endpoint may trail the highest one before it is skipped
+		BreakerThreshold int           `json:"breaker_threshold"` // Consecutive failures that open an endpoint's circuit
+		BreakerCooldown  int           `json:"breaker_cooldown"`  // Seconds an open circuit waits before trying the endpoint again
+	}
+
+	Detection struct {
+		Mode         string `json:"mode"`          // subscribe, poll, fallback or both
+		PollInterval int    `json:"poll_interval"` // Seconds between getSignaturesForAddress calls per address
+	}
+
+	Watcher struct {
+		Workers          int `json:"workers"`           // Concurrent transaction lookups and polls
+		MaxSubscriptions int `json:"max_subscriptions"` // Log subscriptions kept open, addresses past it are polled
+	}
+
+	PriorityFee struct {
+		Enabled          bool   `json:"enabled"`
+		Percentile       int    `json:"percentile"`         // Percentile of recent prioritization fees to pay
+		MaxMicroLamports uint64 `json:"max_micro_lamports"` // Cap on the price per compute unit
+	}
+
+	Sweep struct {
+		Enabled  bool `json:"enabled"`
+		MaxDelay int  `json:"max_delay"` // Seconds a paid payment may wait before it is swept
+		MinBatch int  `json:"min_batch"` // Payments to accumulate before sweeping early
+	}
+
+	LookupTable struct {
+		Enabled   bool     `json:"enabled"`
+		Addresses []string `json:"addresses"` // Frequently used addresses besides the forward address and fee payer, such as a treasury
+	}
+
+	Wallet struct {
+		Passphrase string `json:"passphrase"` // Encrypts new wallet files and decrypts encrypted ones, best set as FORWARDER_WALLET_PASSPHRASE
+	}
+
+	// Test is the network payments made with test keys are watched and forwarded on, such as devnet
+	Test struct {
+		Enabled        bool          `json:"enabled"`         // Test keys are refused payments while disabled
+		ForwardAddress string        `json:"forward_address"` // Where test payments are forwarded on the test network
+		Endpoints      []RPCEndpoint `json:"endpoints"`
+	}
+)
+
+// Default returns the configuration used for anything the file, environment and flags leave out
+// Zero tuning values such as rpc.health_interval are filled in by the component that uses them
+func Default() *Config {
+	return &Config{
+		RatelimitEvery: 100,
+		RatelimitReset: 10,
+		KeyRatelimit:   100,
+		Server: Server{
+			Address:         ":3443",
+			PaymentDeadline: 1800,
+			ShutdownTimeout: 30,
+			TLS: TLS{
+				ClientAuth: "require",
+			},
+		},
+		Database: Database{
+			Driver:           "mongo",
+			URI:              "mongodb://localhost:27017",
+			Name:             "PaymentProcessor",
+			Migrate:          true,
+			WebhookRetention: 30,
+		},
+		Forwarder: Forwarder{
+			MinForward:           0.02,
+			TransactionThreshold: 0.05,
+		},
+		Detection: Detection{
+			Mode:         "fallback",
+			PollInterval: 5,
+		},
+		PriorityFee: PriorityFee{
+			Enabled:          true,
+			Percentile:       75,
+			MaxMicroLamports: 1_000_000,
+		},
+	}
+}
+
+// TestConfig returns the config test payments use, c with the endpoints and forward address of the test network
+// The fee payer and lookup table only exist on the live network, test deposit wallets pay their own fees
+func (c *Config) TestConfig() *Config {
+	test := new(Config)
+	*test = *c
+	test.RPC.Endpoints = c.Test.Endpoints
+	test.Forwarder.ForwardAddress = c.Test.ForwardAddress
+	test.Forwarder.FeePayer = ""
+	test.LookupTable.Enabled = false
+	return test
+}
@@ -0,0 +1,196 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+// Overrides collects repeated -set key=value flags
+type Overrides []string
+
+func (o *Overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *Overrides) Set(v string) error {
+	*o = append(*o, v)
+	return nil
+}
+
+// Load layers the config file, the environment and the overrides over Default and validates the result
+// A missing file is only an error when a path other than DefaultPath was given
+func Load(path string, overrides ...string) (*Config, error) {
+	cfg := Default()
+	if err := cfg.readFile(path); err != nil {
+		return nil, err
+	}
+
+	// The .env file is read on every load rather than copied into the environment, so a reload sees its changes
+	dotenv, err := godotenv.Read(DefaultEnvFile)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("could not read %v: %w", DefaultEnvFile, err)
+	}
+	if err := cfg.readEnv(dotenv); err != nil {
+		return nil, err
+	}
+
+	for _, o := range overrides {
+		key, value, ok := strings.Cut(o, "=")
+		if !ok {
+			return nil, fmt.Errorf("override %q is not key=value", o)
+		}
+		if err := cfg.Set(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.path, cfg.overrides = path, overrides
+	return cfg, nil
+}
+
+func (c *Config) readFile(path string) error {
+	if path == "" {
+		path = DefaultPath
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) && path == DefaultPath {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Unknown keys are rejected so a typo is not silently ignored
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return fmt.Errorf("could not parse %v: %w", path, err)
+	}
+	return nil
+}
+
+// readEnv applies the environment, variables already in the environment win over the .env file
+func (c *Config) readEnv(dotenv map[string]string) error {
+	lookup := func(name string) (string, bool) {
+		if v, ok := os.LookupEnv(name); ok {
+			return v, true
+		}
+		v, ok := dotenv[name]
+		return v, ok
+	}
+
+	if http, _ := lookup("SOLANA_NET_HTTP"); len(c.RPC.Endpoints) == 0 && http != "" {
+		ws, _ := lookup("SOLANA_NET_WS")
+		c.RPC.Endpoints = []RPCEndpoint{{HTTP: http, WS: ws}}
+	}
+
+	var err error
+	walk(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		name := EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+		if v, ok := lookup(name); ok && err == nil {
+			if err = set(field, v); err != nil {
+				err = fmt.Errorf("%v: %w", name, err)
+			}
+		}
+	})
+	return err
+}
+
+// Set changes the value at a dotted key such as database.uri
+// Lists of strings are given comma separated, lists of endpoints can only be set in the file
+func (c *Config) Set(key, value string) error {
+	var found *reflect.Value
+	walk(reflect.ValueOf(c).Elem(), "", func(k string, field reflect.Value) {
+		if k == key {
+			found = &field
+		}
+	})
+
+	if found == nil {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if err := set(*found, value); err != nil {
+		return fmt.Errorf("%v: %w", key, err)
+	}
+	return nil
+}
+
+// walk calls fn with the dotted json key of every setting
+func walk(v reflect.Value, prefix string, fn func(key string, field reflect.Value)) {
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Type().Field(i).IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			walk(field, name, fn)
+			continue
+		}
+		fn(name, field)
+	}
+}
+
+func set(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", value)
+		}
+		field.SetInt(n)
+	case reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a positive integer", value)
+		}
+		field.SetUint(n)
+	case reflect.Float64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		field.SetFloat(n)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() != reflect.String {
+			return errors.New("can only be set in the config file")
+		}
+
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		field.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("cannot be set from %q", value)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+// inTempDir runs the test in an empty directory, so no .env or config.json of the checkout is read
+func inTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp := t.TempDir()
+	if err := os.Chdir(tmp); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(dir) })
+	return tmp
+}
+
+func write(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeConfig writes a valid config file with the settings merged in
+func writeConfig(t *testing.T, path string, settings map[string]any) {
+	t.Helper()
+	file := map[string]any{
+		"forwarder": map[string]any{"foward_address": solana.NewWallet().PublicKey().String()},
+		"rpc":       map[string]any{"endpoints": []map[string]any{{"http": "https://rpc.example", "ws": "wss://rpc.example"}}},
+	}
+	for k, v := range settings {
+		file[k] = v
+	}
+
+	b, err := json.Marshal(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	write(t, path, string(b))
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := inTempDir(t)
+	path := filepath.Join(dir, "forwarder.json")
+	writeConfig(t, path, map[string]any{
+		"detection": map[string]any{"mode": "poll", "poll_interval": 7},
+		"sweep":     map[string]any{"min_batch": 3},
+	})
+	t.Setenv("FORWARDER_DETECTION_MODE", "both")
+	t.Setenv("FORWARDER_SWEEP_MIN_BATCH", "5")
+
+	cfg, err := Load(path, "detection.mode=subscribe")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", cfg.PriorityFee.Percentile, 75},
+		{"file", cfg.Detection.PollInterval, 7},
+		{"environment over file", cfg.Sweep.MinBatch, 5},
+		{"override over environment", cfg.Detection.Mode, "subscribe"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	dir := inTempDir(t)
+	path := filepath.Join(dir, "forwarder.json")
+	writeConfig(t, path, nil)
+
+	write(t, filepath.Join(dir, DefaultEnvFile), "FORWARDER_DETECTION_MODE=poll\nFORWARDER_SWEEP_MIN_BATCH=2\n")
+	t.Setenv("FORWARDER_SWEEP_MIN_BATCH", "9")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Detection.Mode != "poll" || cfg.Sweep.MinBatch != 9 {
+		t.Fatalf("got mode %v and min batch %v, want poll from .env and 9 from the environment", cfg.Detection.Mode, cfg.Sweep.MinBatch)
+	}
+
+	// A reload sees the file as it is now
+	write(t, filepath.Join(dir, DefaultEnvFile), "FORWARDER_DETECTION_MODE=both\n")
+	next, err := cfg.Reload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next.Detection.Mode != "both" {
+		t.Errorf("reloaded mode %v, want both", next.Detection.Mode)
+	}
+	if _, ok := os.LookupEnv("FORWARDER_DETECTION_MODE"); ok {
+		t.Error(".env leaked into the process environment")
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	dir := inTempDir(t)
+	path := filepath.Join(dir, "forwarder.json")
+
+	write(t, path, `{"detection": {"mod": "poll"}}`)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "mod") {
+		t.Errorf("unknown key: got %v, want it named", err)
+	}
+
+	if _, err := Load(filepath.Join(dir, "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing file: got %v, want ErrNotExist", err)
+	}
+
+	writeConfig(t, path, nil)
+	for _, o := range []string{"detection.mode", "unknown.key=1", "sweep.min_batch=many"} {
+		if _, err := Load(path, o); err == nil {
+			t.Errorf("override %q was accepted", o)
+		}
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	cfg := Default()
+	cfg.Forwarder.ForwardAddress = "not an address"
+	cfg.Forwarder.TransactionThreshold = 1
+	cfg.Database.Driver = "redis"
+	cfg.Detection.Mode = "guess"
+	cfg.Server.TLS.Cert = "cert.pem"
+
+	err := cfg.Validate()
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Fatalf("got %v, want ErrInvalidValue", err)
+	}
+	for _, key := range []string{
+		"forwarder.foward_address", "forwarder.transaction_threshold", "database.driver",
+		"detection.mode", "rpc.endpoints", "server.tls",
+	} {
+		if !strings.Contains(err.Error(), key+":") {
+			t.Errorf("%v is not reported in %v", key, err)
+		}
+	}
+}
+
+func TestValidateTestNetwork(t *testing.T) {
+	cfg := Default()
+	cfg.Forwarder.ForwardAddress = "11111111111111111111111111111111"
+	cfg.RPC.Endpoints = []RPCEndpoint{{HTTP: "https://api.mainnet-beta.solana.com", WS: "wss://api.mainnet-beta.solana.com"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("disabled test section: got %v", err)
+	}
+
+	cfg.Test.Enabled = true
+	err := cfg.Validate()
+	for _, key := range []string{"test.forward_address", "test.endpoints"} {
+		if err == nil || !strings.Contains(err.Error(), key+":") {
+			t.Errorf("%v is not reported in %v", key, err)
+		}
+	}
+
+	cfg.Test.ForwardAddress = cfg.Forwarder.ForwardAddress
+	cfg.Test.Endpoints = []RPCEndpoint{{HTTP: "https://api.devnet.solana.com", WS: "wss://api.devnet.solana.com"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("enabled test section: got %v", err)
+	}
+	test := cfg.TestConfig()
+	if test.RPC.Endpoints[0].HTTP != cfg.Test.Endpoints[0].HTTP || test.Forwarder.FeePayer != "" || cfg.RPC.Endpoints[0].HTTP == test.RPC.Endpoints[0].HTTP {
+		t.Errorf("test config uses %v, want only the test endpoints", test.RPC.Endpoints)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	current := Default()
+	next := Default()
+	next.Detection.Mode = "poll"
+	next.Sweep.Enabled = true
+	next.Database.URI = "mongodb://other:27017"
+	next.RPC.Endpoints = []RPCEndpoint{{HTTP: "https://rpc.example.com/?api-key=secret", WS: "wss://rpc.example.com/?api-key=secret"}}
+
+	merged, applied, pending := current.Merge(next)
+	if merged.Detection.Mode != "poll" {
+		t.Errorf("detection.mode is %v, want the new value applied", merged.Detection.Mode)
+	}
+	if merged.Sweep.Enabled || merged.Database.URI != current.Database.URI {
+		t.Error("settings that need a restart were not kept")
+	}
+	if next.Sweep.Enabled != true {
+		t.Error("merge changed next")
+	}
+
+	if len(applied) != 1 || applied[0].Key != "detection.mode" {
+		t.Errorf("applied %v, want detection.mode", applied)
+	}
+
+	keys := map[string]Change{}
+	for _, c := range pending {
+		keys[c.Key] = c
+	}
+	if len(pending) != 3 || keys["sweep.enabled"].To != true {
+		t.Errorf("pending %v, want sweep.enabled, database.uri and rpc.endpoints", pending)
+	}
+	for _, key := range []string{"database.uri", "rpc.endpoints"} {
+		if c := keys[key]; c.From != Redacted || c.To != Redacted {
+			t.Errorf("secret change %v is not redacted", c)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		raw, want string
+	}{
+		{"https://rpc.example.com/?api-key=secret", "https://rpc.example.com/?[redacted]"},
+		{"postgres://user:pass@db:5432/forwarder?sslmode=require", "postgres://[redacted]@db:5432/forwarder?[redacted]"},
+		{"wss://rpc.example.com/v1/secret", "wss://rpc.example.com/v1/secret"},
+		{"not a url", "not a url"},
+		{"forwarder.db", "forwarder.db"},
+	}
+
+	for _, tt := range tests {
+		if got := RedactURL(tt.raw); got != tt.want {
+			t.Errorf("RedactURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+)
+
+// Redacted replaces the value of a secret setting
+const Redacted = "[redacted]"
+
+// restartKeys are read once on startup, changing them only takes effect after a restart
+var restartKeys = []string{
+	"ratelimit_every",
+	"ratelimit_reset",
+	"server.address",
+	"server.tls",
+	"database",
+	"forwarder.fee_payer",
+	"rpc",
+	"detection.poll_interval",
+	"watcher",
+	"sweep",
+	"lookup_table",
+	"test.enabled",
+	"test.endpoints",
+}
+
+// secretKeys are never logged, a change to them only shows that they changed
+// Other settings holding a url with userinfo or a query have those parts redacted
+var secretKeys = []string{
+	"database.uri",
+	"wallet.passphrase",
+	"rpc.endpoints", // Providers put api keys in the http and ws urls
+	"test.endpoints",
+}
+
+// Change is a setting that differs between two configs
+type Change struct {
+	Key  string `json:"key"`
+	From any    `json:"from"`
+	To   any    `json:"to"`
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%v: %v -> %v", c.Key, c.From, c.To)
+}
+
+// RequiresRestart reports whether the setting at key is only read on startup
+func RequiresRestart(key string) bool {
+	for _, k := range restartKeys {
+		if key == k || strings.HasPrefix(key, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload loads the config again from the file and flags c was loaded from
+func (c *Config) Reload() (*Config, error) {
+	return Load(c.path, c.overrides...)
+}
+
+// Merge returns next with the settings that need a restart kept from c
+// Applied lists the settings that changed, pending those that changed but wait for a restart
+func (c *Config) Merge(next *Config) (merged *Config, applied, pending []Change) {
+	merged = new(Config)
+	*merged = *next
+
+	current := settings(c)
+	kept := settings(merged)
+	walk(reflect.ValueOf(next).Elem(), "", func(key string, field reflect.Value) {
+		from := current[key]
+		if reflect.DeepEqual(from.Interface(), field.Interface()) {
+			return
+		}
+
+		change := Change{Key: key, From: redact(key, from.Interface()), To: redact(key, field.Interface())}
+		if RequiresRestart(key) {
+			kept[key].Set(from)
+			pending = append(pending, change)
+			return
+		}
+		applied = append(applied, change)
+	})
+	return merged, applied, pending
+}
+
+// redact hides the value of a secret setting, and the credentials of a url
+func redact(key string, v any) any {
+	if oneOf(key, secretKeys) {
+		return Redacted
+	}
+	if s, ok := v.(string); ok {
+		return RedactURL(s)
+	}
+	return v
+}
+
+// RedactURL hides the userinfo and query of a url, where credentials and api keys are usually passed
+// Anything that is not an absolute url is returned as is
+func RedactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || (u.User == nil && u.RawQuery == "") {
+		return raw
+	}
+
+	bare := *u
+	bare.User, bare.RawQuery, bare.ForceQuery = nil, "", false
+	s := bare.String()
+	if u.User != nil {
+		s = strings.Replace(s, "://", "://"+Redacted+"@", 1)
+	}
+	if u.RawQuery != "" {
+		if i := strings.IndexByte(s, '#'); i >= 0 {
+			s = s[:i] + "?" + Redacted + s[i:]
+		} else {
+			s += "?" + Redacted
+		}
+	}
+	return s
+}
+
+// settings maps the dotted key of every setting of c to its field
+func settings(c *Config) map[string]reflect.Value {
+	fields := make(map[string]reflect.Value)
+	walk(reflect.ValueOf(c).Elem(), "", func(key string, field reflect.Value) {
+		fields[key] = field
+	})
+	return fields
+}
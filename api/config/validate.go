@@ -0,0 +1,189 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/gagliardetto/solana-go"
+)
+
+var (
+	drivers         = []string{"mongo", "sqlite", "postgres", "memory"}
+	detectionModes  = []string{"subscribe", "poll", "fallback", "both"}
+	clientAuths     = []string{"require", "optional"}
+	ErrInvalidValue = errors.New("invalid config")
+)
+
+// problems collects every invalid value so they are reported together
+type problems []error
+
+func (p *problems) add(key, format string, args ...any) {
+	*p = append(*p, fmt.Errorf("%v: %v", key, fmt.Sprintf(format, args...)))
+}
+
+// endpoints checks the urls and weight of every endpoint listed at key
+func (p *problems) endpoints(key string, endpoints []RPCEndpoint) {
+	for i, e := range endpoints {
+		if !validURL(e.HTTP, "http", "https") {
+			p.add(fmt.Sprintf("%v[%d].http", key, i), "%q is not an http(s) url", e.HTTP)
+		}
+		if !validURL(e.WS, "ws", "wss") {
+			p.add(fmt.Sprintf("%v[%d].ws", key, i), "%q is not a ws(s) url", e.WS)
+		}
+		if e.Weight < 0 {
+			p.add(fmt.Sprintf("%v[%d].weight", key, i), "must not be negative")
+		}
+	}
+}
+
+func oneOf(v string, options []string) bool {
+	for _, o := range options {
+		if v == o {
+			return true
+		}
+	}
+	return false
+}
+
+func validURL(raw string, schemes ...string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Host != "" && oneOf(u.Scheme, schemes)
+}
+
+func validAddress(address string) bool {
+	_, err := solana.PublicKeyFromBase58(address)
+	return err == nil
+}
+
+// Validate reports every value that is missing or out of range
+func (c *Config) Validate() error {
+	var p problems
+
+	if c.RatelimitEvery < 0 {
+		p.add("ratelimit_every", "must not be negative")
+	}
+	if c.RatelimitReset < 0 {
+		p.add("ratelimit_reset", "must not be negative")
+	}
+	if c.KeyRatelimit < 0 {
+		p.add("key_ratelimit", "must not be negative")
+	}
+
+	if c.Server.Address == "" {
+		p.add("server.address", "is required, such as :3443")
+	}
+	if c.Server.PaymentDeadline <= 0 {
+		p.add("server.payment_deadline", "must be a positive number of seconds")
+	}
+	if c.Server.ShutdownTimeout < 0 {
+		p.add("server.shutdown_timeout", "must not be negative")
+	}
+
+	tls := c.Server.TLS
+	if (tls.Cert == "") != (tls.Key == "") {
+		p.add("server.tls", "cert and key must be set together")
+	}
+	for _, f := range []struct{ key, path string }{
+		{"server.tls.cert", tls.Cert},
+		{"server.tls.key", tls.Key},
+		{"server.tls.client_ca", tls.ClientCA},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			p.add(f.key, "file %q cannot be read", f.path)
+		}
+	}
+	if tls.Cert == "" && (tls.ClientCA != "" || tls.Redirect != "") {
+		p.add("server.tls", "client_ca and redirect require a cert and key")
+	}
+	if tls.ClientCA != "" && !oneOf(tls.ClientAuth, clientAuths) {
+		p.add("server.tls.client_auth", "%q is not one of %v", tls.ClientAuth, clientAuths)
+	}
+	if tls.Redirect != "" && tls.Redirect == c.Server.Address {
+		p.add("server.tls.redirect", "must differ from server.address")
+	}
+
+	switch {
+	case !oneOf(c.Database.Driver, drivers):
+		p.add("database.driver", "%q is not one of %v", c.Database.Driver, drivers)
+	case c.Database.Driver == "mongo" && !validURL(c.Database.URI, "mongodb", "mongodb+srv"):
+		p.add("database.uri", "%q is not a mongodb:// connection string", c.Database.URI)
+	case c.Database.Driver == "mongo" && c.Database.Name == "":
+		p.add("database.name", "is required by the mongo driver")
+	case c.Database.Driver == "postgres" && !validURL(c.Database.URI, "postgres", "postgresql"):
+		p.add("database.uri", "%q is not a postgres:// connection string", c.Database.URI)
+	}
+	if c.Database.WebhookRetention < 0 {
+		p.add("database.webhook_retention", "must not be negative")
+	}
+
+	if !validAddress(c.Forwarder.ForwardAddress) {
+		p.add("forwarder.foward_address", "%q is not a base58 Solana address", c.Forwarder.ForwardAddress)
+	}
+	if c.Forwarder.MinForward < 0 {
+		p.add("forwarder.min_forward", "must not be negative")
+	}
+	if c.Forwarder.TransactionThreshold < 0 || c.Forwarder.TransactionThreshold >= 1 {
+		p.add("forwarder.transaction_threshold", "must be at least 0 and below 1")
+	}
+	if path := c.Forwarder.FeePayer; path != "" {
+		if _, err := os.Stat(path); err != nil {
+			p.add("forwarder.fee_payer", "wallet file %q cannot be read", path)
+		}
+	}
+
+	if len(c.RPC.Endpoints) == 0 {
+		p.add("rpc.endpoints", "at least one endpoint is required, or SOLANA_NET_HTTP and SOLANA_NET_WS")
+	}
+	p.endpoints("rpc.endpoints", c.RPC.Endpoints)
+
+	if !oneOf(c.Detection.Mode, detectionModes) {
+		p.add("detection.mode", "%q is not one of %v", c.Detection.Mode, detectionModes)
+	}
+	if c.Detection.PollInterval < 0 {
+		p.add("detection.poll_interval", "must not be negative")
+	}
+
+	if c.Watcher.Workers < 0 {
+		p.add("watcher.workers", "must not be negative")
+	}
+	if c.Watcher.MaxSubscriptions < 0 {
+		p.add("watcher.max_subscriptions", "must not be negative")
+	}
+
+	if c.PriorityFee.Percentile < 0 || c.PriorityFee.Percentile > 100 {
+		p.add("priority_fee.percentile", "must be between 0 and 100")
+	}
+
+	if c.Sweep.MaxDelay < 0 {
+		p.add("sweep.max_delay", "must not be negative")
+	}
+	if c.Sweep.MinBatch < 0 {
+		p.add("sweep.min_batch", "must not be negative")
+	}
+
+	for i, address := range c.LookupTable.Addresses {
+		if !validAddress(address) {
+			p.add(fmt.Sprintf("lookup_table.addresses[%d]", i), "%q is not a base58 Solana address", address)
+		}
+	}
+
+	if c.Test.Enabled {
+		if !validAddress(c.Test.ForwardAddress) {
+			p.add("test.forward_address", "%q is not a base58 Solana address", c.Test.ForwardAddress)
+		}
+		if len(c.Test.Endpoints) == 0 {
+			p.add("test.endpoints", "at least one endpoint of the test network is required")
+		}
+		p.endpoints("test.endpoints", c.Test.Endpoints)
+	}
+
+	if len(p) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w\n%w", ErrInvalidValue, errors.Join(p...))
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+func TestBackendAtomic(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		if m, ok := s.Backend.(*Mongo); ok && !m.transactions {
+			t.Skip("mongo standalone servers do not roll back")
+		}
+
+		ctx := context.Background()
+		if err := s.Payments.Insert(ctx, payment("p1", PaymentPending, 100)); err != nil {
+			t.Fatal(err)
+		}
+
+		failed := errors.New("failed")
+		err := s.Atomic(ctx, func(ctx context.Context, tx *Store) error {
+			if err := tx.Transactions.Insert(ctx, &Transaction{Signature: "sig1", PaymentID: "p1", CreatedAt: 1}); err != nil {
+				return err
+			}
+			if err := tx.Payments.Update(ctx, Where(PaymentID.Eq("p1")), PaymentStatus.To(PaymentSeen)); err != nil {
+				return err
+			}
+			return failed
+		})
+		if !errors.Is(err, failed) {
+			t.Fatalf("got %v, want the error of fn", err)
+		}
+
+		if p, err := s.Payments.Get(ctx, Where(PaymentID.Eq("p1"))); err != nil || p.Status != PaymentPending {
+			t.Errorf("payment after rollback: %+v, %v", p, err)
+		}
+		if _, err := s.Transactions.Get(ctx, Where(TransactionSignature.Eq("sig1"))); !errors.Is(err, types.ErrNotFound) {
+			t.Errorf("transaction after rollback: got %v, want ErrNotFound", err)
+		}
+
+		err = s.Atomic(ctx, func(ctx context.Context, tx *Store) error {
+			return tx.Payments.Update(ctx, Where(PaymentID.Eq("p1")), PaymentStatus.To(PaymentSeen))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p, err := s.Payments.Get(ctx, Where(PaymentID.Eq("p1"))); err != nil || p.Status != PaymentSeen {
+			t.Errorf("payment after commit: %+v, %v", p, err)
+		}
+	})
+}
+
+func TestMemoryAtomicUndoesOnlyItsWrites(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(NewMemory())
+	now := uint64(time.Now().Unix())
+	for _, p := range []*Payment{payment("kept", PaymentPaid, now), payment("updated", PaymentPaid, now), payment("deleted", PaymentPaid, now)} {
+		if err := s.Payments.Insert(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Idempotency.Insert(ctx, &IdempotencyKey{ID: "old", CreatedAt: now - 7200}); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	err := s.Atomic(ctx, func(ctx context.Context, tx *Store) error {
+		steps := []error{
+			tx.Payments.Insert(ctx, payment("inserted", PaymentPending, now)),
+			tx.Payments.Update(ctx, Where(PaymentID.Eq("updated")), PaymentStatus.To(PaymentForwarded), PaymentFeeLamports.Add(5000)),
+			tx.Payments.Delete(ctx, Where(PaymentID.Eq("deleted"))),
+		}
+		if _, err := tx.Idempotency.Expire(ctx, IdempotencyCreatedAt, time.Hour); err != nil {
+			steps = append(steps, err)
+		}
+		if err := errors.Join(steps...); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the error of fn", err)
+	}
+
+	if _, err := s.Payments.Get(ctx, Where(PaymentID.Eq("inserted"))); !errors.Is(err, types.ErrNotFound) {
+		t.Errorf("inserted payment: got %v, want ErrNotFound", err)
+	}
+	for _, id := range []string{"kept", "updated", "deleted"} {
+		p, err := s.Payments.Get(ctx, Where(PaymentID.Eq(id)))
+		if err != nil || p.Status != PaymentPaid || p.FeeLamports != 0 {
+			t.Errorf("payment %v after rollback: %+v, %v", id, p, err)
+		}
+	}
+	if _, err := s.Idempotency.Get(ctx, Where(IdempotencyID.Eq("old"))); err != nil {
+		t.Errorf("expired key after rollback: %v", err)
+	}
+}
+
+func TestMemoryAtomicNested(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(NewMemory())
+
+	failed := errors.New("failed")
+	err := s.Atomic(ctx, func(ctx context.Context, tx *Store) error {
+		if err := tx.Payments.Insert(ctx, payment("outer", PaymentPending, 1)); err != nil {
+			return err
+		}
+		// The nested call joins the outer one instead of waiting for the lock it holds
+		return tx.Atomic(ctx, func(ctx context.Context, tx *Store) error {
+			if err := tx.Payments.Insert(ctx, payment("inner", PaymentPending, 2)); err != nil {
+				return err
+			}
+			return failed
+		})
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the error of the nested fn", err)
+	}
+
+	if n, err := s.Payments.Count(ctx, Where[Payment]()); err != nil || n != 0 {
+		t.Errorf("got %v payments, %v, want both writes undone", n, err)
+	}
+}
+
+func TestMemoryAtomicHoldsOtherWriters(t *testing.T) {
+	ctx := context.Background()
+	s := NewStore(NewMemory())
+
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Atomic(ctx, func(ctx context.Context, tx *Store) error {
+			close(entered)
+			<-release
+			return tx.Payments.Insert(ctx, payment("atomic", PaymentPending, 1))
+		})
+	}()
+	<-entered
+
+	written := make(chan error, 1)
+	go func() {
+		written <- s.Payments.Insert(ctx, payment("other", PaymentPending, 2))
+	}()
+
+	select {
+	case err := <-written:
+		t.Fatalf("write finished while Atomic was running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-written; err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.Payments.Count(ctx, Where[Payment]()); err != nil || n != 2 {
+		t.Errorf("got %v payments, %v, want 2", n, err)
+	}
+}
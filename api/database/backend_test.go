@@ -0,0 +1,268 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/google/uuid"
+)
+
+// eachBackend runs the test against a migrated store on every backend
+// Memory and SQLite always run, Postgres and Mongo only when FORWARDER_TEST_POSTGRES or FORWARDER_TEST_MONGO holds a URI
+func eachBackend(t *testing.T, fn func(t *testing.T, s *Store)) {
+	t.Helper()
+	ctx := context.Background()
+
+	open := map[string]func(t *testing.T) (Backend, error){
+		DriverMemory: func(t *testing.T) (Backend, error) {
+			return NewMemory(), nil
+		},
+		DriverSQLite: func(t *testing.T) (Backend, error) {
+			return NewSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+		},
+		DriverPostgres: func(t *testing.T) (Backend, error) {
+			uri := os.Getenv("FORWARDER_TEST_POSTGRES")
+			if uri == "" {
+				t.Skip("FORWARDER_TEST_POSTGRES is not set")
+			}
+			return NewPostgres(ctx, uri)
+		},
+		DriverMongo: func(t *testing.T) (Backend, error) {
+			uri := os.Getenv("FORWARDER_TEST_MONGO")
+			if uri == "" {
+				t.Skip("FORWARDER_TEST_MONGO is not set")
+			}
+			// Every run gets its own database so runs do not see each other's documents
+			m, err := NewMongo(ctx, uri, "forwarder_test_"+uuid.NewString()[:8])
+			if err == nil {
+				t.Cleanup(func() { _ = m.conn.Client.Database(m.conn.Name).Drop(ctx) })
+			}
+			return m, err
+		},
+	}
+
+	for _, driver := range []string{DriverMemory, DriverSQLite, DriverPostgres, DriverMongo} {
+		t.Run(driver, func(t *testing.T) {
+			backend, err := open[driver](t)
+			if err != nil {
+				t.Fatalf("could not open %v: %v", driver, err)
+			}
+			t.Cleanup(func() { _ = backend.Close(ctx) })
+
+			s := NewStore(backend)
+			if _, err := s.Migrate(ctx); err != nil {
+				t.Fatalf("could not migrate: %v", err)
+			}
+			if driver == DriverPostgres {
+				truncate(t, s)
+			}
+			fn(t, s)
+		})
+	}
+}
+
+// truncate empties the tables a shared Postgres database keeps between runs
+func truncate(t *testing.T, s *Store) {
+	t.Helper()
+	ctx := context.Background()
+	for _, err := range []error{
+		s.Payments.Delete(ctx, Where[Payment]()),
+		s.Transactions.Delete(ctx, Where[Transaction]()),
+		s.Idempotency.Delete(ctx, Where[IdempotencyKey]()),
+	} {
+		if err != nil && !errors.Is(err, types.ErrNotFound) {
+			t.Fatal(err)
+		}
+	}
+}
+
+func payment(id, status string, created uint64) *Payment {
+	return &Payment{ID: id, MerchantID: "m1", Status: status, Amount: 1, Address: "addr-" + id, CreatedAt: created}
+}
+
+func TestBackendInsertGet(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		p := payment("p1", PaymentPending, 100)
+		p.AmountReceived, p.FeeLamports = 0.5, 5000
+		if err := s.Payments.Insert(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.Payments.Get(ctx, Where(PaymentID.Eq("p1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got != *p {
+			t.Errorf("got %+v, want %+v", got, p)
+		}
+
+		if _, err := s.Payments.Get(ctx, Where(PaymentID.Eq("missing"))); !errors.Is(err, types.ErrNotFound) {
+			t.Errorf("missing payment: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestBackendUniqueKey(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		tx := &Transaction{Signature: "sig1", PaymentID: "p1", CreatedAt: 1}
+		if err := s.Transactions.Insert(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Transactions.Insert(ctx, tx); !errors.Is(err, types.ErrDuplicate) {
+			t.Errorf("second insert: got %v, want ErrDuplicate", err)
+		}
+	})
+}
+
+func TestBackendUpdate(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		if err := s.Payments.Insert(ctx, payment("p1", PaymentPaid, 100)); err != nil {
+			t.Fatal(err)
+		}
+
+		query := Where(PaymentID.Eq("p1"), PaymentStatus.Eq(PaymentPaid))
+		if err := s.Payments.Update(ctx, query, PaymentStatus.To(PaymentForwarded), PaymentFeeLamports.Add(5000)); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Payments.Update(ctx, Where(PaymentID.Eq("p1")), PaymentFeeLamports.Add(2500)); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := s.Payments.Get(ctx, Where(PaymentID.Eq("p1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != PaymentForwarded || got.FeeLamports != 7500 {
+			t.Errorf("got status %v and fee %v, want forwarded and 7500", got.Status, got.FeeLamports)
+		}
+
+		// The condition no longer holds
+		if err := s.Payments.Update(ctx, query, PaymentStatus.To(PaymentRefunded)); !errors.Is(err, types.ErrNotFound) {
+			t.Errorf("stale update: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestBackendClaim(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		if err := s.Payments.Insert(ctx, payment("p1", PaymentPaid, 100)); err != nil {
+			t.Fatal(err)
+		}
+
+		query := Where(PaymentID.Eq("p1"), PaymentStatus.Eq(PaymentPaid))
+		got, err := s.Payments.Claim(ctx, query, PaymentStatus.To(PaymentSending))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != "p1" || got.Status != PaymentSending || got.Address != "addr-p1" {
+			t.Errorf("claim returned %+v, want the whole payment after the change", got)
+		}
+
+		if _, err := s.Payments.Claim(ctx, query, PaymentStatus.To(PaymentSending)); !errors.Is(err, types.ErrNotFound) {
+			t.Errorf("second claim: got %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func TestBackendDeleteCount(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		for i, status := range []string{PaymentPending, PaymentPending, PaymentPaid} {
+			if err := s.Payments.Insert(ctx, payment(string(rune('a'+i)), status, uint64(i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		n, err := s.Payments.Count(ctx, Where(PaymentStatus.Eq(PaymentPending)))
+		if err != nil || n != 2 {
+			t.Fatalf("count: got %v, %v, want 2", n, err)
+		}
+
+		if err := s.Payments.Delete(ctx, Where(PaymentStatus.Eq(PaymentPending))); err != nil {
+			t.Fatal(err)
+		}
+		n, err = s.Payments.Count(ctx, Where[Payment]())
+		if err != nil || n != 1 {
+			t.Errorf("count after delete: got %v, %v, want 1", n, err)
+		}
+	})
+}
+
+func TestBackendExpire(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		now := uint64(time.Now().Unix())
+		for id, created := range map[string]uint64{"old": now - 7200, "new": now} {
+			if err := s.Idempotency.Insert(ctx, &IdempotencyKey{ID: id, CreatedAt: created}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		deleted, err := s.Idempotency.Expire(ctx, IdempotencyCreatedAt, time.Hour)
+		if err != nil || deleted != 1 {
+			t.Fatalf("expire: got %v, %v, want 1 deleted", deleted, err)
+		}
+		if _, err := s.Idempotency.Get(ctx, Where(IdempotencyID.Eq("new"))); err != nil {
+			t.Errorf("recent key: %v", err)
+		}
+	})
+}
+
+func TestBackendExpiresAt(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		ctx := context.Background()
+		expires := time.Now().Add(time.Hour).Truncate(time.Second)
+		for id, at := range map[string]time.Time{"expiring": expires, "kept": {}} {
+			if err := s.Idempotency.Insert(ctx, &IdempotencyKey{ID: id, ExpiresAt: at}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := s.Idempotency.Get(ctx, Where(IdempotencyID.Eq("expiring")))
+		if err != nil || !got.ExpiresAt.Equal(expires) {
+			t.Errorf("expiring key: got %v, %v, want %v", got, err, expires)
+		}
+		got, err = s.Idempotency.Get(ctx, Where(IdempotencyID.Eq("kept")))
+		if err != nil || !got.ExpiresAt.IsZero() {
+			t.Errorf("kept key: got %v, %v, want no expiry", got, err)
+		}
+	})
+}
+
+func TestMigrateAddsColumns(t *testing.T) {
+	ctx := context.Background()
+	backend, err := NewSQLite(ctx, filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = backend.Close(ctx) })
+
+	// The table as migration 4 created it, before expires_at was added
+	if _, err := backend.q.ExecContext(ctx, `CREATE TABLE "idempotency_keys" ("id" TEXT NOT NULL DEFAULT '', "merchant_id" TEXT NOT NULL DEFAULT '', "key" TEXT NOT NULL DEFAULT '', "request_hash" TEXT NOT NULL DEFAULT '', "status" BIGINT NOT NULL DEFAULT 0, "response" TEXT NOT NULL DEFAULT '', "created_at" BIGINT NOT NULL DEFAULT 0, PRIMARY KEY ("id"))`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.q.ExecContext(ctx, `INSERT INTO "idempotency_keys" ("id") VALUES ('old')`); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewStore(backend)
+	if _, err := s.Migrate(ctx); err != nil {
+		t.Fatalf("could not migrate: %v", err)
+	}
+
+	if got, err := s.Idempotency.Get(ctx, Where(IdempotencyID.Eq("old"))); err != nil || !got.ExpiresAt.IsZero() {
+		t.Errorf("existing key: got %v, %v, want it kept without an expiry", got, err)
+	}
+	if err := s.Idempotency.Insert(ctx, &IdempotencyKey{ID: "new", ExpiresAt: time.Now()}); err != nil {
+		t.Errorf("insert after adding expires_at: %v", err)
+	}
+}
@@ -2,34 +2,38 @@ package database
 
 import (
 	"context"
-	"log"
+	"fmt"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func NewConn(ctx context.Context) *Connection {
+func NewConn(ctx context.Context, uri, name string) (*Connection, error) {
 	options := options.Client().
-		ApplyURI(ConnectionURI).
+		ApplyURI(uri).
 		SetConnectTimeout(ConnectionTimeout)
 
 	client, err := mongo.Connect(ctx, options)
 	if err != nil {
-		log.Fatalf("Could not create mongo client, Error: %v", err.Error())
+		return nil, fmt.Errorf("could not create mongo client: %w", err)
 	}
 
 	if err = client.Ping(ctx, nil); err != nil {
-		log.Fatalf("Could not ping mongo client, Error: %v", err.Error())
+		_ = client.Disconnect(ctx)
+		return nil, fmt.Errorf("could not ping mongo client: %w", err)
 	}
-	return &Connection{Client: client, Collections: make(map[string]*mongo.Collection)}
+	return &Connection{Client: client, Name: name, Collections: make(map[string]*mongo.Collection)}, nil
 }
 
 func (c *Connection) NewCollection(col string) *mongo.Collection {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if _, ok := c.Collections[col]; ok {
 		return c.Collections[col]
 	}
 
-	collection := c.Client.Database(DatabaseName).Collection(col)
+	collection := c.Client.Database(c.Name).Collection(col)
 	c.Collections[col] = collection
 	return collection
 }
@@ -39,9 +43,12 @@ func (c *Connection) Get(col string) *mongo.Collection {
 	return c.NewCollection(col)
 }
 
+// Ping checks that the mongo server is reachable
+func (c *Connection) Ping(ctx context.Context) error {
+	return c.Client.Ping(ctx, nil)
+}
+
 // Close closes the mongo connection
-func (c *Connection) Close(ctx context.Context) {
-	if err := c.Client.Disconnect(ctx); err != nil {
-		log.Fatalf("Could not close mongo client, Error: %v", err.Error())
-	}
+func (c *Connection) Close(ctx context.Context) error {
+	return c.Client.Disconnect(ctx)
 }
@@ -0,0 +1,140 @@
+package database
+
+type (
+	// Field is a field of model T holding values of type V
+	Field[T, V any] struct {
+		name string
+	}
+
+	// Number is a numeric field that can also be incremented
+	Number[T any, V ~int | ~int64 | ~uint64 | ~float64] struct {
+		Field[T, V]
+	}
+
+	// Selector is any field of model T, used to sort and project
+	Selector[T any] interface {
+		field() string
+	}
+
+	// Cond matches documents of model T
+	Cond[T any] struct {
+		c Condition
+	}
+
+	// Change is a write to a field of model T
+	Change[T any] struct {
+		a Assignment
+	}
+)
+
+func (f Field[T, V]) field() string {
+	return f.name
+}
+
+// Eq matches documents whose field equals the value
+func (f Field[T, V]) Eq(v V) Cond[T] {
+	return Cond[T]{Condition{Field: f.name, Values: []any{v}}}
+}
+
+// In matches documents whose field equals any of the values
+func (f Field[T, V]) In(values ...V) Cond[T] {
+	c := Condition{Field: f.name, Values: make([]any, len(values))}
+	for i, v := range values {
+		c.Values[i] = v
+	}
+	return Cond[T]{c}
+}
+
+// To sets the field to the value
+func (f Field[T, V]) To(v V) Change[T] {
+	return Change[T]{Assignment{Field: f.name, Value: v}}
+}
+
+// Add adds the value to the field
+func (f Number[T, V]) Add(v V) Change[T] {
+	return Change[T]{Assignment{Field: f.name, Value: v, Add: true}}
+}
+
+var (
+	MerchantID                = Field[Merchant, string]{"id"}
+	MerchantName              = Field[Merchant, string]{"name"}
+	MerchantDisabled          = Field[Merchant, bool]{"disabled"}
+	MerchantCreatedAt         = Number[Merchant, uint64]{Field[Merchant, uint64]{"created_at"}}
+	MerchantNotifyCommitment  = Field[Merchant, string]{"notify_commitment"}
+	MerchantForwardCommitment = Field[Merchant, string]{"forward_commitment"}
+	MerchantClientCert        = Field[Merchant, string]{"client_cert"}
+
+	APIKeyID         = Field[APIKey, string]{"id"}
+	APIKeyMerchantID = Field[APIKey, string]{"merchant_id"}
+	APIKeyHash       = Field[APIKey, string]{"hash"}
+	APIKeyMode       = Field[APIKey, string]{"mode"}
+	APIKeyRevoked    = Field[APIKey, bool]{"revoked"}
+	APIKeyCreatedAt  = Number[APIKey, uint64]{Field[APIKey, uint64]{"created_at"}}
+	APIKeyExpiresAt  = Number[APIKey, uint64]{Field[APIKey, uint64]{"expires_at"}}
+
+	AdminID        = Field[Admin, string]{"id"}
+	AdminName      = Field[Admin, string]{"name"}
+	AdminRole      = Field[Admin, string]{"role"}
+	AdminHash      = Field[Admin, string]{"hash"}
+	AdminDisabled  = Field[Admin, bool]{"disabled"}
+	AdminCreatedAt = Number[Admin, uint64]{Field[Admin, uint64]{"created_at"}}
+
+	NonceAddress    = Field[NonceAccount, string]{"address"}
+	NonceAuthority  = Field[NonceAccount, string]{"authority"}
+	NoncePreparedID = Field[NonceAccount, string]{"prepared_id"}
+	NonceCreatedAt  = Number[NonceAccount, uint64]{Field[NonceAccount, uint64]{"created_at"}}
+
+	LookupTableAddress   = Field[LookupTable, string]{"address"}
+	LookupTableAuthority = Field[LookupTable, string]{"authority"}
+	LookupTableAddresses = Field[LookupTable, []string]{"addresses"}
+	LookupTableCreatedAt = Number[LookupTable, uint64]{Field[LookupTable, uint64]{"created_at"}}
+
+	PreparedID         = Field[PreparedTransaction, string]{"id"}
+	PreparedKind       = Field[PreparedTransaction, string]{"kind"}
+	PreparedPaymentID  = Field[PreparedTransaction, string]{"payment_id"}
+	PreparedNonce      = Field[PreparedTransaction, string]{"nonce"}
+	PreparedStatus     = Field[PreparedTransaction, string]{"status"}
+	PreparedPreparedBy = Field[PreparedTransaction, string]{"prepared_by"}
+	PreparedApprovedBy = Field[PreparedTransaction, string]{"approved_by"}
+	PreparedSignature  = Field[PreparedTransaction, string]{"signature"}
+	PreparedError      = Field[PreparedTransaction, string]{"error"}
+	PreparedCreatedAt  = Number[PreparedTransaction, uint64]{Field[PreparedTransaction, uint64]{"created_at"}}
+
+	TransactionSignature  = Field[Transaction, string]{"signature"}
+	TransactionPaymentID  = Field[Transaction, string]{"payment_id"}
+	TransactionAddress    = Field[Transaction, string]{"address"}
+	TransactionSender     = Field[Transaction, string]{"sender"}
+	TransactionAmount     = Number[Transaction, float64]{Field[Transaction, float64]{"amount"}}
+	TransactionCommitment = Field[Transaction, string]{"commitment"}
+	TransactionCreatedAt  = Number[Transaction, uint64]{Field[Transaction, uint64]{"created_at"}}
+
+	WebhookDeliveryID = Field[Webhook, string]{"delivery_id"}
+	WebhookPaymentID  = Field[Webhook, string]{"id"}
+	WebhookCommitment = Field[Webhook, string]{"commitment"}
+	WebhookTimeSent   = Number[Webhook, uint64]{Field[Webhook, uint64]{"time_sent"}}
+
+	IdempotencyID        = Field[IdempotencyKey, string]{"id"}
+	IdempotencyStatus    = Field[IdempotencyKey, int]{"status"}
+	IdempotencyResponse  = Field[IdempotencyKey, string]{"response"}
+	IdempotencyCreatedAt = Number[IdempotencyKey, uint64]{Field[IdempotencyKey, uint64]{"created_at"}}
+
+	SchemaVersionVersion = Field[SchemaVersion, int]{"version"}
+
+	PaymentID               = Field[Payment, string]{"id"}
+	PaymentMerchantID       = Field[Payment, string]{"merchant_id"}
+	PaymentMode             = Field[Payment, string]{"mode"}
+	PaymentStatus           = Field[Payment, string]{"status"}
+	PaymentAmount           = Number[Payment, float64]{Field[Payment, float64]{"amount"}}
+	PaymentAmountReceived   = Number[Payment, float64]{Field[Payment, float64]{"amount_received"}}
+	PaymentAddress          = Field[Payment, string]{"address"}
+	PaymentSender           = Field[Payment, string]{"sender"}
+	PaymentSignature        = Field[Payment, string]{"signature"}
+	PaymentCommitment       = Field[Payment, string]{"commitment"}
+	PaymentCursor           = Field[Payment, string]{"cursor"}
+	PaymentForwardSignature = Field[Payment, string]{"forward_signature"}
+	PaymentRefundSignature  = Field[Payment, string]{"refund_signature"}
+	PaymentFeeLamports      = Number[Payment, uint64]{Field[Payment, uint64]{"fee_lamports"}}
+	PaymentPriorityFee      = Number[Payment, uint64]{Field[Payment, uint64]{"priority_fee"}}
+	PaymentCreatedAt        = Number[Payment, uint64]{Field[Payment, uint64]{"created_at"}}
+	PaymentExpires          = Number[Payment, uint64]{Field[Payment, uint64]{"expires"}}
+)
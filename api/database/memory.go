@@ -0,0 +1,446 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Memory keeps every table in memory, it is meant for tests and local development
+// Documents are copied in and out so callers never share them with the store
+type Memory struct {
+	mu     sync.Mutex                  // Held by every call, and by Atomic for the whole of fn
+	tables map[string][]reflect.Value  // Pointers to documents in insertion order
+	unique map[string]map[string]Index // Unique indexes of each table by name
+}
+
+func NewMemory() *Memory {
+	return &Memory{tables: make(map[string][]reflect.Value), unique: make(map[string]map[string]Index)}
+}
+
+// memoryTx is the write log of a running Atomic call, undone in reverse if it fails
+type memoryTx struct {
+	m    *Memory
+	undo []func()
+}
+
+// memoryAtomic marks the context of a running Atomic call
+type memoryAtomic struct{}
+
+// lock takes the store lock unless ctx belongs to an Atomic call that holds it already
+// It returns the write log of that call, nil outside of one, and the release of the lock
+func (m *Memory) lock(ctx context.Context) (*memoryTx, func()) {
+	if tx, ok := ctx.Value(memoryAtomic{}).(*memoryTx); ok && tx.m == m {
+		return tx, func() {}
+	}
+	m.mu.Lock()
+	return nil, m.mu.Unlock
+}
+
+// record adds a step that undoes a write to the log, outside of an Atomic call there is none
+func (tx *memoryTx) record(undo func()) {
+	if tx != nil {
+		tx.undo = append(tx.undo, undo)
+	}
+}
+
+// clone deep copies a document through its bson encoding, doc is a pointer to the model
+func clone(sc *schema, doc any) (reflect.Value, error) {
+	encoded, err := bson.Marshal(doc)
+	if err != nil {
+		return reflect.Value{}, err
+	}
+
+	copied := reflect.New(sc.typ)
+	if err := bson.Unmarshal(encoded, copied.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return copied, nil
+}
+
+// number converts a numeric value to float64 so differently typed numbers compare equal
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+// compare orders two values of a field, numbers by value and strings lexically
+func compare(a, b any) int {
+	x, xok := number(reflect.ValueOf(a))
+	y, yok := number(reflect.ValueOf(b))
+	switch {
+	case xok && yok && x < y:
+		return -1
+	case xok && yok && x > y:
+		return 1
+	case xok && yok:
+		return 0
+	}
+
+	s, sok := a.(string)
+	t, tok := b.(string)
+	if sok && tok {
+		return strings.Compare(s, t)
+	}
+	return 0
+}
+
+func equal(field reflect.Value, v any) bool {
+	want := reflect.ValueOf(v)
+	if a, ok := number(field); ok {
+		b, ok := number(want)
+		return ok && a == b
+	}
+	return want.IsValid() && want.Type() == field.Type() && want.Comparable() && field.Interface() == v
+}
+
+func matches(sc *schema, doc reflect.Value, f Filter) (bool, error) {
+	for _, c := range f.Conditions {
+		field, ok := sc.field(doc, c.Field)
+		if !ok {
+			return false, fmt.Errorf("unknown field %v in %v", c.Field, sc.table)
+		}
+
+		found := false
+		for _, v := range c.Values {
+			found = found || equal(field, v)
+		}
+		if !found {
+			return false, nil
+		}
+	}
+
+	if f.After == nil {
+		return true, nil
+	}
+	return before(sc, f, sc.position(doc, f.Sort), f.After) > 0, nil
+}
+
+// before compares two positions in the order of the filter, negative when a comes first
+func before(sc *schema, f Filter, a, b *Position) int {
+	order := compare(b.Sort, a.Sort)
+	if order == 0 {
+		order = compare(b.Key, a.Key)
+	}
+	if f.Ascending {
+		return -order
+	}
+	return order
+}
+
+// sorted returns the documents matching the filter in its order
+func (m *Memory) sorted(sc *schema, f Filter) ([]reflect.Value, error) {
+	var found []reflect.Value
+	for _, doc := range m.tables[sc.table] {
+		ok, err := matches(sc, doc, f)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			found = append(found, doc)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return before(sc, f, sc.position(found[i], f.Sort), sc.position(found[j], f.Sort)) < 0
+	})
+	return found, nil
+}
+
+// project clears the fields a filter does not read
+func project(sc *schema, doc reflect.Value, f Filter) {
+	if len(f.Fields) == 0 {
+		return
+	}
+
+	wanted := make(map[string]bool, len(f.Fields))
+	for _, name := range f.Fields {
+		wanted[name] = true
+	}
+	for _, c := range sc.columns {
+		if !wanted[c.name] {
+			field := doc.Elem().Field(c.index)
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+}
+
+// apply writes the changes into a document
+func apply(sc *schema, doc reflect.Value, changes []Assignment) error {
+	for _, c := range changes {
+		field, ok := sc.field(doc, c.Field)
+		if !ok {
+			return fmt.Errorf("unknown field %v in %v", c.Field, sc.table)
+		}
+
+		value := reflect.ValueOf(c.Value)
+		if !c.Add {
+			if !value.IsValid() {
+				field.Set(reflect.Zero(field.Type()))
+				continue
+			}
+			if !value.CanConvert(field.Type()) {
+				return fmt.Errorf("cannot set %v of %v to %T", c.Field, sc.table, c.Value)
+			}
+			field.Set(value.Convert(field.Type()))
+			continue
+		}
+
+		delta, ok := number(value)
+		if !ok {
+			return fmt.Errorf("cannot add %T to %v of %v", c.Value, c.Field, sc.table)
+		}
+		switch field.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			field.SetInt(field.Int() + int64(delta))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			field.SetUint(uint64(int64(field.Uint()) + int64(delta)))
+		case reflect.Float32, reflect.Float64:
+			field.SetFloat(field.Float() + delta)
+		default:
+			return fmt.Errorf("cannot add to %v of %v", c.Field, sc.table)
+		}
+	}
+	return nil
+}
+
+// conflicts checks that no other document shares the fields of a unique index with doc
+func (m *Memory) conflicts(sc *schema, doc reflect.Value) error {
+	for _, index := range m.unique[sc.table] {
+		for _, other := range m.tables[sc.table] {
+			if other.Pointer() == doc.Pointer() {
+				continue
+			}
+
+			same := true
+			for _, f := range index.Fields {
+				a, _ := sc.field(doc, f)
+				b, _ := sc.field(other, f)
+				same = same && a.IsValid() && equal(a, b.Interface())
+			}
+			if same {
+				return types.ErrDuplicate
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Insert(ctx context.Context, table string, doc any) error {
+	sc := schemas[table]
+	copied, err := clone(sc, doc)
+	if err != nil {
+		return err
+	}
+
+	tx, unlock := m.lock(ctx)
+	defer unlock()
+
+	if err := m.conflicts(sc, copied); err != nil {
+		return err
+	}
+	previous := m.tables[table]
+	m.tables[table] = append(previous, copied)
+	tx.record(func() { m.tables[table] = previous })
+	return nil
+}
+
+func (m *Memory) Find(ctx context.Context, table string, f Filter, out any) error {
+	sc := schemas[table]
+
+	_, unlock := m.lock(ctx)
+	defer unlock()
+
+	found, err := m.sorted(sc, f)
+	if err != nil {
+		return err
+	}
+	if f.Limit > 0 && int64(len(found)) > f.Limit {
+		found = found[:f.Limit]
+	}
+
+	result := reflect.ValueOf(out).Elem()
+	for _, doc := range found {
+		copied, err := clone(sc, doc.Interface())
+		if err != nil {
+			return err
+		}
+		project(sc, copied, f)
+		result.Set(reflect.Append(result, copied))
+	}
+	return nil
+}
+
+func (m *Memory) Count(ctx context.Context, table string, f Filter) (int64, error) {
+	sc := schemas[table]
+
+	_, unlock := m.lock(ctx)
+	defer unlock()
+
+	var count int64
+	for _, doc := range m.tables[table] {
+		ok, err := matches(sc, doc, f)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Update changes the first match on a copy and swaps it in, so a failed update leaves the document untouched
+func (m *Memory) Update(ctx context.Context, table string, f Filter, changes []Assignment, out any) error {
+	sc := schemas[table]
+
+	tx, unlock := m.lock(ctx)
+	defer unlock()
+
+	found, err := m.sorted(sc, f)
+	if err != nil {
+		return err
+	}
+	if len(found) == 0 {
+		return types.ErrNotFound
+	}
+	doc := found[0]
+
+	updated, err := clone(sc, doc.Interface())
+	if err != nil {
+		return err
+	}
+	if err := apply(sc, updated, changes); err != nil {
+		return err
+	}
+
+	// Checked on the copy, standing in for the document it replaces
+	original := doc.Elem().Interface()
+	doc.Elem().Set(updated.Elem())
+	if err := m.conflicts(sc, doc); err != nil {
+		doc.Elem().Set(reflect.ValueOf(original))
+		return err
+	}
+	tx.record(func() { doc.Elem().Set(reflect.ValueOf(original)) })
+
+	if out == nil {
+		return nil
+	}
+
+	copied, err := clone(sc, doc.Interface())
+	if err != nil {
+		return err
+	}
+	project(sc, copied, f)
+	reflect.ValueOf(out).Elem().Set(copied.Elem())
+	return nil
+}
+
+func (m *Memory) Delete(ctx context.Context, table string, f Filter) error {
+	sc := schemas[table]
+
+	tx, unlock := m.lock(ctx)
+	defer unlock()
+
+	kept := make([]reflect.Value, 0, len(m.tables[table]))
+	for _, doc := range m.tables[table] {
+		ok, err := matches(sc, doc, f)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			kept = append(kept, doc)
+		}
+	}
+
+	previous := m.tables[table]
+	if len(previous) == len(kept) {
+		return types.ErrNotFound
+	}
+	m.tables[table] = kept
+	tx.record(func() { m.tables[table] = previous })
+	return nil
+}
+
+func (m *Memory) Expire(ctx context.Context, table, field string, before uint64) (int64, error) {
+	sc := schemas[table]
+
+	tx, unlock := m.lock(ctx)
+	defer unlock()
+
+	kept := make([]reflect.Value, 0, len(m.tables[table]))
+	for _, doc := range m.tables[table] {
+		v, ok := sc.field(doc, field)
+		if !ok {
+			return 0, fmt.Errorf("unknown field %v in %v", field, table)
+		}
+		if compare(v.Interface(), before) >= 0 {
+			kept = append(kept, doc)
+		}
+	}
+
+	previous := m.tables[table]
+	m.tables[table] = kept
+	tx.record(func() { m.tables[table] = previous })
+	return int64(len(previous) - len(kept)), nil
+}
+
+func (m *Memory) CreateTable(ctx context.Context, table string) error {
+	return m.CreateIndex(ctx, table, Index{Fields: []string{schemas[table].key}, Unique: true})
+}
+
+// CreateIndex only keeps unique indexes, every lookup scans the table anyway
+func (m *Memory) CreateIndex(ctx context.Context, table string, index Index) error {
+	if !index.Unique {
+		return nil
+	}
+
+	_, unlock := m.lock(ctx)
+	defer unlock()
+
+	if m.unique[table] == nil {
+		m.unique[table] = make(map[string]Index)
+	}
+	m.unique[table][index.name(table)] = index
+	return nil
+}
+
+// Atomic runs fn holding the store lock, so other calls wait for it and never see its writes half done
+// Writes made by fn are logged and undone in reverse if it fails, nothing else is touched
+func (m *Memory) Atomic(ctx context.Context, fn func(ctx context.Context, b Backend) error) error {
+	tx, unlock := m.lock(ctx)
+	defer unlock()
+	if tx != nil {
+		return fn(ctx, m)
+	}
+
+	tx = &memoryTx{m: m}
+	err := fn(context.WithValue(ctx, memoryAtomic{}, tx), m)
+	if err != nil {
+		for i := len(tx.undo) - 1; i >= 0; i-- {
+			tx.undo[i]()
+		}
+	}
+	return err
+}
+
+func (m *Memory) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *Memory) Close(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+type (
+	// Index speeds up queries on the fields of a table, in order
+	Index struct {
+		Fields []string
+		Unique bool
+		TTL    bool // Deletes a document once the date in its one field has passed, where the backend supports it
+	}
+
+	// Migration is one versioned change to the schema
+	// Every step must be safe to run again, a migration that fails halfway is retried whole
+	Migration struct {
+		Version int
+		Name    string
+		Up      func(ctx context.Context, b Backend) error
+	}
+)
+
+// name is the index name every backend uses, derived from its table and fields
+func (i Index) name(table string) string {
+	return table + "_" + strings.Join(i.Fields, "_")
+}
+
+// migrations are applied in order, new ones are appended and existing ones never change
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "move legacy webhooks out of transactions",
+		Up: func(ctx context.Context, b Backend) error {
+			// Before transactions were recorded, the transactions collection held the webhooks sent
+			// They have no signature and would break its unique index
+			if m, ok := b.(*Mongo); ok {
+				return m.moveLegacyWebhooks(ctx)
+			}
+			return nil
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create tables",
+		Up: createTables(
+			paymentsTable, transactionsTable, webhooksTable, merchantsTable, apiKeysTable,
+			adminsTable, noncesTable, lookupTablesTable, preparedTable,
+		),
+	},
+	{
+		Version: 3,
+		Name:    "add lookup indexes",
+		Up: createIndexes(map[string][]Index{
+			paymentsTable: {
+				{Fields: []string{"address"}},
+				{Fields: []string{"signature"}},
+				{Fields: []string{"status", "expires"}},
+				{Fields: []string{"merchant_id", "created_at"}},
+			},
+			transactionsTable: {
+				{Fields: []string{"payment_id"}},
+				{Fields: []string{"address"}},
+			},
+			webhooksTable: {
+				{Fields: []string{"id"}},
+				{Fields: []string{"time_sent"}}, // Pruned by the server, Mongo also has the TTL index on expires_at
+			},
+			apiKeysTable: {
+				{Fields: []string{"hash"}, Unique: true},
+				{Fields: []string{"merchant_id"}},
+			},
+			adminsTable: {
+				{Fields: []string{"hash"}, Unique: true},
+			},
+			noncesTable: {
+				{Fields: []string{"authority", "prepared_id"}},
+			},
+			lookupTablesTable: {
+				{Fields: []string{"authority"}},
+			},
+			preparedTable: {
+				{Fields: []string{"status", "created_at"}},
+				{Fields: []string{"payment_id"}},
+			},
+		}),
+	},
+	{
+		Version: 4,
+		Name:    "create idempotency keys",
+		Up: func(ctx context.Context, b Backend) error {
+			if err := createTables(idempotencyTable)(ctx, b); err != nil {
+				return err
+			}
+			return createIndexes(map[string][]Index{
+				idempotencyTable: {{Fields: []string{"created_at"}}}, // Pruned by the server like webhooks
+			})(ctx, b)
+		},
+	},
+	{
+		Version: 5,
+		Name:    "expire transient data with TTL indexes",
+		Up: func(ctx context.Context, b Backend) error {
+			// Adds the expires_at columns to existing SQL tables
+			if err := createTables(webhooksTable, idempotencyTable)(ctx, b); err != nil {
+				return err
+			}
+			// Mongo deletes expired documents itself, the SQL backends keep pruning by time_sent and created_at
+			return createIndexes(map[string][]Index{
+				webhooksTable:    {{Fields: []string{"expires_at"}, TTL: true}},
+				idempotencyTable: {{Fields: []string{"expires_at"}, TTL: true}},
+			})(ctx, b)
+		},
+	},
+	{
+		Version: 6,
+		Name:    "bind client certificates to merchants",
+		Up:      createTables(merchantsTable), // Adds the client_cert column to existing SQL tables
+	},
+}
+
+func createTables(tables ...string) func(ctx context.Context, b Backend) error {
+	return func(ctx context.Context, b Backend) error {
+		for _, table := range tables {
+			if err := b.CreateTable(ctx, table); err != nil {
+				return fmt.Errorf("could not create table %v: %w", table, err)
+			}
+		}
+		return nil
+	}
+}
+
+func createIndexes(indexes map[string][]Index) func(ctx context.Context, b Backend) error {
+	return func(ctx context.Context, b Backend) error {
+		for table, list := range indexes {
+			for _, index := range list {
+				if err := b.CreateIndex(ctx, table, index); err != nil {
+					return fmt.Errorf("could not create index %v: %w", index.name(table), err)
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// Pending returns the migrations that have not been applied yet
+func (s *Store) Pending(ctx context.Context) ([]Migration, error) {
+	if err := s.CreateTable(ctx, migrationsTable); err != nil {
+		return nil, fmt.Errorf("could not create table %v: %w", migrationsTable, err)
+	}
+
+	applied, err := s.Migrations.Find(ctx, Where[SchemaVersion]())
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(map[int]bool, len(applied))
+	for _, v := range applied {
+		done[v.Version] = true
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if !done[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration in order, records each one and returns those applied
+// Instances starting together may run the same migration, whichever records it second treats it as applied
+func (s *Store) Migrate(ctx context.Context) ([]Migration, error) {
+	pending, err := s.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range pending {
+		if err := m.Up(ctx, s.Backend); err != nil {
+			// It may have failed by racing another instance that has applied it by now
+			if applied, _ := s.applied(ctx, m.Version); applied {
+				continue
+			}
+			return pending[:i], fmt.Errorf("migration %v (%v) failed: %w", m.Version, m.Name, err)
+		}
+
+		v := &SchemaVersion{Version: m.Version, Name: m.Name, AppliedAt: uint64(time.Now().Unix())}
+		if err := s.Migrations.Insert(ctx, v); err != nil && !errors.Is(err, types.ErrDuplicate) {
+			return pending[:i], fmt.Errorf("could not record migration %v: %w", m.Version, err)
+		}
+	}
+	return pending, nil
+}
+
+// applied checks if a migration has been recorded
+func (s *Store) applied(ctx context.Context, version int) (bool, error) {
+	_, err := s.Migrations.Get(ctx, Where(SchemaVersionVersion.Eq(version)))
+	if errors.Is(err, types.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
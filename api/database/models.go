@@ -0,0 +1,187 @@
+package database
+
+import "time"
+
+const (
+	ModeLive = "live"
+	ModeTest = "test"
+
+	PaymentPending   = "pending"
+	PaymentSeen      = "seen" // Received but not yet at the merchant's forward commitment
+	PaymentPaid      = "paid"
+	PaymentSending   = "sending" // Claimed by a forward or refund that is being sent
+	PaymentForwarded = "forwarded"
+	PaymentRefunded  = "refunded"
+	PaymentExpired   = "expired"
+	PaymentHeld      = "held" // A prepared refund or payout is awaiting approval
+
+	PreparedPending   = "pending_approval"
+	PreparedConfirmed = "confirmed"
+	PreparedFailed    = "failed"
+	PreparedCancelled = "cancelled"
+
+	KindRefund = "refund"
+	KindPayout = "payout"
+
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleAdmin    = "admin"
+)
+
+type (
+	Merchant struct {
+		ID        string `json:"id" bson:"id"`
+		Name      string `json:"name" bson:"name"`
+		Disabled  bool   `json:"disabled" bson:"disabled"`
+		CreatedAt uint64 `json:"created_at" bson:"created_at"`
+
+		NotifyCommitment  string `json:"notify_commitment" bson:"notify_commitment"`   // First commitment level a webhook is sent at
+		ForwardCommitment string `json:"forward_commitment" bson:"forward_commitment"` // Commitment level funds are forwarded at
+		ClientCert        string `json:"client_cert" bson:"client_cert"`               // sha256 fingerprint of the only client certificate its keys are accepted with
+	}
+
+	// APIKey only ever stores the hash of a key, the raw key is shown once on creation
+	APIKey struct {
+		ID         string   `json:"id" bson:"id"`
+		MerchantID string   `json:"merchant_id" bson:"merchant_id"`
+		Prefix     string   `json:"prefix" bson:"prefix"`
+		Hash       string   `json:"-" bson:"hash"`
+		Mode       string   `json:"mode" bson:"mode"`
+		Scopes     []string `json:"scopes" bson:"scopes"`
+		RateLimit  int      `json:"ratelimit" bson:"ratelimit"` // Requests per minute, 0 uses the configured default
+		Revoked    bool     `json:"revoked" bson:"revoked"`
+		CreatedAt  uint64   `json:"created_at" bson:"created_at"`
+		ExpiresAt  uint64   `json:"expires_at" bson:"expires_at"` // 0 never expires, set when a key is rotated
+	}
+
+	// Admin is an operator account for the admin api, only the token hash is stored
+	Admin struct {
+		ID        string `json:"id" bson:"id"`
+		Name      string `json:"name" bson:"name"`
+		Role      string `json:"role" bson:"role"`
+		Hash      string `json:"-" bson:"hash"`
+		Disabled  bool   `json:"disabled" bson:"disabled"`
+		CreatedAt uint64 `json:"created_at" bson:"created_at"`
+	}
+
+	NonceAccount struct {
+		Address    string `json:"address" bson:"address"`
+		Authority  string `json:"authority" bson:"authority"`
+		PreparedID string `json:"prepared_id" bson:"prepared_id"` // Empty while the nonce is free
+		CreatedAt  uint64 `json:"created_at" bson:"created_at"`
+	}
+
+	// LookupTable is an address lookup table owned by the fee payer
+	LookupTable struct {
+		Address   string   `json:"address" bson:"address"`
+		Authority string   `json:"authority" bson:"authority"`
+		Addresses []string `json:"addresses" bson:"addresses"`
+		CreatedAt uint64   `json:"created_at" bson:"created_at"`
+	}
+
+	// PreparedTransaction is a signed durable transaction waiting for an admin to approve it
+	PreparedTransaction struct {
+		ID          string `json:"id" bson:"id"`
+		Kind        string `json:"kind" bson:"kind"`
+		PaymentID   string `json:"payment_id" bson:"payment_id"`
+		To          string `json:"to" bson:"to"`
+		Nonce       string `json:"nonce" bson:"nonce"`
+		Transaction string `json:"transaction" bson:"transaction"` // Base64 encoded
+		Status      string `json:"status" bson:"status"`
+		PreparedBy  string `json:"prepared_by" bson:"prepared_by"`
+		ApprovedBy  string `json:"approved_by" bson:"approved_by"`
+		Signature   string `json:"signature" bson:"signature"`
+		Error       string `json:"error" bson:"error"`
+		CreatedAt   uint64 `json:"created_at" bson:"created_at"`
+	}
+
+	// Transaction is a transfer into a payment's deposit address
+	Transaction struct {
+		Signature  string  `json:"signature" bson:"signature"`
+		PaymentID  string  `json:"payment_id" bson:"payment_id"`
+		Address    string  `json:"address" bson:"address"`
+		Sender     string  `json:"sender" bson:"sender"`
+		Amount     float64 `json:"amount" bson:"amount"`
+		Commitment string  `json:"commitment" bson:"commitment"` // processed, confirmed, finalized or dropped
+		CreatedAt  uint64  `json:"created_at" bson:"created_at"`
+	}
+
+	// Webhook is a notification sent to a merchant, it is stored as sent so it can be replayed
+	Webhook struct {
+		DeliveryID     string    `json:"delivery_id" bson:"delivery_id"` // Unique per notification, merchants can drop repeats by it
+		Success        bool      `json:"success" bson:"success"`
+		ID             string    `json:"id" bson:"id"`
+		Error          any       `json:"error" bson:"error"`
+		DesiredAmount  float64   `json:"desired_amount" bson:"desired_amount"`
+		AmountSent     float64   `json:"amount_sent" bson:"amount_sent"`
+		TransactionID  string    `json:"transaction_id" bson:"transaction_id"`
+		Address        string    `json:"address" bson:"address"`
+		TimeSent       uint64    `json:"time_sent" bson:"time_sent"`
+		PercentOfTotal float64   `json:"percent_of_total" bson:"percent_of_total"`
+		Commitment     string    `json:"commitment" bson:"commitment"`  // processed, confirmed, finalized or dropped
+		ExpiresAt      time.Time `json:"-" bson:"expires_at,omitempty"` // Removed by the TTL index once passed, unset while webhooks are kept forever
+	}
+
+	// IdempotencyKey stores the first response to a request, so a retry with the same key gets it again
+	IdempotencyKey struct {
+		ID          string    `json:"id" bson:"id"` // Merchant ID and key, keys are scoped per merchant
+		MerchantID  string    `json:"merchant_id" bson:"merchant_id"`
+		Key         string    `json:"key" bson:"key"`
+		RequestHash string    `json:"request_hash" bson:"request_hash"` // sha256 of the method, path and body
+		Status      int       `json:"status" bson:"status"`             // 0 while the first request is running
+		Response    string    `json:"response" bson:"response"`
+		CreatedAt   uint64    `json:"created_at" bson:"created_at"`
+		ExpiresAt   time.Time `json:"-" bson:"expires_at,omitempty"` // Removed by the TTL index once passed
+	}
+
+	// SchemaVersion records a migration that has been applied
+	SchemaVersion struct {
+		Version   int    `json:"version" bson:"version"`
+		Name      string `json:"name" bson:"name"`
+		AppliedAt uint64 `json:"applied_at" bson:"applied_at"`
+	}
+
+	Payment struct {
+		ID               string  `json:"id" bson:"id"`
+		MerchantID       string  `json:"merchant_id" bson:"merchant_id"`
+		Mode             string  `json:"mode" bson:"mode"`
+		Status           string  `json:"status" bson:"status"`
+		Amount           float64 `json:"amount" bson:"amount"`
+		AmountReceived   float64 `json:"amount_received" bson:"amount_received"`
+		CallbackURI      string  `json:"callback_uri" bson:"callback_uri"`
+		Address          string  `json:"address" bson:"address"`
+		Sender           string  `json:"sender" bson:"sender"`
+		Signature        string  `json:"signature" bson:"signature"`
+		Commitment       string  `json:"commitment" bson:"commitment"` // Highest commitment level the transaction reached
+		Cursor           string  `json:"-" bson:"cursor"`              // Newest signature seen by the poller
+		ForwardSignature string  `json:"forward_signature" bson:"forward_signature"`
+		RefundSignature  string  `json:"refund_signature" bson:"refund_signature"`
+		FeeLamports      uint64  `json:"fee_lamports" bson:"fee_lamports"` // Network fees spent moving this payment's funds
+		PriorityFee      uint64  `json:"priority_fee" bson:"priority_fee"` // Micro-lamports per compute unit paid by the last outgoing transaction
+		CreatedAt        uint64  `json:"created_at" bson:"created_at"`
+		Expires          uint64  `json:"expires" bson:"expires"`
+	}
+)
+
+// HasScope checks if the key has been granted the scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, v := range k.Scopes {
+		if v == scope {
+			return true
+		}
+	}
+	return false
+}
+
+var roleRanks = map[string]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+// ValidRole checks if the role exists
+func ValidRole(role string) bool {
+	_, ok := roleRanks[role]
+	return ok
+}
+
+// Can checks if the admin's role is at least the given role
+func (a *Admin) Can(role string) bool {
+	return !a.Disabled && roleRanks[a.Role] >= roleRanks[role]
+}
@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Mongo stores every table as a collection
+type Mongo struct {
+	conn         *Connection
+	transactions bool // Only replica sets and sharded clusters support transactions
+}
+
+// NewMongo connects to the named database of a mongo deployment
+func NewMongo(ctx context.Context, uri, name string) (*Mongo, error) {
+	conn, err := NewConn(ctx, uri, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var hello struct {
+		SetName string `bson:"setName"`
+		Msg     string `bson:"msg"`
+	}
+	m := &Mongo{conn: conn}
+	if err := conn.Client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello); err == nil {
+		m.transactions = hello.SetName != "" || hello.Msg == "isdbgrid"
+	}
+	return m, nil
+}
+
+// filter builds the mongo query of a filter, including the keyset condition of its position
+func (m *Mongo) filter(table string, f Filter) bson.M {
+	query, and := bson.M{}, bson.A{}
+	for _, c := range f.Conditions {
+		var match any = bson.M{"$in": c.Values}
+		if len(c.Values) == 1 {
+			match = c.Values[0]
+		}
+
+		// A field matched twice needs both conditions to hold
+		if _, ok := query[c.Field]; ok {
+			and = append(and, bson.M{c.Field: match})
+			continue
+		}
+		query[c.Field] = match
+	}
+	if len(and) > 0 {
+		query["$and"] = and
+	}
+
+	if f.After != nil {
+		op := "$lt"
+		if f.Ascending {
+			op = "$gt"
+		}
+
+		key := schemas[table].key
+		query["$or"] = bson.A{
+			bson.M{f.Sort: bson.M{op: f.After.Sort}},
+			bson.M{f.Sort: f.After.Sort, key: bson.M{op: f.After.Key}},
+		}
+	}
+	return query
+}
+
+func (m *Mongo) sort(table string, f Filter) bson.D {
+	dir := -1
+	if f.Ascending {
+		dir = 1
+	}
+	return bson.D{{Key: f.Sort, Value: dir}, {Key: schemas[table].key, Value: dir}}
+}
+
+func projection(f Filter) bson.M {
+	if len(f.Fields) == 0 {
+		return nil
+	}
+
+	fields := bson.M{}
+	for _, name := range f.Fields {
+		fields[name] = 1
+	}
+	return fields
+}
+
+func update(changes []Assignment) bson.M {
+	set, inc := bson.M{}, bson.M{}
+	for _, c := range changes {
+		if c.Add {
+			inc[c.Field] = c.Value
+		} else {
+			set[c.Field] = c.Value
+		}
+	}
+
+	result := bson.M{}
+	if len(set) > 0 {
+		result["$set"] = set
+	}
+	if len(inc) > 0 {
+		result["$inc"] = inc
+	}
+	return result
+}
+
+func (m *Mongo) Insert(ctx context.Context, table string, doc any) error {
+	_, err := m.conn.Get(table).InsertOne(ctx, doc)
+	return duplicateKey(err)
+}
+
+// duplicateKey turns a unique index violation into types.ErrDuplicate
+func duplicateKey(err error) error {
+	if mongo.IsDuplicateKeyError(err) {
+		return types.ErrDuplicate
+	}
+	return err
+}
+
+func (m *Mongo) Find(ctx context.Context, table string, f Filter, out any) error {
+	opts := options.Find().SetSort(m.sort(table, f))
+	if f.Limit > 0 {
+		opts.SetLimit(f.Limit)
+	}
+	if fields := projection(f); fields != nil {
+		opts.SetProjection(fields)
+	}
+
+	cursor, err := m.conn.Get(table).Find(ctx, m.filter(table, f), opts)
+	if err != nil {
+		return err
+	}
+	return cursor.All(ctx, out)
+}
+
+func (m *Mongo) Count(ctx context.Context, table string, f Filter) (int64, error) {
+	return m.conn.Get(table).CountDocuments(ctx, m.filter(table, f))
+}
+
+func (m *Mongo) Update(ctx context.Context, table string, f Filter, changes []Assignment, out any) error {
+	if out == nil {
+		result, err := m.conn.Get(table).UpdateOne(ctx, m.filter(table, f), update(changes))
+		if err != nil {
+			return duplicateKey(err)
+		}
+
+		if result.MatchedCount == 0 {
+			return types.ErrNotFound
+		}
+		return nil
+	}
+
+	opts := options.FindOneAndUpdate().
+		SetReturnDocument(options.After).
+		SetSort(m.sort(table, f))
+	if fields := projection(f); fields != nil {
+		opts.SetProjection(fields)
+	}
+
+	err := m.conn.Get(table).FindOneAndUpdate(ctx, m.filter(table, f), update(changes), opts).Decode(out)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return types.ErrNotFound
+	}
+	return duplicateKey(err)
+}
+
+func (m *Mongo) Delete(ctx context.Context, table string, f Filter) error {
+	result, err := m.conn.Get(table).DeleteMany(ctx, m.filter(table, f))
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
+
+func (m *Mongo) Expire(ctx context.Context, table, field string, before uint64) (int64, error) {
+	result, err := m.conn.Get(table).DeleteMany(ctx, bson.M{field: bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (m *Mongo) CreateTable(ctx context.Context, table string) error {
+	err := m.conn.Client.Database(m.conn.Name).CreateCollection(ctx, table)
+	var cmdErr mongo.CommandError
+	if err != nil && !(errors.As(err, &cmdErr) && cmdErr.Code == NamespaceExists) {
+		return err
+	}
+
+	// Documents are identified by their own key rather than _id
+	return m.CreateIndex(ctx, table, Index{Fields: []string{schemas[table].key}, Unique: true})
+}
+
+func (m *Mongo) CreateIndex(ctx context.Context, table string, index Index) error {
+	keys := bson.D{}
+	for _, field := range index.Fields {
+		keys = append(keys, bson.E{Key: field, Value: 1})
+	}
+
+	opts := options.Index().SetName(index.name(table)).SetUnique(index.Unique)
+	if index.TTL {
+		// Documents are removed once the date in the field has passed
+		opts.SetExpireAfterSeconds(0)
+	}
+
+	_, err := m.conn.Get(table).Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	return err
+}
+
+// moveLegacyWebhooks moves the webhooks stored in the transactions collection to the webhooks collection
+// Their _id becomes the delivery ID, so a move that was interrupted can be run again
+func (m *Mongo) moveLegacyWebhooks(ctx context.Context) error {
+	legacy := bson.M{"signature": bson.M{"$exists": false}}
+	cursor, err := m.conn.Get(transactionsTable).Find(ctx, legacy)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc bson.M
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if id, ok := doc["_id"].(primitive.ObjectID); ok {
+			doc["delivery_id"] = id.Hex()
+		}
+
+		_, err := m.conn.Get(webhooksTable).InsertOne(ctx, doc)
+		if err != nil && !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	_, err = m.conn.Get(transactionsTable).DeleteMany(ctx, legacy)
+	return err
+}
+
+// Atomic runs fn in a transaction when the deployment supports them
+// A standalone server runs fn as is, unique indexes still reject repeated writes but a failure halfway is not undone
+func (m *Mongo) Atomic(ctx context.Context, fn func(ctx context.Context, b Backend) error) error {
+	if !m.transactions || mongo.SessionFromContext(ctx) != nil {
+		return fn(ctx, m)
+	}
+
+	return m.conn.Client.UseSession(ctx, func(sc mongo.SessionContext) error {
+		_, err := sc.WithTransaction(sc, func(sc mongo.SessionContext) (any, error) {
+			return nil, fn(sc, m)
+		})
+		return err
+	})
+}
+
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.conn.Ping(ctx)
+}
+
+func (m *Mongo) Close(ctx context.Context) error {
+	return m.conn.Close(ctx)
+}
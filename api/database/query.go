@@ -0,0 +1,148 @@
+package database
+
+import (
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+type (
+	// Condition matches documents whose field equals any of the values
+	Condition struct {
+		Field  string
+		Values []any
+	}
+
+	// Assignment sets a field, or adds to it
+	Assignment struct {
+		Field string
+		Value any
+		Add   bool
+	}
+
+	// Position is where a page ended, the sort value and key of its last document
+	Position struct {
+		Sort any `json:"s"`
+		Key  any `json:"k"`
+	}
+
+	// Filter is a query as the backends see it
+	// Documents are ordered by the sort field and then the key, in the same direction
+	Filter struct {
+		Conditions []Condition
+		Sort       string // Empty uses the table's sort field
+		Ascending  bool
+		Limit      int64     // 0 reads every match
+		After      *Position // Only documents after this position
+		Fields     []string  // Projection, empty reads every field
+	}
+
+	// Query selects documents of model T
+	Query[T any] struct {
+		filter Filter
+		cursor string
+	}
+
+	// Page is a slice of a listing and the cursor that continues it
+	Page[T any] struct {
+		Items []*T   `json:"items"`
+		Next  string `json:"next,omitempty"` // Empty on the last page
+	}
+)
+
+// Where selects the documents matching every condition, newest first
+func Where[T any](conds ...Cond[T]) *Query[T] {
+	q := &Query[T]{}
+	for _, c := range conds {
+		q.filter.Conditions = append(q.filter.Conditions, c.c)
+	}
+	return q
+}
+
+// And adds conditions to the query
+func (q *Query[T]) And(conds ...Cond[T]) *Query[T] {
+	for _, c := range conds {
+		q.filter.Conditions = append(q.filter.Conditions, c.c)
+	}
+	return q
+}
+
+// Limit caps how many documents are read
+func (q *Query[T]) Limit(n int64) *Query[T] {
+	q.filter.Limit = n
+	return q
+}
+
+// OrderBy sorts by a field instead of the table's default, ties are broken by the key
+func (q *Query[T]) OrderBy(field Selector[T], ascending bool) *Query[T] {
+	q.filter.Sort = field.field()
+	q.filter.Ascending = ascending
+	return q
+}
+
+// After continues a listing from the cursor of a previous page
+func (q *Query[T]) After(cursor string) *Query[T] {
+	q.cursor = cursor
+	return q
+}
+
+// Only reads the given fields, the rest are left zero
+func (q *Query[T]) Only(fields ...Selector[T]) *Query[T] {
+	for _, f := range fields {
+		q.filter.Fields = append(q.filter.Fields, f.field())
+	}
+	return q
+}
+
+// resolve fills in the defaults of the table and decodes the cursor
+func (q *Query[T]) resolve(sc *schema) (Filter, error) {
+	f := q.filter
+	if f.Sort == "" {
+		f.Sort = sc.sort
+	}
+
+	// The cursor needs the sort field and key of every document
+	if len(f.Fields) > 0 {
+		f.Fields = append(append([]string{}, f.Fields...), f.Sort, sc.key)
+	}
+
+	if q.cursor != "" {
+		pos, err := decodeCursor(sc, f.Sort, q.cursor)
+		if err != nil {
+			return Filter{}, err
+		}
+		f.After = pos
+	}
+	return f, nil
+}
+
+func encodeCursor(pos *Position) string {
+	encoded, _ := json.Marshal(pos)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeCursor reads a cursor back into values of the sort field and key's types
+func decodeCursor(sc *schema, sort, cursor string) (*Position, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, types.ErrInvalidCursor
+	}
+
+	var encoded struct {
+		Sort json.RawMessage `json:"s"`
+		Key  json.RawMessage `json:"k"`
+	}
+	if err := json.Unmarshal(raw, &encoded); err != nil {
+		return nil, types.ErrInvalidCursor
+	}
+
+	pos := &Position{}
+	if pos.Sort, err = sc.decode(sort, encoded.Sort); err != nil {
+		return nil, types.ErrInvalidCursor
+	}
+	if pos.Key, err = sc.decode(sc.key, encoded.Key); err != nil {
+		return nil, types.ErrInvalidCursor
+	}
+	return pos, nil
+}
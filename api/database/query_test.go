@@ -0,0 +1,125 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+func seed(t *testing.T, s *Store) {
+	t.Helper()
+	ctx := context.Background()
+	for _, p := range []*Payment{
+		payment("a", PaymentPending, 10),
+		payment("b", PaymentPaid, 30),
+		payment("c", PaymentPending, 20),
+		payment("d", PaymentForwarded, 40),
+		payment("e", PaymentPending, 20), // Same created_at as c, the key breaks the tie
+	} {
+		if err := s.Payments.Insert(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func ids(payments []*Payment) []string {
+	out := make([]string, 0, len(payments))
+	for _, p := range payments {
+		out = append(out, p.ID)
+	}
+	return out
+}
+
+func TestQueryConditions(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		seed(t, s)
+		ctx := context.Background()
+
+		tests := []struct {
+			name  string
+			query *Query[Payment]
+			want  []string
+		}{
+			{"every", Where[Payment](), []string{"d", "b", "e", "c", "a"}},
+			{"eq", Where(PaymentStatus.Eq(PaymentPending)), []string{"e", "c", "a"}},
+			{"in", Where(PaymentStatus.In(PaymentPaid, PaymentForwarded)), []string{"d", "b"}},
+			{"and", Where(PaymentStatus.Eq(PaymentPending)).And(PaymentCreatedAt.Eq(20)), []string{"e", "c"}},
+			{"none", Where(PaymentStatus.Eq(PaymentRefunded)), []string{}},
+			{"ascending", Where(PaymentStatus.Eq(PaymentPending)).OrderBy(PaymentCreatedAt, true), []string{"a", "c", "e"}},
+			{"limit", Where[Payment]().Limit(2), []string{"d", "b"}},
+		}
+
+		for _, tt := range tests {
+			got, err := s.Payments.Find(ctx, tt.query)
+			if err != nil {
+				t.Fatalf("%v: %v", tt.name, err)
+			}
+			if !slices.Equal(ids(got), tt.want) {
+				t.Errorf("%v: got %v, want %v", tt.name, ids(got), tt.want)
+			}
+		}
+	})
+}
+
+func TestQueryOnly(t *testing.T) {
+	eachBackend(t, func(t *testing.T, s *Store) {
+		seed(t, s)
+
+		got, err := s.Payments.Get(context.Background(), Where(PaymentID.Eq("b")).Only(PaymentStatus))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != PaymentPaid || got.Address != "" || got.MerchantID != "" {
+			t.Errorf("got %+v, want only the status set", got)
+		}
+	})
+}
+
+func TestQueryPages(t *testing.T) {
+	for _, ascending := range []bool{false, true} {
+		eachBackend(t, func(t *testing.T, s *Store) {
+			seed(t, s)
+			ctx := context.Background()
+
+			want := []string{"d", "b", "e", "c", "a"}
+			if ascending {
+				want = []string{"a", "c", "e", "b", "d"}
+			}
+
+			var listed []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(want) {
+					t.Fatal("pages never end")
+				}
+
+				page, err := s.Payments.Page(ctx, Where[Payment]().OrderBy(PaymentCreatedAt, ascending).Limit(2).After(cursor))
+				if err != nil {
+					t.Fatal(err)
+				}
+				listed = append(listed, ids(page.Items)...)
+				if page.Next == "" {
+					break
+				}
+				cursor = page.Next
+			}
+
+			if !slices.Equal(listed, want) {
+				t.Errorf("ascending %v: listed %v, want %v", ascending, listed, want)
+			}
+		})
+	}
+}
+
+func TestQueryInvalidCursor(t *testing.T) {
+	s := NewStore(NewMemory())
+	for _, cursor := range []string{"not base64!", "bm90IGpzb24", encodeCursor(&Position{Sort: "text", Key: "a"})} {
+		_, err := s.Payments.Page(context.Background(), Where[Payment]().After(cursor))
+		if !errors.Is(err, types.ErrInvalidCursor) {
+			t.Errorf("cursor %q: got %v, want ErrInvalidCursor", cursor, err)
+		}
+	}
+}
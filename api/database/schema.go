@@ -0,0 +1,96 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// column is a field of a model, named by its bson tag
+type column struct {
+	name  string
+	index int
+	kind  reflect.Kind
+	typ   reflect.Type
+}
+
+// schema describes how a model is stored by every backend
+type schema struct {
+	table   string
+	key     string // Field that uniquely identifies a document
+	sort    string // Field listings are ordered by, newest first
+	typ     reflect.Type
+	columns []column
+	named   map[string]column
+}
+
+// schemas are the tables of every model, registered by the store
+var schemas = make(map[string]*schema)
+
+func register[T any](table, key, sort string) string {
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	s := &schema{table: table, key: key, sort: sort, typ: typ, named: make(map[string]column)}
+
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("bson"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		col := column{name: name, index: i, kind: typ.Field(i).Type.Kind(), typ: typ.Field(i).Type}
+		s.columns = append(s.columns, col)
+		s.named[name] = col
+	}
+
+	schemas[table] = s
+	return table
+}
+
+// field returns the value of a document's field, doc is a pointer to the model
+func (s *schema) field(doc reflect.Value, name string) (reflect.Value, bool) {
+	col, ok := s.named[name]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	return doc.Elem().Field(col.index), true
+}
+
+// encoded reports if a column is stored as JSON by the SQL backends
+func (c column) encoded() bool {
+	return c.kind == reflect.Slice || c.kind == reflect.Map || c.kind == reflect.Interface
+}
+
+// timestamp reports if a column holds a time, the SQL backends store it as unix seconds and Mongo as a date
+func (c column) timestamp() bool {
+	return c.typ == timeType
+}
+
+// decode reads a JSON value into the type of a field
+func (s *schema) decode(name string, raw []byte) (any, error) {
+	col, ok := s.named[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown field %v in %v", name, s.table)
+	}
+
+	v := reflect.New(s.typ.Field(col.index).Type)
+	if err := json.Unmarshal(raw, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// position returns where a document sits in a listing sorted by the field
+func (s *schema) position(doc reflect.Value, sort string) *Position {
+	pos := &Position{}
+	if v, ok := s.field(doc, sort); ok {
+		pos.Sort = v.Interface()
+	}
+	if v, ok := s.field(doc, s.key); ok {
+		pos.Key = v.Interface()
+	}
+	return pos
+}
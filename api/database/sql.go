@@ -0,0 +1,585 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+const (
+	DefaultSQLitePath = "forwarder.db"
+	SQLiteBusyTimeout = 5000    // Milliseconds a write waits on a locked database
+	UniqueViolation   = "23505" // Postgres error code of a unique constraint violation
+)
+
+// dialect is what differs between the SQL databases
+type dialect struct {
+	driver string
+	bind   func(n int) string // Placeholder of the nth argument
+	lock   string             // Appended to the select that picks the row Claim updates
+}
+
+var (
+	sqliteDialect = dialect{
+		driver: "sqlite",
+		bind:   func(int) string { return "?" },
+	}
+
+	postgresDialect = dialect{
+		driver: "pgx",
+		bind:   func(n int) string { return "$" + strconv.Itoa(n) },
+		lock:   " FOR UPDATE SKIP LOCKED",
+	}
+)
+
+// querier runs statements on the database or inside a transaction
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// SQL stores every table as a relational table with a column per field
+// Slices and other nested values are stored as JSON
+type SQL struct {
+	db      *sql.DB
+	q       querier // db, or the transaction of Atomic
+	dialect dialect
+}
+
+// NewSQLite opens an embedded SQLite database for single node deployments
+func NewSQLite(ctx context.Context, path string) (*SQL, error) {
+	if path == "" {
+		path = DefaultSQLitePath
+	}
+	if !strings.Contains(path, "?") {
+		path += fmt.Sprintf("?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)", SQLiteBusyTimeout)
+	}
+	return openSQL(ctx, sqliteDialect, path)
+}
+
+// NewPostgres connects to a Postgres database
+func NewPostgres(ctx context.Context, uri string) (*SQL, error) {
+	if uri == "" {
+		return nil, types.ErrMissingURI
+	}
+	return openSQL(ctx, postgresDialect, uri)
+}
+
+func openSQL(ctx context.Context, d dialect, dsn string) (*SQL, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows one writer, a single connection avoids busy errors between our own queries
+	if d.driver == sqliteDialect.driver {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQL{db: db, q: db, dialect: d}, nil
+}
+
+func quote(name string) string {
+	return `"` + name + `"`
+}
+
+// columnType is the SQL type of a field, the names are understood by both SQLite and Postgres
+func columnType(c column) string {
+	switch {
+	case c.encoded():
+		return "TEXT NOT NULL DEFAULT 'null'"
+	case c.kind == reflect.String:
+		return "TEXT NOT NULL DEFAULT ''"
+	case c.kind == reflect.Bool:
+		return "BOOLEAN NOT NULL DEFAULT FALSE"
+	case c.kind == reflect.Float32 || c.kind == reflect.Float64:
+		return "DOUBLE PRECISION NOT NULL DEFAULT 0"
+	default:
+		return "BIGINT NOT NULL DEFAULT 0"
+	}
+}
+
+// value converts a field to what the driver stores in its column
+func value(c column, v any) (any, error) {
+	if c.encoded() {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	}
+	if t, ok := v.(time.Time); ok {
+		if t.IsZero() {
+			return int64(0), nil
+		}
+		return t.Unix(), nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(rv.Uint()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	}
+	return v, nil
+}
+
+// statement builds a query with numbered placeholders
+type statement struct {
+	dialect dialect
+	sql     strings.Builder
+	args    []any
+}
+
+func (st *statement) write(parts ...string) *statement {
+	for _, p := range parts {
+		st.sql.WriteString(p)
+	}
+	return st
+}
+
+func (st *statement) bind(v any) string {
+	st.args = append(st.args, v)
+	return st.dialect.bind(len(st.args))
+}
+
+// condition writes a field matching any of the values
+func (st *statement) condition(sc *schema, c Condition) (string, error) {
+	col, ok := sc.named[c.Field]
+	if !ok {
+		return "", fmt.Errorf("unknown field %v in %v", c.Field, sc.table)
+	}
+
+	if len(c.Values) == 0 {
+		return "1 = 0", nil
+	}
+
+	binds := make([]string, len(c.Values))
+	for i, v := range c.Values {
+		arg, err := value(col, v)
+		if err != nil {
+			return "", err
+		}
+		binds[i] = st.bind(arg)
+	}
+
+	if len(binds) == 1 {
+		return quote(c.Field) + " = " + binds[0], nil
+	}
+	return quote(c.Field) + " IN (" + strings.Join(binds, ", ") + ")", nil
+}
+
+// where writes the conditions of a filter and the keyset condition of its position
+func (st *statement) where(sc *schema, f Filter) error {
+	conds := make([]string, 0, len(f.Conditions)+1)
+	for _, c := range f.Conditions {
+		cond, err := st.condition(sc, c)
+		if err != nil {
+			return err
+		}
+		conds = append(conds, cond)
+	}
+
+	if f.After != nil {
+		sortCol, ok := sc.named[f.Sort]
+		if !ok {
+			return fmt.Errorf("unknown field %v in %v", f.Sort, sc.table)
+		}
+
+		op := " < "
+		if f.Ascending {
+			op = " > "
+		}
+
+		after, err := value(sortCol, f.After.Sort)
+		if err != nil {
+			return err
+		}
+		sort, key := quote(f.Sort), quote(sc.key)
+		conds = append(conds, "("+sort+op+st.bind(after)+" OR ("+sort+" = "+st.bind(after)+" AND "+key+op+st.bind(f.After.Key)+"))")
+	}
+
+	if len(conds) > 0 {
+		st.write(" WHERE ", strings.Join(conds, " AND "))
+	}
+	return nil
+}
+
+// order writes the order of a filter, ties are broken by the key
+func (st *statement) order(sc *schema, f Filter) {
+	dir := " DESC"
+	if f.Ascending {
+		dir = " ASC"
+	}
+	st.write(" ORDER BY ", quote(f.Sort), dir, ", ", quote(sc.key), dir)
+}
+
+// set writes the assignments of an update, additions add to the current value
+func (st *statement) set(sc *schema, changes []Assignment) error {
+	assigns := make([]string, 0, len(changes))
+	for _, c := range changes {
+		col, ok := sc.named[c.Field]
+		if !ok {
+			return fmt.Errorf("unknown field %v in %v", c.Field, sc.table)
+		}
+
+		arg, err := value(col, c.Value)
+		if err != nil {
+			return err
+		}
+
+		if c.Add {
+			assigns = append(assigns, quote(c.Field)+" = "+quote(c.Field)+" + "+st.bind(arg))
+		} else {
+			assigns = append(assigns, quote(c.Field)+" = "+st.bind(arg))
+		}
+	}
+	st.write(" SET ", strings.Join(assigns, ", "))
+	return nil
+}
+
+// first writes the conditions matching only the first row of a filter
+// The conditions are repeated outside the subselect so a row changed by a concurrent update is rechecked
+func (st *statement) first(sc *schema, f Filter, lock string) error {
+	if err := st.where(sc, f); err != nil {
+		return err
+	}
+	if len(f.Conditions) == 0 && f.After == nil {
+		st.write(" WHERE ")
+	} else {
+		st.write(" AND ")
+	}
+
+	st.write(quote(sc.key), " IN (SELECT ", quote(sc.key), " FROM ", quote(sc.table))
+	if err := st.where(sc, f); err != nil {
+		return err
+	}
+	st.order(sc, f)
+	st.write(" LIMIT 1", lock, ")")
+	return nil
+}
+
+func (s *SQL) statement() *statement {
+	return &statement{dialect: s.dialect}
+}
+
+// selected returns the columns a filter reads, every column unless it has a projection
+func selected(sc *schema, f Filter) []column {
+	if len(f.Fields) == 0 {
+		return sc.columns
+	}
+
+	wanted := make(map[string]bool, len(f.Fields))
+	for _, name := range f.Fields {
+		wanted[name] = true
+	}
+
+	cols := make([]column, 0, len(wanted))
+	for _, c := range sc.columns {
+		if wanted[c.name] {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+func columnNames(cols []column) string {
+	names := make([]string, len(cols))
+	for i, c := range cols {
+		names[i] = quote(c.name)
+	}
+	return strings.Join(names, ", ")
+}
+
+// scan reads a row of the given columns into a new document
+func scan(sc *schema, cols []column, rows *sql.Rows) (reflect.Value, error) {
+	doc := reflect.New(sc.typ)
+	dest := make([]any, len(cols))
+	encoded := make([]string, len(cols))
+	unix := make([]int64, len(cols))
+
+	for i, c := range cols {
+		switch {
+		case c.encoded():
+			dest[i] = &encoded[i]
+		case c.timestamp():
+			dest[i] = &unix[i]
+		default:
+			dest[i] = doc.Elem().Field(c.index).Addr().Interface()
+		}
+	}
+
+	if err := rows.Scan(dest...); err != nil {
+		return reflect.Value{}, err
+	}
+
+	for i, c := range cols {
+		switch {
+		case c.encoded():
+			if err := json.Unmarshal([]byte(encoded[i]), doc.Elem().Field(c.index).Addr().Interface()); err != nil {
+				return reflect.Value{}, err
+			}
+		case c.timestamp() && unix[i] != 0:
+			doc.Elem().Field(c.index).Set(reflect.ValueOf(time.Unix(unix[i], 0)))
+		}
+	}
+	return doc, nil
+}
+
+func (s *SQL) Insert(ctx context.Context, table string, doc any) error {
+	sc := schemas[table]
+	rv := reflect.ValueOf(doc)
+
+	st := s.statement()
+	binds := make([]string, len(sc.columns))
+	for i, c := range sc.columns {
+		arg, err := value(c, rv.Elem().Field(c.index).Interface())
+		if err != nil {
+			return err
+		}
+		binds[i] = st.bind(arg)
+	}
+
+	st.write("INSERT INTO ", quote(table), " (", columnNames(sc.columns), ") VALUES (", strings.Join(binds, ", "), ")")
+	_, err := s.q.ExecContext(ctx, st.sql.String(), st.args...)
+	return duplicate(err)
+}
+
+func (s *SQL) Find(ctx context.Context, table string, f Filter, out any) error {
+	sc := schemas[table]
+	cols := selected(sc, f)
+
+	st := s.statement().write("SELECT ", columnNames(cols), " FROM ", quote(table))
+	if err := st.where(sc, f); err != nil {
+		return err
+	}
+	st.order(sc, f)
+	if f.Limit > 0 {
+		st.write(" LIMIT ", strconv.FormatInt(f.Limit, 10))
+	}
+
+	rows, err := s.q.QueryContext(ctx, st.sql.String(), st.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	found := reflect.ValueOf(out).Elem()
+	for rows.Next() {
+		doc, err := scan(sc, cols, rows)
+		if err != nil {
+			return err
+		}
+		found.Set(reflect.Append(found, doc))
+	}
+	return rows.Err()
+}
+
+func (s *SQL) Count(ctx context.Context, table string, f Filter) (int64, error) {
+	st := s.statement().write("SELECT COUNT(*) FROM ", quote(table))
+	if err := st.where(schemas[table], f); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	err := s.q.QueryRowContext(ctx, st.sql.String(), st.args...).Scan(&count)
+	return count, err
+}
+
+func (s *SQL) Update(ctx context.Context, table string, f Filter, changes []Assignment, out any) error {
+	sc := schemas[table]
+
+	// Only claims lock, a plain update skipping a locked row would wrongly report it missing
+	lock := ""
+	if out != nil {
+		lock = s.dialect.lock
+	}
+
+	st := s.statement().write("UPDATE ", quote(table))
+	if err := st.set(sc, changes); err != nil {
+		return err
+	}
+	if err := st.first(sc, f, lock); err != nil {
+		return err
+	}
+
+	if out == nil {
+		result, err := s.q.ExecContext(ctx, st.sql.String(), st.args...)
+		if err != nil {
+			return duplicate(err)
+		}
+		return affected(result)
+	}
+
+	cols := selected(sc, f)
+	st.write(" RETURNING ", columnNames(cols))
+
+	rows, err := s.q.QueryContext(ctx, st.sql.String(), st.args...)
+	if err != nil {
+		return duplicate(err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return duplicate(err)
+		}
+		return types.ErrNotFound
+	}
+
+	doc, err := scan(sc, cols, rows)
+	if err != nil {
+		return err
+	}
+	reflect.ValueOf(out).Elem().Set(doc.Elem())
+	return nil
+}
+
+func (s *SQL) Delete(ctx context.Context, table string, f Filter) error {
+	st := s.statement().write("DELETE FROM ", quote(table))
+	if err := st.where(schemas[table], f); err != nil {
+		return err
+	}
+
+	result, err := s.q.ExecContext(ctx, st.sql.String(), st.args...)
+	if err != nil {
+		return err
+	}
+	return affected(result)
+}
+
+// duplicate turns a unique constraint violation into types.ErrDuplicate
+func duplicate(err error) error {
+	var liteErr *sqlite.Error
+	if errors.As(err, &liteErr) && (liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || liteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY) {
+		return types.ErrDuplicate
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == UniqueViolation {
+		return types.ErrDuplicate
+	}
+	return err
+}
+
+func affected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return types.ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQL) Expire(ctx context.Context, table, field string, before uint64) (int64, error) {
+	st := s.statement().write("DELETE FROM ", quote(table), " WHERE ", quote(field), " < ")
+	st.write(st.bind(int64(before)))
+
+	result, err := s.q.ExecContext(ctx, st.sql.String(), st.args...)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQL) CreateTable(ctx context.Context, table string) error {
+	sc := schemas[table]
+	defs := make([]string, 0, len(sc.columns)+1)
+	for _, c := range sc.columns {
+		defs = append(defs, quote(c.name)+" "+columnType(c))
+	}
+	defs = append(defs, "PRIMARY KEY ("+quote(sc.key)+")")
+
+	if _, err := s.q.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v)", quote(table), strings.Join(defs, ", "))); err != nil {
+		return err
+	}
+	return s.addColumns(ctx, sc)
+}
+
+// addColumns adds the fields a model gained since its table was created
+func (s *SQL) addColumns(ctx context.Context, sc *schema) error {
+	rows, err := s.q.QueryContext(ctx, fmt.Sprintf("SELECT * FROM %v WHERE 1 = 0", quote(sc.table)))
+	if err != nil {
+		return err
+	}
+	names, err := rows.Columns()
+	_ = rows.Close()
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		existing[name] = true
+	}
+	for _, c := range sc.columns {
+		if existing[c.name] {
+			continue
+		}
+		if _, err := s.q.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %v ADD COLUMN %v %v", quote(sc.table), quote(c.name), columnType(c))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SQL) CreateIndex(ctx context.Context, table string, index Index) error {
+	fields := make([]string, len(index.Fields))
+	for i, f := range index.Fields {
+		fields[i] = quote(f)
+	}
+
+	unique := ""
+	if index.Unique {
+		unique = "UNIQUE "
+	}
+
+	// Rows are not deleted by the database, TTL indexes are plain indexes the server prunes by
+	stmt := fmt.Sprintf("CREATE %vINDEX IF NOT EXISTS %v ON %v (%v)", unique, quote(index.name(table)), quote(table), strings.Join(fields, ", "))
+	_, err := s.q.ExecContext(ctx, stmt)
+	return err
+}
+
+// Atomic runs fn in a transaction, committed when fn returns nil and rolled back otherwise
+func (s *SQL) Atomic(ctx context.Context, fn func(ctx context.Context, b Backend) error) error {
+	if _, ok := s.q.(*sql.Tx); ok {
+		return fn(ctx, s)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(ctx, &SQL{db: s.db, q: tx, dialect: s.dialect}); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQL) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+func (s *SQL) Close(ctx context.Context) error {
+	return s.db.Close()
+}
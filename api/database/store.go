@@ -0,0 +1,234 @@
+package database
+
+import (
+	"context"
+	"reflect"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+const (
+	DriverMongo    = "mongo"
+	DriverSQLite   = "sqlite"
+	DriverPostgres = "postgres"
+	DriverMemory   = "memory"
+)
+
+type (
+	// Backend stores documents in tables, every implementation matches, orders and updates them the same way
+	// Update and Delete return types.ErrNotFound when nothing matched the filter
+	// Insert and Update return types.ErrDuplicate when a unique index is violated
+	Backend interface {
+		Insert(ctx context.Context, table string, doc any) error
+		Find(ctx context.Context, table string, filter Filter, out any) error // out is a *[]*T
+		Count(ctx context.Context, table string, filter Filter) (int64, error)
+		Update(ctx context.Context, table string, filter Filter, changes []Assignment, out any) error // Changes one match, atomically reading it back into out unless out is nil
+		Delete(ctx context.Context, table string, filter Filter) error                                // Deletes every match
+		Expire(ctx context.Context, table, field string, before uint64) (int64, error)                // Deletes documents whose field is below before
+		CreateTable(ctx context.Context, table string) error                                          // Creates the table with a unique key, if it does not exist
+		CreateIndex(ctx context.Context, table string, index Index) error                             // Creates the index, if it does not exist
+		Atomic(ctx context.Context, fn func(ctx context.Context, b Backend) error) error              // Runs fn so its writes apply together or not at all
+		Ping(ctx context.Context) error
+		Close(ctx context.Context) error
+	}
+
+	// Repository reads and writes one model
+	Repository[T any] struct {
+		backend Backend
+		table   string
+	}
+
+	// Store holds the repository of every model on one backend
+	Store struct {
+		Backend
+
+		Payments     *Repository[Payment]
+		Transactions *Repository[Transaction]
+		Webhooks     *Repository[Webhook]
+		Merchants    *Repository[Merchant]
+		APIKeys      *Repository[APIKey]
+		Admins       *Repository[Admin]
+		Nonces       *Repository[NonceAccount]
+		LookupTables *Repository[LookupTable]
+		Prepared     *Repository[PreparedTransaction]
+		Migrations   *Repository[SchemaVersion]
+		Idempotency  *Repository[IdempotencyKey]
+	}
+)
+
+var (
+	paymentsTable     = register[Payment]("payments", "id", "created_at")
+	transactionsTable = register[Transaction]("transactions", "signature", "created_at")
+	webhooksTable     = register[Webhook]("webhooks", "delivery_id", "time_sent")
+	merchantsTable    = register[Merchant]("merchants", "id", "created_at")
+	apiKeysTable      = register[APIKey]("api_keys", "id", "created_at")
+	adminsTable       = register[Admin]("admins", "id", "created_at")
+	noncesTable       = register[NonceAccount]("nonces", "address", "created_at")
+	lookupTablesTable = register[LookupTable]("lookup_tables", "address", "created_at")
+	preparedTable     = register[PreparedTransaction]("prepared", "id", "created_at")
+	migrationsTable   = register[SchemaVersion]("migrations", "version", "applied_at")
+	idempotencyTable  = register[IdempotencyKey]("idempotency_keys", "id", "created_at")
+)
+
+// NewStore creates the repositories on top of a backend
+func NewStore(backend Backend) *Store {
+	return &Store{
+		Backend:      backend,
+		Payments:     &Repository[Payment]{backend, paymentsTable},
+		Transactions: &Repository[Transaction]{backend, transactionsTable},
+		Webhooks:     &Repository[Webhook]{backend, webhooksTable},
+		Merchants:    &Repository[Merchant]{backend, merchantsTable},
+		APIKeys:      &Repository[APIKey]{backend, apiKeysTable},
+		Admins:       &Repository[Admin]{backend, adminsTable},
+		Nonces:       &Repository[NonceAccount]{backend, noncesTable},
+		LookupTables: &Repository[LookupTable]{backend, lookupTablesTable},
+		Prepared:     &Repository[PreparedTransaction]{backend, preparedTable},
+		Migrations:   &Repository[SchemaVersion]{backend, migrationsTable},
+		Idempotency:  &Repository[IdempotencyKey]{backend, idempotencyTable},
+	}
+}
+
+// Open connects to the backend chosen in the config
+func Open(ctx context.Context, cfg config.Database) (*Store, error) {
+	var (
+		backend Backend
+		err     error
+	)
+	switch cfg.Driver {
+	case "", DriverMongo:
+		backend, err = NewMongo(ctx, cfg.URI, cfg.Name)
+	case DriverSQLite:
+		backend, err = NewSQLite(ctx, cfg.URI)
+	case DriverPostgres:
+		backend, err = NewPostgres(ctx, cfg.URI)
+	case DriverMemory:
+		backend = NewMemory()
+	default:
+		return nil, types.ErrUnknownDriver
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(backend), nil
+}
+
+// Atomic runs fn on a store whose writes apply together or not at all, fn must only use that store and ctx
+func (s *Store) Atomic(ctx context.Context, fn func(ctx context.Context, tx *Store) error) error {
+	return s.Backend.Atomic(ctx, func(ctx context.Context, b Backend) error {
+		return fn(ctx, NewStore(b))
+	})
+}
+
+// Insert writes a new document
+func (r *Repository[T]) Insert(ctx context.Context, v *T) error {
+	return r.backend.Insert(ctx, r.table, v)
+}
+
+// Get returns the first document the query selects
+func (r *Repository[T]) Get(ctx context.Context, q *Query[T]) (*T, error) {
+	filter, err := q.resolve(schemas[r.table])
+	if err != nil {
+		return nil, err
+	}
+	filter.Limit = 1
+
+	found := make([]*T, 0, 1)
+	if err := r.backend.Find(ctx, r.table, filter, &found); err != nil {
+		return nil, err
+	}
+
+	if len(found) == 0 {
+		return nil, types.ErrNotFound
+	}
+	return found[0], nil
+}
+
+// Find returns every document the query selects
+func (r *Repository[T]) Find(ctx context.Context, q *Query[T]) ([]*T, error) {
+	filter, err := q.resolve(schemas[r.table])
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]*T, 0)
+	if err := r.backend.Find(ctx, r.table, filter, &found); err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// Page returns up to the query's limit of documents and the cursor of the next page
+func (r *Repository[T]) Page(ctx context.Context, q *Query[T]) (*Page[T], error) {
+	sc := schemas[r.table]
+	filter, err := q.resolve(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// One extra document tells if there is another page
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+
+	found := make([]*T, 0)
+	if err := r.backend.Find(ctx, r.table, filter, &found); err != nil {
+		return nil, err
+	}
+
+	page := &Page[T]{Items: found}
+	if limit > 0 && int64(len(found)) > limit {
+		page.Items = found[:limit]
+		page.Next = encodeCursor(sc.position(reflect.ValueOf(page.Items[limit-1]), filter.Sort))
+	}
+	return page, nil
+}
+
+// Count counts the documents matching the query's conditions
+func (r *Repository[T]) Count(ctx context.Context, q *Query[T]) (int64, error) {
+	return r.backend.Count(ctx, r.table, Filter{Conditions: q.filter.Conditions})
+}
+
+// Update applies the changes to one document matching the query
+func (r *Repository[T]) Update(ctx context.Context, q *Query[T], changes ...Change[T]) error {
+	filter, err := q.resolve(schemas[r.table])
+	if err != nil {
+		return err
+	}
+	return r.backend.Update(ctx, r.table, filter, assignments(changes), nil)
+}
+
+// Claim atomically applies the changes to the first document the query selects and returns it
+func (r *Repository[T]) Claim(ctx context.Context, q *Query[T], changes ...Change[T]) (*T, error) {
+	filter, err := q.resolve(schemas[r.table])
+	if err != nil {
+		return nil, err
+	}
+
+	v := new(T)
+	if err := r.backend.Update(ctx, r.table, filter, assignments(changes), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Delete deletes every document matching the query's conditions
+func (r *Repository[T]) Delete(ctx context.Context, q *Query[T]) error {
+	return r.backend.Delete(ctx, r.table, Filter{Conditions: q.filter.Conditions})
+}
+
+// Expire deletes the documents whose field, a unix time, is older than the age
+func (r *Repository[T]) Expire(ctx context.Context, field Number[T, uint64], age time.Duration) (int64, error) {
+	return r.backend.Expire(ctx, r.table, field.field(), uint64(time.Now().Add(-age).Unix()))
+}
+
+func assignments[T any](changes []Change[T]) []Assignment {
+	result := make([]Assignment, len(changes))
+	for i, c := range changes {
+		result[i] = c.a
+	}
+	return result
+}
@@ -1,24 +1,23 @@
 package database
 
 import (
+	"sync"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
 var (
-	ConnectionURI = "mongodb://localhost:27017"
-	DatabaseName  = "PaymentProcessor"
 	ConnectionTimeout = time.Second * 90
+	NamespaceExists   = int32(48) // Mongo error code of creating a collection that exists
 )
 
 type (
 	Connection struct {
 		Client      *mongo.Client
+		Name        string // Database the collections live in
 		Collections map[string]*mongo.Collection
-	}
 
-	Production interface {
-		
+		mu sync.Mutex
 	}
 )
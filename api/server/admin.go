@@ -0,0 +1,576 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/types"
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// CreateAdmin creates an admin account, returning its raw token
+func (c *Client) CreateAdmin(ctx context.Context, name, role string) (string, *database.Admin, error) {
+	if !database.ValidRole(role) {
+		return "", nil, types.ErrInvalidRole
+	}
+
+	raw, err := GenerateKey(AdminMode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	admin := &database.Admin{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Role:      role,
+		Hash:      HashKey(raw),
+		CreatedAt: uint64(time.Now().Unix()),
+	}
+
+	if err := c.db.Admins.Insert(ctx, admin); err != nil {
+		return "", nil, err
+	}
+	return raw, admin, nil
+}
+
+// AuthenticateAdmin resolves the admin token of the request
+func (c *Client) AuthenticateAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := extractKey(r)
+		if !strings.HasPrefix(raw, KeyPrefix+AdminMode+"_") {
+			types.Unauthorized(w, types.ErrInvalidAPIKey)
+			return
+		}
+
+		admin, err := c.db.Admins.Get(r.Context(), database.Where(database.AdminHash.Eq(HashKey(raw))))
+		if err != nil || admin.Disabled {
+			types.Unauthorized(w, types.ErrInvalidAPIKey)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), adminKey, admin)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRole rejects admins below the given role
+func RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			admin := AdminFrom(r.Context())
+			if admin == nil || !admin.Can(role) {
+				types.Forbidden(w, types.ErrInsufficientScope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// AdminFrom returns the admin of an authenticated admin request
+func AdminFrom(ctx context.Context) *database.Admin {
+	v, _ := ctx.Value(adminKey).(*database.Admin)
+	return v
+}
+
+func (c *Client) adminRoutes(r chi.Router) {
+	r.Use(c.AuthenticateAdmin)
+
+	r.Group(func(r chi.Router) {
+		r.Use(RequireRole(database.RoleViewer))
+		r.Get("/health", c.AdminHealth)
+		r.Get("/payments", c.AdminListPayments)
+		r.Get("/payments/{id}", c.AdminGetPayment)
+		r.Get("/wallets", c.AdminListWallets)
+		r.Get("/wallets/{address}", c.AdminGetWallet)
+		r.Get("/merchants", c.AdminListMerchants)
+		r.Get("/merchants/{id}/keys", c.AdminListKeys)
+		r.Get("/nonces", c.AdminListNonces)
+		r.Get("/prepared", c.AdminListPrepared)
+		r.Get("/prepared/{id}", c.AdminGetPrepared)
+		r.Get("/lookup-table", c.AdminGetLookupTable)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(RequireRole(database.RoleOperator))
+		r.Post("/payments/{id}/recheck", c.AdminRecheckPayment)
+		r.Post("/payments/{id}/webhooks/replay", c.AdminReplayWebhooks)
+		r.Post("/sweep", c.AdminSweep)
+		r.Post("/payments/{id}/prepare", c.AdminPrepare)
+	})
+
+	r.Group(func(r chi.Router) {
+		r.Use(RequireRole(database.RoleAdmin))
+		r.Post("/merchants", c.AdminCreateMerchant)
+		r.Post("/merchants/{id}/disable", c.AdminDisableMerchant)
+		r.Post("/merchants/{id}/settings", c.AdminMerchantSettings)
+		r.Post("/merchants/{id}/cert", c.AdminBindCert)
+		r.Post("/merchants/{id}/keys", c.AdminIssueKey)
+		r.Post("/keys/{id}/revoke", c.AdminRevokeKey)
+		r.Post("/admins", c.AdminCreateAdmin)
+		r.Post("/nonces", c.AdminCreateNonce)
+		r.Post("/prepared/{id}/approve", c.AdminApprove)
+		r.Post("/prepared/{id}/cancel", c.AdminCancel)
+		r.Post("/lookup-table", c.AdminSyncLookupTable)
+		r.Post("/config/reload", c.AdminReloadConfig)
+	})
+}
+
+// paymentFilters are the query parameters payments can be listed by
+var paymentFilters = map[string]database.Field[database.Payment, string]{
+	"status":      database.PaymentStatus,
+	"merchant_id": database.PaymentMerchantID,
+	"address":     database.PaymentAddress,
+	"mode":        database.PaymentMode,
+	"signature":   database.PaymentSignature,
+	"sender":      database.PaymentSender,
+}
+
+// paginate applies the limit, cursor and order of a listing request
+// Listings are newest first unless order=asc is given
+func paginate[T any](r *http.Request, q *database.Query[T], createdAt database.Selector[T]) *database.Query[T] {
+	params := r.URL.Query()
+
+	limit, _ := strconv.ParseInt(params.Get("limit"), 10, 64)
+	if limit <= 0 || limit > MaxAdminListLimit {
+		limit = MaxAdminListLimit
+	}
+	return q.Limit(limit).After(params.Get("cursor")).OrderBy(createdAt, params.Get("order") == "asc")
+}
+
+// Recheck looks the deposit address up on chain and processes anything that was missed
+func (c *Client) Recheck(ctx context.Context, p *database.Payment) error {
+	if c.network(p.Mode) == nil {
+		return types.ErrTestModeDisabled
+	}
+
+	switch p.Status {
+	case database.PaymentPaid:
+		// Another forward or refund claimed it since it was read, its funds are on their way
+		if err := c.ForwardFunds(ctx, p); !errors.Is(err, types.ErrNotPaid) {
+			return err
+		}
+		return nil
+	case database.PaymentPending, database.PaymentExpired:
+	default:
+		return nil
+	}
+
+	sigs, err := c.network(p.Mode).Signatures(ctx, p.Address, RecheckDepth, rpc.CommitmentConfirmed)
+	if err != nil {
+		return err
+	}
+
+	// A signature that could not be decided does not stop the others from being looked at
+	var failed error
+	for _, sig := range sigs {
+		done, err := c.ProcessSignature(ctx, p, sig)
+		if done {
+			return nil
+		}
+		if err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// Sweep forwards the funds of every paid payment that has not been forwarded yet
+func (c *Client) Sweep(ctx context.Context) (*SweepResponse, error) {
+	if c.sweeper != nil {
+		return c.sweeper.FlushAll(ctx)
+	}
+
+	payments, err := c.db.Payments.Find(ctx, database.Where(database.PaymentStatus.Eq(database.PaymentPaid)))
+	if err != nil {
+		return nil, err
+	}
+	return c.sweepPayments(ctx, payments), nil
+}
+
+// ReplayWebhooks resends every webhook recorded for a payment
+func (c *Client) ReplayWebhooks(ctx context.Context, p *database.Payment) (int, error) {
+	sent, err := c.db.Webhooks.Find(ctx, database.Where(database.WebhookPaymentID.Eq(p.ID)).OrderBy(database.WebhookTimeSent, true))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, v := range sent {
+		SendWebhook(p.CallbackURI, v)
+	}
+	return len(sent), nil
+}
+
+// PaymentDetails returns a payment with the transactions credited to it and the webhooks sent for it
+func (c *Client) PaymentDetails(ctx context.Context, id string) (*AdminPaymentResponse, error) {
+	payment, err := c.db.Payments.Get(ctx, database.Where(database.PaymentID.Eq(id)))
+	if err != nil {
+		return nil, err
+	}
+
+	transactions, err := c.db.Transactions.Find(ctx, database.Where(database.TransactionPaymentID.Eq(payment.ID)))
+	if err != nil {
+		return nil, err
+	}
+
+	webhooks, err := c.db.Webhooks.Find(ctx, database.Where(database.WebhookPaymentID.Eq(payment.ID)))
+	if err != nil {
+		return nil, err
+	}
+	return &AdminPaymentResponse{Payment: payment, Transactions: transactions, Webhooks: webhooks}, nil
+}
+
+// Wallets returns every deposit wallet on disk with its balance and payment
+func (c *Client) Wallets(ctx context.Context) ([]*WalletResponse, error) {
+	entries, err := os.ReadDir("wal")
+	if err != nil {
+		return nil, err
+	}
+
+	wallets := make([]*WalletResponse, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".dat") {
+			continue
+		}
+		wallets = append(wallets, c.walletInfo(ctx, strings.TrimSuffix(e.Name(), ".dat")))
+	}
+	return wallets, nil
+}
+
+func (c *Client) adminPayment(r *http.Request) (*database.Payment, error) {
+	return c.db.Payments.Get(r.Context(), database.Where(database.PaymentID.Eq(chi.URLParam(r, "id"))))
+}
+
+func (c *Client) AdminHealth(w http.ResponseWriter, r *http.Request) {
+	response := &HealthResponse{Success: true, Database: "ok"}
+	if err := c.db.Ping(r.Context()); err != nil {
+		response.Success = false
+		response.Database = err.Error()
+	}
+
+	health, err := c.sol.Health(r.Context())
+	if err != nil {
+		response.Success = false
+		health = err.Error()
+	}
+	response.Solana = health
+	response.Endpoints = c.sol.Endpoints()
+	response.Watcher = c.sol.WatcherStats()
+
+	if slot, err := c.sol.Slot(r.Context()); err == nil {
+		response.Slot = slot
+	}
+
+	if response.FeePayer = c.sol.FeePayer(); response.FeePayer != "" {
+		if bal, err := c.sol.WalletBalance(r.Context(), response.FeePayer); err == nil {
+			response.FeePayerBalance, _ = bal.Float64()
+		}
+	}
+
+	if pending, err := c.db.Payments.Count(r.Context(), database.Where(database.PaymentStatus.Eq(database.PaymentPending))); err == nil {
+		response.PendingPayments = pending
+	}
+	SendJSON(w, response)
+}
+
+// PaymentQuery matches the payments whose fields equal the filters, keyed like the query parameters of GET /admin/payments
+// Unknown keys and empty values are ignored
+func PaymentQuery(filters map[string]string) *database.Query[database.Payment] {
+	query := database.Where[database.Payment]()
+	for param, field := range paymentFilters {
+		if v := filters[param]; v != "" {
+			query.And(field.Eq(v))
+		}
+	}
+	return query
+}
+
+// ListPayments returns a page of the payments matching the query
+func (c *Client) ListPayments(ctx context.Context, query *database.Query[database.Payment]) (*database.Page[database.Payment], error) {
+	return c.db.Payments.Page(ctx, query)
+}
+
+func (c *Client) AdminListPayments(w http.ResponseWriter, r *http.Request) {
+	filters := make(map[string]string)
+	for param := range paymentFilters {
+		filters[param] = r.URL.Query().Get(param)
+	}
+
+	page, err := c.ListPayments(r.Context(), paginate(r, PaymentQuery(filters), database.PaymentCreatedAt))
+	if errors.Is(err, types.ErrInvalidCursor) {
+		types.BadRequest(w, err)
+		return
+	}
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, page)
+}
+
+func (c *Client) AdminGetPayment(w http.ResponseWriter, r *http.Request) {
+	response, err := c.PaymentDetails(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, types.ErrNotFound) {
+		types.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, response)
+}
+
+func (c *Client) AdminRecheckPayment(w http.ResponseWriter, r *http.Request) {
+	payment, err := c.adminPayment(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	if err := c.Recheck(r.Context(), payment); err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+
+	if payment, err = c.adminPayment(r); err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, payment)
+}
+
+func (c *Client) AdminReplayWebhooks(w http.ResponseWriter, r *http.Request) {
+	payment, err := c.adminPayment(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	count, err := c.ReplayWebhooks(r.Context(), payment)
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, &ReplayResponse{Success: true, Sent: count})
+}
+
+func (c *Client) AdminSweep(w http.ResponseWriter, r *http.Request) {
+	response, err := c.Sweep(r.Context())
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, response)
+}
+
+func (c *Client) walletInfo(ctx context.Context, address string) *WalletResponse {
+	response := &WalletResponse{Address: address}
+
+	// A wallet of a test payment holds funds on the test network
+	network := c.sol
+	if p, err := c.db.Payments.Get(ctx, database.Where(database.PaymentAddress.Eq(address))); err == nil {
+		response.PaymentID = p.ID
+		response.Status = p.Status
+		if network = c.network(p.Mode); network == nil {
+			response.Error = types.ErrTestModeDisabled.Error()
+			return response
+		}
+	}
+
+	bal, err := network.WalletBalance(ctx, address)
+	if err != nil {
+		response.Error = err.Error()
+		return response
+	}
+	response.Balance, _ = bal.Float64()
+	return response
+}
+
+func (c *Client) AdminListWallets(w http.ResponseWriter, r *http.Request) {
+	wallets, err := c.Wallets(r.Context())
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, wallets)
+}
+
+func (c *Client) AdminGetWallet(w http.ResponseWriter, r *http.Request) {
+	address := chi.URLParam(r, "address")
+	if _, err := sol.PublicKeyFromBase58(address); err != nil {
+		types.BadRequest(w, types.ErrInvalidAddress)
+		return
+	}
+	SendJSON(w, c.walletInfo(r.Context(), address))
+}
+
+func (c *Client) AdminListMerchants(w http.ResponseWriter, r *http.Request) {
+	merchants, err := c.db.Merchants.Find(r.Context(), database.Where[database.Merchant]())
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, merchants)
+}
+
+func (c *Client) AdminCreateMerchant(w http.ResponseWriter, r *http.Request) {
+	var body *AdminMerchantBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		types.BadRequest(w, types.ErrInvalidName)
+		return
+	}
+
+	merchant, keys, err := c.CreateMerchant(r.Context(), body.Name)
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, &AdminMerchantResponse{Success: true, Merchant: merchant, Keys: keys})
+}
+
+func (c *Client) AdminDisableMerchant(w http.ResponseWriter, r *http.Request) {
+	err := c.db.Merchants.Update(r.Context(), database.Where(database.MerchantID.Eq(chi.URLParam(r, "id"))), database.MerchantDisabled.To(true))
+	if errors.Is(err, types.ErrNotFound) {
+		types.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, &SuccessResponse{Success: true})
+}
+
+// AdminBindCert binds the client certificate a merchant's backend presents, its keys are refused with any other
+func (c *Client) AdminBindCert(w http.ResponseWriter, r *http.Request) {
+	var body *AdminCertBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	fingerprint := ""
+	if strings.TrimSpace(body.Certificate) != "" {
+		var err error
+		if fingerprint, err = parseFingerprint(body.Certificate); err != nil {
+			types.BadRequest(w, err)
+			return
+		}
+	}
+
+	query := database.Where(database.MerchantID.Eq(chi.URLParam(r, "id")))
+	err := c.db.Merchants.Update(r.Context(), query, database.MerchantClientCert.To(fingerprint))
+	if errors.Is(err, types.ErrNotFound) {
+		types.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+
+	merchant, err := c.db.Merchants.Get(r.Context(), query)
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, merchant)
+}
+
+func (c *Client) AdminListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := c.db.APIKeys.Find(r.Context(), database.Where(database.APIKeyMerchantID.Eq(chi.URLParam(r, "id"))))
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, keys)
+}
+
+func (c *Client) AdminIssueKey(w http.ResponseWriter, r *http.Request) {
+	var body *AdminKeyBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	if body.Mode != database.ModeLive && body.Mode != database.ModeTest {
+		types.BadRequest(w, types.ErrInvalidMode)
+		return
+	}
+	if c.network(body.Mode) == nil {
+		types.BadRequest(w, types.ErrTestModeDisabled)
+		return
+	}
+
+	if len(body.Scopes) == 0 {
+		body.Scopes = AllScopes
+	}
+
+	for _, scope := range body.Scopes {
+		if scope != ScopeCreate && scope != ScopeRead && scope != ScopeRefund {
+			types.BadRequest(w, types.ErrInvalidScope)
+			return
+		}
+	}
+
+	merchantID := chi.URLParam(r, "id")
+	if _, err := c.db.Merchants.Get(r.Context(), database.Where(database.MerchantID.Eq(merchantID))); err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	raw, key, err := c.IssueKey(r.Context(), merchantID, body.Mode, body.Scopes, body.RateLimit)
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, &KeyRotateResponse{Success: true, Key: raw, Info: key})
+}
+
+func (c *Client) AdminRevokeKey(w http.ResponseWriter, r *http.Request) {
+	err := c.db.APIKeys.Update(r.Context(), database.Where(database.APIKeyID.Eq(chi.URLParam(r, "id"))), database.APIKeyRevoked.To(true))
+	if errors.Is(err, types.ErrNotFound) {
+		types.NotFound(w, err)
+		return
+	}
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, &SuccessResponse{Success: true})
+}
+
+func (c *Client) AdminCreateAdmin(w http.ResponseWriter, r *http.Request) {
+	var body *AdminAccountBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	if strings.TrimSpace(body.Name) == "" {
+		types.BadRequest(w, types.ErrInvalidName)
+		return
+	}
+
+	raw, admin, err := c.CreateAdmin(r.Context(), body.Name, body.Role)
+	if err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+	SendJSON(w, &AdminAccountResponse{Success: true, Token: raw, Admin: admin})
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go"
+	"github.com/go-chi/httprate"
+	"github.com/google/uuid"
+)
+
+type ctxKey int
+
+const (
+	merchantKey ctxKey = iota
+	apiKeyKey
+	adminKey
+)
+
+// HashKey returns the hex encoded sha256 of a raw api key
+func HashKey(raw string) string {
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateKey creates a new raw api key, e.g. fwd_live_<base58>
+func GenerateKey(mode string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return KeyPrefix + mode + "_" + solana.PublicKeyFromBytes(b).String(), nil
+}
+
+// IssueKey creates and stores a key for a merchant, returning the raw key
+func (c *Client) IssueKey(ctx context.Context, merchantID, mode string, scopes []string, ratelimit int) (string, *database.APIKey, error) {
+	raw, err := GenerateKey(mode)
+	if err != nil {
+		return "", nil, err
+	}
+
+	key := &database.APIKey{
+		ID:         uuid.New().String(),
+		MerchantID: merchantID,
+		Prefix:     raw[:len(KeyPrefix)+len(mode)+7],
+		Hash:       HashKey(raw),
+		Mode:       mode,
+		Scopes:     scopes,
+		RateLimit:  ratelimit,
+		CreatedAt:  uint64(time.Now().Unix()),
+	}
+
+	if err := c.db.APIKeys.Insert(ctx, key); err != nil {
+		return "", nil, err
+	}
+	return raw, key, nil
+}
+
+// CreateMerchant creates a merchant with a live key holding every scope, and a test key when test mode is enabled
+func (c *Client) CreateMerchant(ctx context.Context, name string) (*database.Merchant, map[string]string, error) {
+	merchant := &database.Merchant{
+		ID:        uuid.New().String(),
+		Name:      name,
+		CreatedAt: uint64(time.Now().Unix()),
+	}
+
+	if err := c.db.Merchants.Insert(ctx, merchant); err != nil {
+		return nil, nil, err
+	}
+
+	keys := make(map[string]string)
+	for _, mode := range []string{database.ModeLive, database.ModeTest} {
+		if c.network(mode) == nil {
+			continue
+		}
+
+		raw, _, err := c.IssueKey(ctx, merchant.ID, mode, AllScopes, 0)
+		if err != nil {
+			return nil, nil, err
+		}
+		keys[mode] = raw
+	}
+	return merchant, keys, nil
+}
+
+// RotateKey issues a replacement for a key, the old key keeps working until the grace period passes
+func (c *Client) RotateKey(ctx context.Context, old *database.APIKey) (string, *database.APIKey, error) {
+	raw, key, err := c.IssueKey(ctx, old.MerchantID, old.Mode, old.Scopes, old.RateLimit)
+	if err != nil {
+		return "", nil, err
+	}
+
+	expires := uint64(time.Now().Add(KeyRotationGrace).Unix())
+	if old.ExpiresAt == 0 || old.ExpiresAt > expires {
+		if err := c.db.APIKeys.Update(ctx, database.Where(database.APIKeyID.Eq(old.ID)), database.APIKeyExpiresAt.To(expires)); err != nil {
+			return "", nil, err
+		}
+	}
+	return raw, key, nil
+}
+
+func extractKey(r *http.Request) string {
+	if v := r.Header.Get("X-API-Key"); v != "" {
+		return v
+	}
+	auth := r.Header.Get("Authorization")
+	if strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return ""
+}
+
+// lookupKey resolves a raw key to its stored key and merchant
+func (c *Client) lookupKey(ctx context.Context, raw string) (*database.APIKey, *database.Merchant, error) {
+	if !strings.HasPrefix(raw, KeyPrefix) {
+		return nil, nil, types.ErrInvalidAPIKey
+	}
+
+	key, err := c.db.APIKeys.Get(ctx, database.Where(database.APIKeyHash.Eq(HashKey(raw))))
+	if err != nil {
+		return nil, nil, types.ErrInvalidAPIKey
+	}
+
+	if key.Revoked || (key.ExpiresAt != 0 && key.ExpiresAt <= uint64(time.Now().Unix())) {
+		return nil, nil, types.ErrInvalidAPIKey
+	}
+
+	merchant, err := c.db.Merchants.Get(ctx, database.Where(database.MerchantID.Eq(key.MerchantID)))
+	if err != nil || merchant.Disabled {
+		return nil, nil, types.ErrInvalidAPIKey
+	}
+	return key, merchant, nil
+}
+
+// Authenticate resolves the api key of the request and applies its rate limit
+func (c *Client) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		raw := extractKey(r)
+		if raw == "" {
+			types.Unauthorized(w, types.ErrMissingAPIKey)
+			return
+		}
+
+		key, merchant, err := c.lookupKey(r.Context(), raw)
+		if err != nil {
+			types.Unauthorized(w, err)
+			return
+		}
+
+		// mTLS only proves the CA signed the certificate, this ties it to the merchant the key belongs to
+		if !clientCertBound(r, merchant) {
+			types.Unauthorized(w, types.ErrClientCertMismatch)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), apiKeyKey, key)
+		ctx = context.WithValue(ctx, merchantKey, merchant)
+		if key.RateLimit > 0 {
+			ctx = httprate.WithRequestLimit(ctx, key.RateLimit)
+		} else if limit := c.config().KeyRatelimit; limit > 0 {
+			// The limiter was created with the limit on startup, a reload changes it per request
+			ctx = httprate.WithRequestLimit(ctx, limit)
+		}
+
+		r = r.WithContext(ctx)
+		if c.limiter.RespondOnLimit(w, r, key.ID) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects keys that have not been granted the scope
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := KeyFrom(r.Context())
+			if key == nil || !key.HasScope(scope) {
+				types.Forbidden(w, types.ErrInsufficientScope)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// KeyFrom returns the api key of an authenticated request
+func KeyFrom(ctx context.Context) *database.APIKey {
+	v, _ := ctx.Value(apiKeyKey).(*database.APIKey)
+	return v
+}
+
+// MerchantFrom returns the merchant of an authenticated request
+func MerchantFrom(ctx context.Context) *database.Merchant {
+	v, _ := ctx.Value(merchantKey).(*database.Merchant)
+	return v
+}
@@ -3,10 +3,12 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/Aran404/Forwarder/api/config"
 	"github.com/Aran404/Forwarder/api/database"
 	"github.com/Aran404/Forwarder/api/solana"
 	"github.com/Aran404/Forwarder/api/types"
@@ -36,7 +38,7 @@ func handleError(w http.ResponseWriter, r *http.Request, status int, reason erro
 }
 
 // UpgradeWS upgrades the connection to a websocket and runs KeepAlive operations
-func (c Client) UpgradeWS(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+func (c *Client) UpgradeWS(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
 	conn, err := c.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		return nil, err
@@ -72,21 +74,136 @@ func (c Client) UpgradeWS(w http.ResponseWriter, r *http.Request) (*websocket.Co
 	return conn, nil
 }
 
-func (c *Client) Listen() {
-	c.http.Post("/payment/create", c.CreatePayment)
-	http.ListenAndServe(":3443", c.http)
+// Listen starts the background work and serves the API until it fails or ctx is done, then shuts down
+// With a certificate configured it is served over TLS, checking client certificates when a client CA is set
+func (c *Client) Listen(ctx context.Context) error {
+	cfg := c.config().Server
+	c.server = &http.Server{Addr: cfg.Address}
+
+	tlsEnabled := cfg.TLS.Cert != ""
+	if tlsEnabled {
+		certs, err := newCertificates(cfg.TLS)
+		if err != nil {
+			return fmt.Errorf("could not load TLS certificate: %w", err)
+		}
+		c.server.TLSConfig = certs.TLSConfig()
+
+		if cfg.TLS.Redirect != "" {
+			c.redirect = &http.Server{Addr: cfg.TLS.Redirect, Handler: redirectHTTPS(cfg.Address)}
+		}
+	}
+
+	c.start(ctx)
+
+	c.http.Group(func(r chi.Router) {
+		r.Use(c.Authenticate)
+		r.With(RequireScope(ScopeCreate), c.Idempotent).Post("/payment/create", c.CreatePayment)
+		r.With(RequireScope(ScopeRead)).Get("/payment/{id}", c.GetPayment)
+		r.With(RequireScope(ScopeRefund)).Post("/payment/{id}/refund", c.RefundPayment)
+		r.Post("/keys/rotate", c.RotateAPIKey)
+		r.Post("/settings", c.MerchantSettings)
+	})
+	c.http.Route("/admin", c.adminRoutes)
+
+	c.server.Handler = c.http
+	failed := make(chan error, 2)
+	go func() {
+		if tlsEnabled {
+			failed <- c.server.ListenAndServeTLS("", "")
+		} else {
+			failed <- c.server.ListenAndServe()
+		}
+	}()
+
+	if c.redirect != nil {
+		log.Printf("Redirecting HTTP on %v to HTTPS", c.redirect.Addr)
+		go func() {
+			failed <- c.redirect.ListenAndServe()
+		}()
+	}
+
+	select {
+	case err := <-failed:
+		c.stop()
+		if c.redirect != nil {
+			c.redirect.Close()
+		}
+		c.server.Close()
+		return err
+	case <-ctx.Done():
+	}
+	return c.Shutdown()
 }
 
-func NewClient(ctx context.Context) *Client {
+// start runs what only a serving instance does: the lookup table, the sweeper, pruning, reloading on SIGHUP
+// and resuming the payments a previous instance left unfinished
+// Commands that use the client once go without, so paid payments are forwarded directly and nothing is left queued
+func (c *Client) start(ctx context.Context) {
+	cfg := c.config()
+	if cfg.LookupTable.Enabled {
+		if _, err := c.SyncLookupTable(ctx); err != nil {
+			log.Printf("Could not set up lookup table, sending legacy transactions: %v", err)
+		}
+	}
+
+	if cfg.Sweep.Enabled {
+		c.sweeper = NewSweeper(c)
+		go c.sweeper.Run(ctx)
+	}
+
+	go c.prune(ctx, time.Duration(cfg.Database.WebhookRetention)*24*time.Hour)
+	c.reloadOnHangup(ctx)
+
+	if err := c.resume(ctx); err != nil {
+		log.Printf("Could not resume unfinished payments: %v", err)
+	}
+}
+
+// begin registers work for Wait to wait on, it is false once stopped and the work must not start
+// A true begin must be followed by tracking.Done
+func (c *Client) begin() bool {
+	c.stopping.RLock()
+	defer c.stopping.RUnlock()
+	if c.ctx.Err() != nil {
+		return false
+	}
+
+	c.tracking.Add(1)
+	return true
+}
+
+// track runs fn in the background, Wait returns once every tracked fn has
+// Once stopped fn is not run, the payment it belongs to is left for the next instance
+func (c *Client) track(fn func()) {
+	if !c.begin() {
+		return
+	}
+
+	go func() {
+		defer c.tracking.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until the transactions being tracked are finalized or dropped and paid payments are forwarded
+func (c *Client) Wait() {
+	c.tracking.Wait()
+}
+
+func NewClient(ctx context.Context, cfg *config.Config) (*Client, error) {
 	r := chi.NewRouter()
 	r.Use(
 		middleware.RequestID,
 		middleware.RealIP,
 		middleware.Logger,
 		middleware.Recoverer,
-		httprate.LimitByRealIP(100, time.Minute),
 	)
 
+	limit := cfg.KeyRatelimit
+	if limit <= 0 {
+		limit = DefaultKeyLimit
+	}
+
 	upgrader := &websocket.Upgrader{
 		HandshakeTimeout: 10 * time.Second,
 		ReadBufferSize:   1024,
@@ -94,16 +211,65 @@ func NewClient(ctx context.Context) *Client {
 		Error:            handleError,
 	}
 
-	return &Client{
+	sol, err := solana.NewClient(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var test *solana.Client
+	if cfg.Test.Enabled {
+		if test, err = solana.NewClient(ctx, cfg.TestConfig()); err != nil {
+			sol.Close()
+			return nil, err
+		}
+	}
+	closeNetworks := func() {
+		sol.Close()
+		if test != nil {
+			test.Close()
+		}
+	}
+
+	db, err := database.Open(ctx, cfg.Database)
+	if err != nil {
+		closeNetworks()
+		return nil, err
+	}
+
+	if err := checkSchema(ctx, db, cfg.Database.Migrate); err != nil {
+		closeNetworks()
+		_ = db.Close(ctx)
+		return nil, err
+	}
+
+	// Outlives ctx, background work only stops on shutdown
+	background, cancel := context.WithCancel(context.WithoutCancel(ctx))
+
+	c := &Client{
+		ctx:      background,
+		cancel:   cancel,
 		upgrader: upgrader,
 		http:     r,
-		sol:      solana.NewClient(ctx),
-		db:       database.NewConn(ctx),
+		limiter:  httprate.NewRateLimiter(limit, time.Minute),
+		sol:      sol,
+		test:     test,
+		db:       db,
 	}
+
+	c.cfg.Store(cfg)
+	return c, nil
 }
 
+// Close stops the background work, then closes the websockets and the database in that order
 func (c *Client) Close(ctx context.Context) {
-	c.db.Close(ctx)
-	c.upgrader = nil 
+	c.stop()
+	c.sol.Close()
+	if c.test != nil {
+		c.test.Close()
+	}
+	if err := c.db.Close(ctx); err != nil {
+		log.Printf("Could not close database: %v", err)
+	}
+	c.upgrader = nil
 	c.http = nil
-}
\ No newline at end of file
+}
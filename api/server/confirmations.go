@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/big"
+	"net/http"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/solana"
+	"github.com/Aran404/Forwarder/api/types"
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+var commitmentLevels = []rpc.CommitmentType{rpc.CommitmentProcessed, rpc.CommitmentConfirmed, rpc.CommitmentFinalized}
+
+// commitments returns the levels a merchant is notified and has funds forwarded at
+func (c *Client) commitments(ctx context.Context, merchantID string) (notify, forward rpc.CommitmentType) {
+	notify, forward = DefaultCommitment, DefaultCommitment
+
+	query := database.Where(database.MerchantID.Eq(merchantID)).Only(database.MerchantNotifyCommitment, database.MerchantForwardCommitment)
+	m, err := c.db.Merchants.Get(ctx, query)
+	if err != nil {
+		return
+	}
+
+	if m.NotifyCommitment != "" {
+		notify = rpc.CommitmentType(m.NotifyCommitment)
+	}
+	if m.ForwardCommitment != "" {
+		forward = rpc.CommitmentType(m.ForwardCommitment)
+	}
+	return
+}
+
+// inspect returns how much the deposit address received in the transaction and who sent it
+// Before a transaction is confirmed it cannot be fetched, so the processed balance is used and the sender is unknown
+func (c *Client) inspect(ctx context.Context, p *database.Payment, signature string, status rpc.ConfirmationStatusType) (*big.Float, string, error) {
+	if !solana.Reached(status, rpc.CommitmentConfirmed) {
+		value, err := c.network(p.Mode).BalanceAt(ctx, p.Address, rpc.CommitmentProcessed)
+		return value, "", err
+	}
+
+	tx, err := c.network(p.Mode).GetTransaction(ctx, signature)
+	if err != nil {
+		return nil, "", err
+	}
+	if tx.Meta.Err != nil {
+		return nil, "", types.ErrTransactionFailed
+	}
+
+	value, err := tx.Received(p.Address)
+	if err != nil {
+		return nil, "", err
+	}
+	return value, tx.From(p.Address), nil
+}
+
+// trackConfirmations follows a payment's transaction until it is finalized or dropped
+// A transaction still not finalized after the confirmation timeout is checked less often, never given up on, so the payment does not stay seen
+func (c *Client) trackConfirmations(p *database.Payment, sig sol.Signature) {
+	notify, forward := c.commitments(c.ctx, p.MerchantID)
+	ticker := time.NewTicker(solana.ConfirmPollInterval)
+	defer ticker.Stop()
+
+	next, started, lastSeen, slowed := 0, time.Now(), time.Now(), false
+	for next < len(commitmentLevels) {
+		ctx, cancel := context.WithTimeout(c.ctx, DeadlineContext)
+		status, err := c.network(p.Mode).SignatureStatus(ctx, sig)
+		switch {
+		case err != nil:
+			log.Printf("Could not get status of %v: %v", sig, err)
+		case status != nil && status.Err != nil:
+			cancel()
+			c.dropPayment(p, sig.String())
+			return
+		case status == nil:
+			if time.Since(lastSeen) > DropTimeout {
+				cancel()
+				c.dropPayment(p, sig.String())
+				return
+			}
+		default:
+			lastSeen = time.Now()
+			for ; next < len(commitmentLevels) && solana.Reached(status.ConfirmationStatus, commitmentLevels[next]); next++ {
+				if err := c.reachCommitment(ctx, p, commitmentLevels[next], notify, forward); errors.Is(err, types.ErrNotFound) {
+					cancel()
+					log.Printf("Stopped tracking %v, payment %v moved on", sig, p.ID)
+					return
+				} else if err != nil {
+					log.Printf("Could not record %v of payment %v: %v", commitmentLevels[next], p.ID, err)
+					break
+				}
+			}
+		}
+		cancel()
+
+		if !slowed && next < len(commitmentLevels) && time.Since(started) > ConfirmationTimeout {
+			slowed = true
+			ticker.Reset(ConfirmationBackoff)
+			log.Printf("Transaction %v of payment %v is still at %v after %v, checking it every %v", sig, p.ID, p.Commitment, ConfirmationTimeout, ConfirmationBackoff)
+		}
+
+		select {
+		case <-c.ctx.Done():
+			log.Printf("Stopped tracking %v of payment %v at %v for shutdown", sig, p.ID, p.Commitment)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reachCommitment records a new commitment level, notifying the merchant and forwarding the funds when due
+// The payment and its transaction are updated together, nothing is sent unless both were
+func (c *Client) reachCommitment(ctx context.Context, p *database.Payment, level, notify, forward rpc.CommitmentType) error {
+	reached := *p
+	reached.Commitment = string(level)
+	update := []database.Change[database.Payment]{database.PaymentCommitment.To(reached.Commitment)}
+
+	// The transaction can only be fetched once confirmed, the amount seen before came from the balance
+	if level == rpc.CommitmentConfirmed {
+		if value, sender, err := c.inspect(ctx, p, p.Signature, rpc.ConfirmationStatusConfirmed); err == nil {
+			reached.AmountReceived, _ = value.Float64()
+			reached.Sender = sender
+			update = append(update, database.PaymentAmountReceived.To(reached.AmountReceived), database.PaymentSender.To(reached.Sender))
+		}
+	}
+
+	if level == forward {
+		reached.Status = database.PaymentPaid
+		update = append(update, database.PaymentStatus.To(reached.Status))
+	}
+
+	err := c.db.Atomic(ctx, func(ctx context.Context, db *database.Store) error {
+		// A payment whose transaction was dropped no longer has its signature
+		query := database.Where(database.PaymentID.Eq(p.ID), database.PaymentSignature.Eq(p.Signature))
+		if err := db.Payments.Update(ctx, query, update...); err != nil {
+			return err
+		}
+
+		return db.Transactions.Update(ctx, database.Where(database.TransactionSignature.Eq(p.Signature)),
+			database.TransactionCommitment.To(reached.Commitment),
+			database.TransactionAmount.To(reached.AmountReceived),
+			database.TransactionSender.To(reached.Sender),
+		)
+	})
+	if err != nil {
+		return err
+	}
+	*p = reached
+
+	if solana.Reached(rpc.ConfirmationStatusType(level), notify) {
+		c.notify(ctx, p, string(level), nil)
+	}
+
+	if level == forward {
+		c.track(func() { c.forwardPaid(p) })
+	}
+	return nil
+}
+
+// deliveryID identifies the webhook of a payment's transaction at a commitment level
+// It is the same every time, so a repeated notification is recognised and not sent twice
+func deliveryID(p *database.Payment, commitment string) string {
+	return uuid.NewSHA1(uuid.NameSpaceURL, []byte(p.ID+"/"+p.Signature+"/"+commitment)).String()
+}
+
+// notify records and sends a webhook for the payment's transaction, unless it was sent already
+func (c *Client) notify(ctx context.Context, p *database.Payment, commitment string, reason error) {
+	now := time.Now()
+	response := &database.Webhook{
+		DeliveryID:     deliveryID(p, commitment),
+		Success:        reason == nil,
+		ID:             p.ID,
+		DesiredAmount:  p.Amount,
+		AmountSent:     p.AmountReceived,
+		TransactionID:  p.Signature,
+		Address:        p.Address,
+		TimeSent:       uint64(now.Unix()),
+		PercentOfTotal: (p.AmountReceived / p.Amount) * 100,
+		Commitment:     commitment,
+	}
+	if retention := c.config().Database.WebhookRetention; retention > 0 {
+		response.ExpiresAt = now.Add(time.Duration(retention) * 24 * time.Hour)
+	}
+
+	if reason != nil {
+		response.Error = types.GetProperError(reason)
+	} else if new(big.Float).Mul(big.NewFloat(p.Amount), big.NewFloat(1-c.config().Forwarder.TransactionThreshold)).Cmp(big.NewFloat(p.AmountReceived)) >= 0 {
+		response.Error = types.GetProperError(types.ErrTransactionSlipped)
+	}
+
+	err := c.db.Webhooks.Insert(ctx, response)
+	if errors.Is(err, types.ErrDuplicate) {
+		return
+	}
+	if err != nil {
+		log.Printf("Could not record webhook of payment %v: %v", p.ID, err)
+	}
+	SendWebhook(p.CallbackURI, response)
+}
+
+// forwardPaid hands a paid payment to the sweeper or forwards it right away
+func (c *Client) forwardPaid(p *database.Payment) {
+	if c.sweeper != nil {
+		c.sweeper.Add(p)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ForwardTimeout)
+	defer cancel()
+
+	if err := c.ForwardFunds(ctx, p); err != nil {
+		log.Printf("Could not forward payment %v: %v", p.ID, err)
+	}
+}
+
+// dropPayment returns a payment whose transaction never landed to pending and watches it again
+func (c *Client) dropPayment(p *database.Payment, signature string) {
+	ctx, cancel := context.WithTimeout(context.Background(), DeadlineContext)
+	defer cancel()
+
+	log.Printf("Transaction %v of payment %v was dropped", signature, p.ID)
+	err := c.db.Atomic(ctx, func(ctx context.Context, db *database.Store) error {
+		err := db.Payments.Update(ctx,
+			database.Where(
+				database.PaymentID.Eq(p.ID),
+				database.PaymentSignature.Eq(signature),
+				database.PaymentStatus.In(database.PaymentSeen, database.PaymentPaid),
+			),
+			database.PaymentStatus.To(database.PaymentPending),
+			database.PaymentSignature.To(""),
+			database.PaymentSender.To(""),
+			database.PaymentCommitment.To(""),
+			database.PaymentAmountReceived.To(0),
+		)
+		if err != nil {
+			return err
+		}
+		return db.Transactions.Update(ctx, database.Where(database.TransactionSignature.Eq(signature)), database.TransactionCommitment.To(CommitmentDropped))
+	})
+	if err != nil {
+		log.Printf("Could not update payment %v: %v", p.ID, err)
+		return
+	}
+
+	c.notify(ctx, p, CommitmentDropped, types.ErrTransactionDropped)
+
+	p.Status, p.Signature, p.Sender, p.Commitment, p.AmountReceived = database.PaymentPending, "", "", "", 0
+	if time.Now().Before(time.Unix(int64(p.Expires), 0)) {
+		c.WatchPayment(p)
+	}
+}
+
+// UpdateSettings sets the commitment levels a merchant is notified and has funds forwarded at
+func (c *Client) UpdateSettings(ctx context.Context, merchantID string, body *MerchantSettingsBody) error {
+	var update []database.Change[database.Merchant]
+	switch rpc.CommitmentType(body.NotifyCommitment) {
+	case "":
+	case rpc.CommitmentProcessed, rpc.CommitmentConfirmed, rpc.CommitmentFinalized:
+		update = append(update, database.MerchantNotifyCommitment.To(body.NotifyCommitment))
+	default:
+		return types.ErrInvalidCommitment
+	}
+
+	switch rpc.CommitmentType(body.ForwardCommitment) {
+	case "":
+	case rpc.CommitmentConfirmed, rpc.CommitmentFinalized:
+		update = append(update, database.MerchantForwardCommitment.To(body.ForwardCommitment))
+	default:
+		return types.ErrInvalidCommitment
+	}
+
+	if len(update) == 0 {
+		return nil
+	}
+	return c.db.Merchants.Update(ctx, database.Where(database.MerchantID.Eq(merchantID)), update...)
+}
+
+func (c *Client) MerchantSettings(w http.ResponseWriter, r *http.Request) {
+	c.updateSettings(w, r, MerchantFrom(r.Context()).ID)
+}
+
+func (c *Client) AdminMerchantSettings(w http.ResponseWriter, r *http.Request) {
+	c.updateSettings(w, r, chi.URLParam(r, "id"))
+}
+
+func (c *Client) updateSettings(w http.ResponseWriter, r *http.Request, merchantID string) {
+	var body *MerchantSettingsBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	if err := c.UpdateSettings(r.Context(), merchantID, body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	merchant, err := c.db.Merchants.Get(r.Context(), database.Where(database.MerchantID.Eq(merchantID)))
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+	SendJSON(w, merchant)
+}
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/solana"
+)
+
+// WatchPayment looks for transactions to the deposit address until the payment is paid or expires
+// Depending on the detection mode they come from log subscriptions, polling or both
+// Transactions are looked for at the merchant's notify commitment, so a merchant notified at processed hears of them first
+func (c *Client) WatchPayment(p *database.Payment) {
+	ctx, cancel := context.WithDeadline(c.ctx, time.Unix(int64(p.Expires), 0))
+	notify, _ := c.commitments(ctx, p.MerchantID)
+
+	mode := c.config().Detection.Mode
+	if mode == "" {
+		mode = DetectSubscribe
+	}
+
+	c.network(p.Mode).Watch(ctx, &solana.Watch{
+		Address:    p.Address,
+		Commitment: notify,
+		Subscribe:  mode != DetectPoll,
+		Poll:       mode == DetectPoll || mode == DetectBoth,
+		Fallback:   mode == DetectFallback,
+		Cursor:     p.Cursor,
+		OnCursor: func(cursor string) {
+			c.setPaymentStatus(ctx, p.ID, database.PaymentCursor.To(cursor))
+		},
+		Handle: func(signature string) (bool, error) {
+			done, err := c.ProcessSignature(ctx, p, signature)
+			if done {
+				cancel()
+			}
+			return done, err
+		},
+	})
+
+	context.AfterFunc(ctx, func() {
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), DeadlineContext)
+		defer cancel()
+		query := database.Where(database.PaymentID.Eq(p.ID), database.PaymentStatus.Eq(database.PaymentPending))
+		_ = c.db.Payments.Update(ctx, query, database.PaymentStatus.To(database.PaymentExpired))
+	})
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/solana"
+	"github.com/Aran404/Forwarder/api/types"
+	sol "github.com/gagliardetto/solana-go"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// CreateNonce creates a nonce account on chain and records it, claimed by preparedID if set
+func (c *Client) CreateNonce(ctx context.Context, preparedID string) (*database.NonceAccount, error) {
+	address, sig, err := c.sol.CreateNonceAccount(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created nonce account %v. Transaction: %v", address, sig.String())
+
+	nonce := &database.NonceAccount{
+		Address:    address,
+		Authority:  c.sol.FeePayer(),
+		PreparedID: preparedID,
+		CreatedAt:  uint64(time.Now().Unix()),
+	}
+	return nonce, c.db.Nonces.Insert(ctx, nonce)
+}
+
+// claimNonce reserves a free nonce account for a prepared transaction, creating one if none are free
+func (c *Client) claimNonce(ctx context.Context, preparedID string) (*database.NonceAccount, error) {
+	nonce, err := c.db.Nonces.Claim(ctx,
+		database.Where(database.NoncePreparedID.Eq(""), database.NonceAuthority.Eq(c.sol.FeePayer())),
+		database.NoncePreparedID.To(preparedID),
+	)
+
+	if errors.Is(err, types.ErrNotFound) {
+		return c.CreateNonce(ctx, preparedID)
+	}
+	return nonce, err
+}
+
+func (c *Client) releaseNonce(ctx context.Context, address string) {
+	if err := c.db.Nonces.Update(ctx, database.Where(database.NonceAddress.Eq(address)), database.NoncePreparedID.To("")); err != nil {
+		log.Printf("Could not release nonce %v: %v", address, err)
+	}
+}
+
+// hold claims a paid payment for a prepared transaction, so the sweeper, rechecks and refunds leave it alone
+func (c *Client) hold(ctx context.Context, id string) (*database.Payment, error) {
+	p, err := c.claim(ctx, id, database.PaymentHeld)
+	if errors.Is(err, types.ErrNotFound) {
+		return nil, types.ErrNotRefundable
+	}
+	return p, err
+}
+
+// unhold returns a held payment to paid, a payment that moved on since is left as it is
+func (c *Client) unhold(ctx context.Context, id string) {
+	c.unclaim(ctx, id, database.PaymentHeld)
+}
+
+// Prepare signs a durable refund or payout of a paid payment that an admin can approve later
+func (c *Client) Prepare(ctx context.Context, p *database.Payment, kind, to string, admin *database.Admin) (*database.PreparedTransaction, error) {
+	switch kind {
+	case database.KindRefund:
+	case database.KindPayout:
+		if _, err := sol.PublicKeyFromBase58(to); err != nil {
+			return nil, types.ErrInvalidAddress
+		}
+	default:
+		return nil, types.ErrInvalidKind
+	}
+
+	// Nonce accounts belong to the fee payer, which only exists on the live network
+	if p.Mode == database.ModeTest {
+		return nil, types.ErrLiveOnly
+	}
+
+	p, err := c.hold(ctx, p.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	prepared, err := c.prepare(ctx, p, kind, to, admin)
+	if err != nil {
+		c.unhold(ctx, p.ID)
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// prepare signs the transaction of a payment that is held for it
+func (c *Client) prepare(ctx context.Context, p *database.Payment, kind, to string, admin *database.Admin) (*database.PreparedTransaction, error) {
+	if kind == database.KindRefund {
+		to = p.Sender
+	}
+
+	from, err := c.sol.FromFile(walletPath(p.Address))
+	if err != nil {
+		return nil, err
+	}
+
+	prepared := &database.PreparedTransaction{
+		ID:         uuid.New().String(),
+		Kind:       kind,
+		PaymentID:  p.ID,
+		To:         to,
+		Status:     database.PreparedPending,
+		PreparedBy: admin.ID,
+		CreatedAt:  uint64(time.Now().Unix()),
+	}
+
+	record, err := c.claimNonce(ctx, prepared.ID)
+	if err != nil {
+		return nil, err
+	}
+	prepared.Nonce = record.Address
+
+	nonce, err := c.sol.GetNonce(ctx, record.Address)
+	if err != nil {
+		c.releaseNonce(ctx, record.Address)
+		return nil, err
+	}
+
+	tx, err := c.sol.PrepareSendAll(ctx, from, to, nonce)
+	if err != nil {
+		c.releaseNonce(ctx, record.Address)
+		return nil, err
+	}
+
+	if prepared.Transaction, err = tx.ToBase64(); err != nil {
+		c.releaseNonce(ctx, record.Address)
+		return nil, err
+	}
+
+	if err := c.db.Prepared.Insert(ctx, prepared); err != nil {
+		c.releaseNonce(ctx, record.Address)
+		return nil, err
+	}
+	return prepared, nil
+}
+
+// Approve submits a prepared transaction and waits for it to be confirmed
+func (c *Client) Approve(ctx context.Context, prepared *database.PreparedTransaction, admin *database.Admin) error {
+	if prepared.Status != database.PreparedPending {
+		return types.ErrNotPending
+	}
+
+	if prepared.PreparedBy == admin.ID {
+		return types.ErrSelfApproval
+	}
+
+	tx := new(sol.Transaction)
+	if err := tx.UnmarshalBase64(prepared.Transaction); err != nil {
+		return err
+	}
+
+	sig, err := c.sol.SubmitDurable(ctx, tx, prepared.Nonce, solana.SendCommitment)
+	if errors.Is(err, types.ErrTransactionExpired) || errors.Is(err, types.ErrTransactionFailed) {
+		c.finishPrepared(ctx, prepared,
+			database.PreparedStatus.To(database.PreparedFailed),
+			database.PreparedApprovedBy.To(admin.ID),
+			database.PreparedError.To(err.Error()),
+		)
+		c.unhold(ctx, prepared.PaymentID)
+		return err
+	}
+
+	if err != nil {
+		_ = c.db.Prepared.Update(ctx, database.Where(database.PreparedID.Eq(prepared.ID)), database.PreparedError.To(err.Error()))
+		return err
+	}
+
+	c.finishPrepared(ctx, prepared,
+		database.PreparedStatus.To(database.PreparedConfirmed),
+		database.PreparedApprovedBy.To(admin.ID),
+		database.PreparedSignature.To(sig.String()),
+	)
+
+	update := []database.Change[database.Payment]{database.PaymentStatus.To(database.PaymentRefunded), database.PaymentRefundSignature.To(sig.String())}
+	if prepared.Kind == database.KindPayout {
+		update = []database.Change[database.Payment]{database.PaymentStatus.To(database.PaymentForwarded), database.PaymentForwardSignature.To(sig.String())}
+	}
+	query := database.Where(database.PaymentID.Eq(prepared.PaymentID), database.PaymentStatus.Eq(database.PaymentHeld))
+	if err := c.db.Payments.Update(ctx, query, update...); err != nil {
+		log.Printf("Could not update payment %v: %v", prepared.PaymentID, err)
+	}
+
+	log.Printf("Approved %v of payment %v to %v. Transaction: %v", prepared.Kind, prepared.PaymentID, prepared.To, sig.String())
+	p, err := c.db.Payments.Get(ctx, database.Where(database.PaymentID.Eq(prepared.PaymentID)))
+	if err != nil {
+		return nil
+	}
+	c.disposeEmpty(ctx, p)
+	return nil
+}
+
+// disposeEmpty disposes of the deposit wallet of a payment once it holds nothing
+// The amount of a prepared transaction is fixed when it is signed, so funds that arrived later stay behind
+// Such a wallet is kept, reconcile reports it as leftover
+func (c *Client) disposeEmpty(ctx context.Context, p *database.Payment) {
+	balance, err := c.sol.WalletBalance(ctx, p.Address)
+	if err != nil {
+		log.Printf("Could not check wallet %v of payment %v, keeping it: %v", p.Address, p.ID, err)
+		return
+	}
+	if balance.Sign() > 0 {
+		log.Printf("Wallet %v of payment %v still holds %v SOL, keeping it for reconcile", p.Address, p.ID, balance.Text('f', 9))
+		return
+	}
+
+	if from, err := c.sol.FromFile(walletPath(p.Address)); err == nil {
+		if err := from.Dispose(ctx); err != nil {
+			log.Printf("Could not dispose wallet %v: %v", p.Address, err)
+		}
+	}
+}
+
+// Cancel advances the nonce of a prepared transaction so it can never be submitted
+func (c *Client) Cancel(ctx context.Context, prepared *database.PreparedTransaction) error {
+	if prepared.Status != database.PreparedPending {
+		return types.ErrNotPending
+	}
+
+	if _, err := c.sol.AdvanceNonce(ctx, prepared.Nonce); err != nil {
+		return err
+	}
+
+	c.finishPrepared(ctx, prepared, database.PreparedStatus.To(database.PreparedCancelled))
+	c.unhold(ctx, prepared.PaymentID)
+	return nil
+}
+
+func (c *Client) finishPrepared(ctx context.Context, prepared *database.PreparedTransaction, changes ...database.Change[database.PreparedTransaction]) {
+	if err := c.db.Prepared.Update(ctx, database.Where(database.PreparedID.Eq(prepared.ID)), changes...); err != nil {
+		log.Printf("Could not update prepared transaction %v: %v", prepared.ID, err)
+	}
+	c.releaseNonce(ctx, prepared.Nonce)
+}
+
+func (c *Client) adminPrepared(r *http.Request) (*database.PreparedTransaction, error) {
+	return c.db.Prepared.Get(r.Context(), database.Where(database.PreparedID.Eq(chi.URLParam(r, "id"))))
+}
+
+func (c *Client) AdminListNonces(w http.ResponseWriter, r *http.Request) {
+	nonces, err := c.db.Nonces.Find(r.Context(), database.Where[database.NonceAccount]())
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, nonces)
+}
+
+func (c *Client) AdminCreateNonce(w http.ResponseWriter, r *http.Request) {
+	nonce, err := c.CreateNonce(r.Context(), "")
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, nonce)
+}
+
+func (c *Client) AdminListPrepared(w http.ResponseWriter, r *http.Request) {
+	query := database.Where[database.PreparedTransaction]()
+	if v := r.URL.Query().Get("status"); v != "" {
+		query.And(database.PreparedStatus.Eq(v))
+	}
+
+	prepared, err := c.db.Prepared.Page(r.Context(), paginate(r, query, database.PreparedCreatedAt))
+	if errors.Is(err, types.ErrInvalidCursor) {
+		types.BadRequest(w, err)
+		return
+	}
+	if err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, prepared)
+}
+
+func (c *Client) AdminGetPrepared(w http.ResponseWriter, r *http.Request) {
+	prepared, err := c.adminPrepared(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+	SendJSON(w, prepared)
+}
+
+func (c *Client) AdminPrepare(w http.ResponseWriter, r *http.Request) {
+	var body *AdminPrepareBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	payment, err := c.adminPayment(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	prepared, err := c.Prepare(r.Context(), payment, body.Kind, body.To, AdminFrom(r.Context()))
+	if err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+	SendJSON(w, prepared)
+}
+
+func (c *Client) AdminApprove(w http.ResponseWriter, r *http.Request) {
+	prepared, err := c.adminPrepared(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	if err := c.Approve(r.Context(), prepared, AdminFrom(r.Context())); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	if prepared, err = c.adminPrepared(r); err != nil {
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, prepared)
+}
+
+func (c *Client) AdminCancel(w http.ResponseWriter, r *http.Request) {
+	prepared, err := c.adminPrepared(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	if err := c.Cancel(r.Context(), prepared); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+	SendJSON(w, &SuccessResponse{Success: true})
+}
@@ -3,12 +3,15 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"io"
 	"log"
 	"net/http"
 	"strings"
 
+	"github.com/Aran404/Forwarder/api/database"
 	"github.com/Aran404/Forwarder/api/types"
+	"github.com/go-chi/chi/v5"
 )
 
 func ParseJSON(r *http.Request, v interface{}) error {
@@ -46,6 +49,17 @@ func SendWebhook(uri string, v interface{}) {
 }
 
 func (c *Client) CreatePayment(w http.ResponseWriter, r *http.Request) {
+	if c.draining.Load() {
+		types.ServiceUnavailable(w, types.ErrShuttingDown)
+		return
+	}
+
+	// Test payments are watched and forwarded on the test network, which is only there when enabled
+	if c.network(KeyFrom(r.Context()).Mode) == nil {
+		types.Forbidden(w, types.ErrTestModeDisabled)
+		return
+	}
+
 	var body *PaymentCreateBody
 	if err := ParseJSON(r, &body); err != nil {
 		types.BadRequest(w, err)
@@ -60,15 +74,64 @@ func (c *Client) CreatePayment(w http.ResponseWriter, r *http.Request) {
 		body.CallbackURI = "http://" + body.CallbackURI
 	}
 
-	if !ALLOW_LOCAL_HOST && strings.Contains(body.CallbackURI, "localhost") {
+	cfg := c.config()
+	if !cfg.Server.AllowLocalhost && strings.Contains(body.CallbackURI, "localhost") {
 		types.BadRequest(w, types.ErrInvalidCallbackURI)
 		return
 	}
 
-	if body.Amount < MinForward || body.Amount <= 0 {
+	if body.Amount < cfg.Forwarder.MinForward || body.Amount <= 0 {
 		types.BadRequest(w, types.ErrInvalidAmount)
 		return
 	}
 
 	c.HandleCreatePayment(w, r, body)
 }
+
+func (c *Client) merchantPayment(r *http.Request) (*database.Payment, error) {
+	query := database.Where(database.PaymentID.Eq(chi.URLParam(r, "id")), database.PaymentMerchantID.Eq(MerchantFrom(r.Context()).ID))
+	return c.db.Payments.Get(r.Context(), query)
+}
+
+func (c *Client) GetPayment(w http.ResponseWriter, r *http.Request) {
+	payment, err := c.merchantPayment(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+	SendJSON(w, payment)
+}
+
+func (c *Client) RefundPayment(w http.ResponseWriter, r *http.Request) {
+	payment, err := c.merchantPayment(r)
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+
+	sig, err := c.Refund(r.Context(), payment)
+	switch {
+	case errors.Is(err, types.ErrNotRefundable), errors.Is(err, types.ErrAlreadyForwarded):
+		types.Conflict(w, err)
+		return
+	case errors.Is(err, types.ErrTestModeDisabled):
+		types.Forbidden(w, err)
+		return
+	case errors.Is(err, types.ErrNotFound):
+		types.NotFound(w, err)
+		return
+	case err != nil:
+		types.InternalServerError(w, err)
+		return
+	}
+	SendJSON(w, &RefundResponse{Success: true, ID: payment.ID, Signature: sig.String()})
+}
+
+func (c *Client) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	raw, key, err := c.RotateKey(r.Context(), KeyFrom(r.Context()))
+	if err != nil {
+		types.GInternalServerError(w)
+		return
+	}
+	SendJSON(w, &KeyRotateResponse{Success: true, Key: raw, Info: key})
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+// recorder keeps a copy of the response written through it
+type recorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// requestHash identifies a request by its method, path and body
+func requestHash(r *http.Request, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(r.Method + " " + r.URL.Path + "\n"))
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// claimIdempotencyKey stores the key for a new request, or returns the response stored for it
+// A nil key means the request is new and must be handled
+func (c *Client) claimIdempotencyKey(ctx context.Context, record *database.IdempotencyKey) (*database.IdempotencyKey, error) {
+	for attempt := 0; ; attempt++ {
+		err := c.db.Idempotency.Insert(ctx, record)
+		if !errors.Is(err, types.ErrDuplicate) {
+			return nil, err
+		}
+
+		stored, err := c.db.Idempotency.Get(ctx, database.Where(database.IdempotencyID.Eq(record.ID)))
+		if errors.Is(err, types.ErrNotFound) && attempt == 0 {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// Keys past the window count as unused even before they are pruned
+		// A key still without a response past its lease was left by a request that crashed, it is free again too
+		expired := stored.CreatedAt < uint64(time.Now().Add(-IdempotencyWindow).Unix())
+		abandoned := stored.Status == 0 && stored.CreatedAt < uint64(time.Now().Add(-IdempotencyLease).Unix())
+		if (expired || abandoned) && attempt == 0 {
+			query := database.Where(
+				database.IdempotencyID.Eq(stored.ID),
+				database.IdempotencyCreatedAt.Eq(stored.CreatedAt),
+				database.IdempotencyStatus.Eq(stored.Status),
+			)
+			if err := c.db.Idempotency.Delete(ctx, query); err != nil && !errors.Is(err, types.ErrNotFound) {
+				return nil, err
+			}
+			continue
+		}
+
+		switch {
+		case stored.RequestHash != record.RequestHash:
+			return nil, types.ErrIdempotencyReused
+		case stored.Status == 0:
+			return nil, types.ErrIdempotencyPending
+		}
+		return stored, nil
+	}
+}
+
+// Idempotent replays the first response to a request repeated with the same Idempotency-Key
+// Keys are scoped per merchant and kept for IdempotencyWindow, reusing one with a different body is a conflict
+func (c *Client) Idempotent(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyHeader)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(key) > MaxIdempotencyKey {
+			types.BadRequest(w, types.ErrInvalidIdempotency)
+			return
+		}
+
+		// The whole body is held in memory to hash it, so it is capped before it is read
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(MaxIdempotentBody)))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			types.RequestTooLarge(w, types.ErrBodyTooLarge)
+			return
+		}
+		if err != nil {
+			types.BadRequest(w, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		merchant := MerchantFrom(r.Context())
+		record := &database.IdempotencyKey{
+			ID:          merchant.ID + "/" + key,
+			MerchantID:  merchant.ID,
+			Key:         key,
+			RequestHash: requestHash(r, body),
+			CreatedAt:   uint64(time.Now().Unix()),
+			ExpiresAt:   time.Now().Add(IdempotencyWindow),
+		}
+
+		stored, err := c.claimIdempotencyKey(r.Context(), record)
+		switch {
+		case errors.Is(err, types.ErrIdempotencyReused), errors.Is(err, types.ErrIdempotencyPending):
+			types.Conflict(w, err)
+			return
+		case err != nil:
+			types.InternalServerError(w, err)
+			return
+		case stored != nil:
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(stored.Status)
+			_, _ = io.WriteString(w, stored.Response)
+			return
+		}
+
+		rec := &recorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// The client may have given up waiting, which is when it retries, so the response is kept regardless
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), DeadlineContext)
+		defer cancel()
+
+		// A request that outlived its lease may have lost the key to a retry, whose record is left alone
+		query := database.Where(database.IdempotencyID.Eq(record.ID), database.IdempotencyCreatedAt.Eq(record.CreatedAt))
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			// Failures are not replayed, the request can be retried with the same key
+			_ = c.db.Idempotency.Delete(ctx, query)
+			return
+		}
+		_ = c.db.Idempotency.Update(ctx, query, database.IdempotencyStatus.To(rec.status), database.IdempotencyResponse.To(rec.body.String()))
+	})
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+)
+
+// idempotent wraps a handler that counts its calls and answers with status
+func idempotent(t *testing.T, status int) (*Client, http.Handler, *int) {
+	t.Helper()
+	c := &Client{db: database.NewStore(database.NewMemory())}
+	if _, err := c.db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	calls := new(int)
+	h := c.Idempotent(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*calls++
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`{"call":` + strconv.Itoa(*calls) + `}`))
+	}))
+	return c, h, calls
+}
+
+func send(h http.Handler, merchant, key, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/payment/create", strings.NewReader(body))
+	r = r.WithContext(context.WithValue(r.Context(), merchantKey, &database.Merchant{ID: merchant}))
+	if key != "" {
+		r.Header.Set(IdempotencyHeader, key)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestIdempotentReplays(t *testing.T) {
+	_, h, calls := idempotent(t, http.StatusOK)
+
+	first := send(h, "m1", "k1", `{"amount":1}`)
+	second := send(h, "m1", "k1", `{"amount":1}`)
+	if *calls != 1 {
+		t.Fatalf("handler ran %v times, want once", *calls)
+	}
+	if second.Code != first.Code || second.Body.String() != first.Body.String() {
+		t.Errorf("replay got %v %q, want %v %q", second.Code, second.Body, first.Code, first.Body)
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay is not marked")
+	}
+
+	// Keys are scoped per merchant and requests without one always run
+	send(h, "m2", "k1", `{"amount":1}`)
+	send(h, "m1", "", `{"amount":1}`)
+	if *calls != 3 {
+		t.Errorf("handler ran %v times, want 3", *calls)
+	}
+}
+
+func TestIdempotentConflicts(t *testing.T) {
+	c, h, calls := idempotent(t, http.StatusOK)
+
+	send(h, "m1", "k1", `{"amount":1}`)
+	if w := send(h, "m1", "k1", `{"amount":2}`); w.Code != http.StatusConflict {
+		t.Errorf("reused key got %v, want 409", w.Code)
+	}
+
+	// A request still running holds its key
+	pending := &database.IdempotencyKey{ID: "m1/k2", MerchantID: "m1", Key: "k2", CreatedAt: uint64(time.Now().Unix())}
+	pending.RequestHash = requestHash(httptest.NewRequest(http.MethodPost, "/payment/create", nil), []byte(`{"amount":1}`))
+	if err := c.db.Idempotency.Insert(context.Background(), pending); err != nil {
+		t.Fatal(err)
+	}
+	if w := send(h, "m1", "k2", `{"amount":1}`); w.Code != http.StatusConflict {
+		t.Errorf("running request got %v, want 409", w.Code)
+	}
+	if *calls != 1 {
+		t.Errorf("handler ran %v times, want once", *calls)
+	}
+}
+
+func TestIdempotentFreesAbandonedAndExpiredKeys(t *testing.T) {
+	c, h, calls := idempotent(t, http.StatusOK)
+	hash := requestHash(httptest.NewRequest(http.MethodPost, "/payment/create", nil), []byte(`{"amount":1}`))
+	now := time.Now()
+
+	for id, record := range map[string]*database.IdempotencyKey{
+		"abandoned": {Status: 0, CreatedAt: uint64(now.Add(-2 * IdempotencyLease).Unix())},
+		"expired":   {Status: http.StatusOK, Response: `{"old":true}`, CreatedAt: uint64(now.Add(-2 * IdempotencyWindow).Unix())},
+	} {
+		record.ID, record.MerchantID, record.Key, record.RequestHash = "m1/"+id, "m1", id, hash
+		if err := c.db.Idempotency.Insert(context.Background(), record); err != nil {
+			t.Fatal(err)
+		}
+
+		if w := send(h, "m1", id, `{"amount":1}`); w.Code != http.StatusOK || w.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("%v key got %v replayed %q, want the request to run", id, w.Code, w.Header().Get("Idempotent-Replayed"))
+		}
+	}
+	if *calls != 2 {
+		t.Errorf("handler ran %v times, want twice", *calls)
+	}
+}
+
+func TestIdempotentDoesNotKeepFailures(t *testing.T) {
+	_, h, calls := idempotent(t, http.StatusInternalServerError)
+
+	send(h, "m1", "k1", `{"amount":1}`)
+	send(h, "m1", "k1", `{"amount":1}`)
+	if *calls != 2 {
+		t.Errorf("handler ran %v times, want the failure retried", *calls)
+	}
+}
+
+func TestIdempotentRefusesLargeBodies(t *testing.T) {
+	_, h, calls := idempotent(t, http.StatusOK)
+
+	body := `{"memo":"` + strings.Repeat("a", MaxIdempotentBody) + `"}`
+	if w := send(h, "m1", "k1", body); w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body got %v, want 413", w.Code)
+	}
+	if *calls != 0 {
+		t.Errorf("handler ran %v times, want never", *calls)
+	}
+
+	// The key was not taken by the refused request
+	if w := send(h, "m1", "k1", `{"amount":1}`); w.Code != http.StatusOK || *calls != 1 {
+		t.Errorf("key after a refused request got %v and %v calls, want 200 and 1", w.Code, *calls)
+	}
+}
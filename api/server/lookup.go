@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/types"
+	sol "github.com/gagliardetto/solana-go"
+	addresslookuptable "github.com/gagliardetto/solana-go/programs/address-lookup-table"
+)
+
+// lookupAddresses returns the frequently used addresses that belong in the lookup table
+func (c *Client) lookupAddresses() []string {
+	cfg := c.config()
+	addresses := []string{cfg.Forwarder.ForwardAddress, c.sol.FeePayer()}
+	return append(addresses, cfg.LookupTable.Addresses...)
+}
+
+// SyncLookupTable creates the fee payer's lookup table if needed, adds any missing addresses and starts using it
+func (c *Client) SyncLookupTable(ctx context.Context, extra ...string) (*database.LookupTable, error) {
+	if c.sol.FeePayer() == "" {
+		return nil, types.ErrNoFeePayer
+	}
+
+	table, err := c.db.LookupTables.Get(ctx, database.Where(database.LookupTableAuthority.Eq(c.sol.FeePayer())))
+	if errors.Is(err, types.ErrNotFound) {
+		address, sig, err := c.sol.CreateLookupTable(ctx)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Created lookup table %v. Transaction: %v", address, sig.String())
+
+		table = &database.LookupTable{
+			Address:   address,
+			Authority: c.sol.FeePayer(),
+			CreatedAt: uint64(time.Now().Unix()),
+		}
+		if err := c.db.LookupTables.Insert(ctx, table); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	present := make(map[string]bool, len(table.Addresses))
+	for _, a := range table.Addresses {
+		present[a] = true
+	}
+
+	var missing []string
+	for _, a := range append(c.lookupAddresses(), extra...) {
+		if a == "" || present[a] {
+			continue
+		}
+		if _, err := sol.PublicKeyFromBase58(a); err != nil {
+			return nil, types.ErrInvalidAddress
+		}
+		present[a] = true
+		missing = append(missing, a)
+	}
+
+	if len(table.Addresses)+len(missing) > addresslookuptable.LOOKUP_TABLE_MAX_ADDRESSES {
+		return nil, types.ErrLookupTableFull
+	}
+
+	if len(missing) > 0 {
+		if err := c.sol.ExtendLookupTable(ctx, table.Address, missing); err != nil {
+			return nil, err
+		}
+
+		table.Addresses = append(table.Addresses, missing...)
+		if err := c.db.LookupTables.Update(ctx, database.Where(database.LookupTableAddress.Eq(table.Address)), database.LookupTableAddresses.To(table.Addresses)); err != nil {
+			return nil, err
+		}
+		log.Printf("Added %v addresses to lookup table %v", len(missing), table.Address)
+	}
+
+	return table, c.sol.UseLookupTable(ctx, table.Address)
+}
+
+func (c *Client) AdminGetLookupTable(w http.ResponseWriter, r *http.Request) {
+	table, err := c.db.LookupTables.Get(r.Context(), database.Where(database.LookupTableAuthority.Eq(c.sol.FeePayer())))
+	if err != nil {
+		types.NotFound(w, err)
+		return
+	}
+	SendJSON(w, table)
+}
+
+func (c *Client) AdminSyncLookupTable(w http.ResponseWriter, r *http.Request) {
+	var body *AdminLookupTableBody
+	if err := ParseJSON(r, &body); err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+
+	table, err := c.SyncLookupTable(r.Context(), body.Addresses...)
+	if err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+	SendJSON(w, table)
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+)
+
+// Migrate applies the pending schema migrations and logs each one
+func Migrate(ctx context.Context, db *database.Store) error {
+	applied, err := db.Migrate(ctx)
+	for _, m := range applied {
+		log.Printf("Applied migration %v: %v", m.Version, m.Name)
+	}
+	return err
+}
+
+// checkSchema migrates on startup when configured, otherwise it only warns about pending migrations
+func checkSchema(ctx context.Context, db *database.Store, migrate bool) error {
+	if migrate {
+		return Migrate(ctx, db)
+	}
+
+	pending, err := db.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		log.Printf("%v migrations are pending, run the migrate command to apply them", len(pending))
+	}
+	return nil
+}
+
+// prune deletes sent webhooks past their retention and expired idempotency keys until the context is done
+// A retention of 0 keeps webhooks forever
+func (c *Client) prune(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(PruneInterval)
+	defer ticker.Stop()
+
+	for {
+		if retention > 0 {
+			deleted, err := c.db.Webhooks.Expire(ctx, database.WebhookTimeSent, retention)
+			if err != nil {
+				log.Printf("Could not prune webhooks: %v", err)
+			} else if deleted > 0 {
+				log.Printf("Pruned %v webhooks older than %v", deleted, retention)
+			}
+		}
+
+		if _, err := c.db.Idempotency.Expire(ctx, database.IdempotencyCreatedAt, IdempotencyWindow); err != nil {
+			log.Printf("Could not prune idempotency keys: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"github.com/Aran404/Forwarder/api/database"
+)
+
+// Reconcile compares the deposit wallets on disk and on chain with the payments in the database
+// Pending and expired payments holding funds are rechecked, anything else that does not add up is reported
+func (c *Client) Reconcile(ctx context.Context) (*ReconcileResponse, error) {
+	wallets, err := c.Wallets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &ReconcileResponse{Wallets: len(wallets), Failed: make(map[string]string)}
+	for _, w := range wallets {
+		switch {
+		case w.Error != "":
+			response.Failed[w.Address] = w.Error
+		case w.PaymentID == "":
+			response.Orphaned = append(response.Orphaned, w.Address)
+		case w.Balance <= 0:
+		case w.Status == database.PaymentPending, w.Status == database.PaymentExpired:
+			c.reconcilePayment(ctx, w.PaymentID, response)
+		case w.Status == database.PaymentPaid:
+			response.Unforwarded = append(response.Unforwarded, w.PaymentID)
+		case w.Status == database.PaymentSending:
+			response.Sending = append(response.Sending, w.PaymentID)
+		case w.Status == database.PaymentForwarded, w.Status == database.PaymentRefunded:
+			response.Leftover = append(response.Leftover, w.PaymentID)
+		}
+	}
+
+	// A payment that still expects or holds funds needs its wallet to move them
+	query := database.Where(database.PaymentStatus.In(database.PaymentPending, database.PaymentSeen, database.PaymentPaid, database.PaymentSending, database.PaymentHeld)).
+		Only(database.PaymentID, database.PaymentAddress)
+	payments, err := c.db.Payments.Find(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range payments {
+		if _, err := os.Stat(walletPath(p.Address)); errors.Is(err, os.ErrNotExist) {
+			response.Missing = append(response.Missing, p.ID)
+		}
+	}
+
+	response.Success = len(response.Failed) == 0
+	return response, nil
+}
+
+// reconcilePayment rechecks a payment whose wallet holds funds and records what came of it
+func (c *Client) reconcilePayment(ctx context.Context, id string, response *ReconcileResponse) {
+	query := database.Where(database.PaymentID.Eq(id))
+	p, err := c.db.Payments.Get(ctx, query)
+	if err == nil {
+		err = c.Recheck(ctx, p)
+	}
+	if err == nil {
+		p, err = c.db.Payments.Get(ctx, query)
+	}
+
+	switch {
+	case err != nil:
+		response.Failed[id] = err.Error()
+	case p.Status == database.PaymentPending, p.Status == database.PaymentExpired:
+		response.Unmatched = append(response.Unmatched, id)
+	default:
+		response.Recovered = append(response.Recovered, id)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+// config returns the config in use, read it once per request so a reload midway does not mix values
+func (c *Client) config() *config.Config {
+	return c.cfg.Load()
+}
+
+// Reload reads the config again and swaps it in if it is valid, an invalid config leaves the current one in place
+// Settings only read on startup keep their value and are returned as pending until a restart
+func (c *Client) Reload() (*ReloadResponse, error) {
+	c.reloading.Lock()
+	defer c.reloading.Unlock()
+
+	current := c.config()
+	next, err := current.Reload()
+	if err != nil {
+		log.Printf("Rejected config reload: %v", err)
+		return nil, err
+	}
+
+	merged, applied, pending := current.Merge(next)
+	c.cfg.Store(merged)
+	c.sol.SetConfig(merged)
+	if c.test != nil {
+		c.test.SetConfig(merged.TestConfig())
+	}
+
+	for _, change := range applied {
+		log.Printf("Config changed %v", change)
+	}
+	for _, change := range pending {
+		log.Printf("Config changed %v, restart to apply", change)
+	}
+	if len(applied) == 0 && len(pending) == 0 {
+		log.Println("Config reloaded, nothing changed")
+	}
+	return &ReloadResponse{Success: true, Applied: applied, Pending: pending}, nil
+}
+
+// reloadOnHangup reloads the config every time the process receives SIGHUP until the context is done
+// The signal is caught before it returns, so a SIGHUP never stops the process
+func (c *Client) reloadOnHangup(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				_, _ = c.Reload()
+			}
+		}
+	}()
+}
+
+func (c *Client) AdminReloadConfig(w http.ResponseWriter, r *http.Request) {
+	response, err := c.Reload()
+	if err != nil {
+		types.BadRequest(w, err)
+		return
+	}
+	SendJSON(w, response)
+}
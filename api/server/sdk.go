@@ -2,75 +2,229 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"net/http"
 	"time"
 
+	"github.com/Aran404/Forwarder/api/database"
 	"github.com/Aran404/Forwarder/api/solana"
 	"github.com/Aran404/Forwarder/api/types"
-	"github.com/gagliardetto/solana-go/rpc"
-	"github.com/gagliardetto/solana-go/rpc/ws"
+	sol "github.com/gagliardetto/solana-go"
 	"github.com/google/uuid"
 )
 
-func (c *Client) ForwardFunds(ctx context.Context, pr *PaymentCreateResponse) error {
-	path := fmt.Sprintf("wal/%v.dat", pr.Address)
-	from, err := c.sol.FromFile(path)
+func walletPath(address string) string {
+	return fmt.Sprintf("wal/%v.dat", address)
+}
+
+// network returns the client payments of a mode are watched and forwarded with
+// It is nil for test payments while test mode is disabled
+func (c *Client) network(mode string) *solana.Client {
+	if mode == database.ModeTest {
+		return c.test
+	}
+	return c.sol
+}
+
+// forwardAddress returns where the funds of payments of a mode go
+func (c *Client) forwardAddress(mode string) string {
+	if mode == database.ModeTest {
+		return c.config().Test.ForwardAddress
+	}
+	return c.config().Forwarder.ForwardAddress
+}
+
+func (c *Client) setPaymentStatus(ctx context.Context, id string, changes ...database.Change[database.Payment]) {
+	if err := c.db.Payments.Update(ctx, database.Where(database.PaymentID.Eq(id)), changes...); err != nil {
+		log.Printf("Could not update payment %v: %v", id, err)
+	}
+}
+
+// addFee records network fees spent on behalf of a payment
+func (c *Client) addFee(ctx context.Context, id string, fees *solana.Fees, lamports uint64) {
+	if fees == nil {
+		return
+	}
+	c.setPaymentStatus(ctx, id, database.PaymentPriorityFee.To(fees.MicroLamports), database.PaymentFeeLamports.Add(lamports))
+}
+
+// claim moves a paid payment to status and returns it as stored, so nothing else sends its funds meanwhile
+// ErrNotFound means it is no longer paid
+func (c *Client) claim(ctx context.Context, id, status string) (*database.Payment, error) {
+	return c.db.Payments.Claim(ctx,
+		database.Where(database.PaymentID.Eq(id), database.PaymentStatus.Eq(database.PaymentPaid)),
+		database.PaymentStatus.To(status),
+	)
+}
+
+// unclaim returns a payment claimed with status to paid, a payment that moved on since is left as it is
+func (c *Client) unclaim(ctx context.Context, id, status string) {
+	query := database.Where(database.PaymentID.Eq(id), database.PaymentStatus.Eq(status))
+	if err := c.db.Payments.Update(ctx, query, database.PaymentStatus.To(database.PaymentPaid)); err != nil && !errors.Is(err, types.ErrNotFound) {
+		log.Printf("Could not release payment %v: %v", id, err)
+	}
+}
+
+// settle records where the funds of a payment claimed with status went
+func (c *Client) settle(ctx context.Context, id, status string, changes ...database.Change[database.Payment]) {
+	query := database.Where(database.PaymentID.Eq(id), database.PaymentStatus.Eq(status))
+	if err := c.db.Payments.Update(ctx, query, changes...); err != nil {
+		log.Printf("Could not update payment %v: %v", id, err)
+	}
+}
+
+// ForwardFunds sends the funds of a paid payment to the forward address
+func (c *Client) ForwardFunds(ctx context.Context, p *database.Payment) error {
+	if c.network(p.Mode) == nil {
+		return types.ErrTestModeDisabled
+	}
+
+	p, err := c.claim(ctx, p.ID, database.PaymentSending)
+	if errors.Is(err, types.ErrNotFound) {
+		return types.ErrNotPaid
+	}
 	if err != nil {
 		return err
 	}
 
-	tx, err := c.sol.SendAllBalance(ctx, from, types.Config.Forwarder.ForwardAddress, false)
+	forward := c.forwardAddress(p.Mode)
+	tx, dispose, err := c.sendAll(ctx, p, forward)
 	if err != nil {
+		c.unclaim(ctx, p.ID, database.PaymentSending)
 		return err
 	}
 
-	log.Printf("Successfully forwarded funds from %v to %v. Transaction: %v", pr.Address, types.Config.Forwarder.ForwardAddress, tx.String())
-	return from.Dispose(ctx)
+	log.Printf("Successfully forwarded funds from %v to %v. Transaction: %v", p.Address, forward, tx.String())
+	c.settle(ctx, p.ID, database.PaymentSending, database.PaymentStatus.To(database.PaymentForwarded), database.PaymentForwardSignature.To(tx.String()))
+	return dispose(ctx)
 }
 
-func (c *Client) HandleWebhookCall(ctx context.Context, b *PaymentCreateBody, r *ws.LogResult, pr *PaymentCreateResponse) bool {
-	tx, err := c.sol.GetTransaction(ctx, r.Value.Signature.String())
-	if err != nil || tx.Meta.Err != nil {
-		return false
+// Refund sends the funds held by the deposit wallet back to the sender
+func (c *Client) Refund(ctx context.Context, p *database.Payment) (*sol.Signature, error) {
+	if c.network(p.Mode) == nil {
+		return nil, types.ErrTestModeDisabled
 	}
 
-	value, err := tx.Value()
+	id := p.ID
+	p, err := c.claim(ctx, id, database.PaymentSending)
+	if errors.Is(err, types.ErrNotFound) {
+		current, err := c.db.Payments.Get(ctx, database.Where(database.PaymentID.Eq(id)).Only(database.PaymentStatus))
+		if err == nil && current.Status == database.PaymentForwarded {
+			return nil, types.ErrAlreadyForwarded
+		}
+		return nil, types.ErrNotRefundable
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	tx, dispose, err := c.sendAll(ctx, p, p.Sender)
+	if err != nil {
+		c.unclaim(ctx, p.ID, database.PaymentSending)
+		return nil, err
+	}
+
+	log.Printf("Refunded payment %v to %v. Transaction: %v", p.ID, p.Sender, tx.String())
+	c.settle(ctx, p.ID, database.PaymentSending, database.PaymentStatus.To(database.PaymentRefunded), database.PaymentRefundSignature.To(tx.String()))
+	return tx, dispose(ctx)
+}
+
+// sendAll sends the whole balance of a payment's deposit wallet and records the fee
+// It returns the transaction and a func disposing of the emptied wallet
+func (c *Client) sendAll(ctx context.Context, p *database.Payment, to string) (*sol.Signature, func(context.Context) error, error) {
+	network := c.network(p.Mode)
+	from, err := network.FromFile(walletPath(p.Address))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, fees, err := network.SendAllBalance(ctx, from, to, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.addFee(ctx, p.ID, fees, fees.Lamports)
+	return tx, from.Dispose, nil
+}
+
+// ProcessSignature credits the payment with the transaction and tracks it until it is finalized
+// The merchant is notified and the funds forwarded at the commitment levels the merchant chose
+// It returns true once the payment is credited and false when the transaction is not for it
+// An error means it could not be decided, such as when an endpoint failed or lags behind, and the signature must be offered again
+func (c *Client) ProcessSignature(ctx context.Context, p *database.Payment, signature string) (bool, error) {
+	sig, err := sol.SignatureFromBase58(signature)
 	if err != nil {
-		return false
+		return false, nil
 	}
 
-	amount := big.NewFloat(b.Amount)
-	if new(big.Float).Mul(amount, IgnoreIotaTxThreshold).Cmp(value) >= 0 {
-		return false
+	status, err := c.network(p.Mode).SignatureStatus(ctx, sig)
+	switch {
+	case err != nil:
+		return false, err
+	case status == nil:
+		return false, types.ErrTransactionUnknown
+	case status.Err != nil:
+		return false, nil
+	}
+
+	value, sender, err := c.inspect(ctx, p, signature, status.ConfirmationStatus)
+	if errors.Is(err, types.ErrTransactionFailed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	fvalue, _ := value.Float64()
-	response := &WebhookResponse{
-		Success:        true,
-		ID:             pr.ID,
-		DesiredAmount:  b.Amount,
-		AmountSent:     fvalue,
-		TransactionID:  r.Value.Signature.String(),
-		Address:        pr.Address,
-		TimeSent:       uint64(time.Now().Unix()),
-		PercentOfTotal: (fvalue / float64(b.Amount)) * 100,
+	if value.Sign() <= 0 || new(big.Float).Mul(big.NewFloat(p.Amount), IgnoreIotaTxThreshold).Cmp(value) >= 0 {
+		return false, nil
 	}
 
-	if new(big.Float).Mul(amount, TransactionThreshold).Cmp(value) >= 0 {
-		response.Error = types.GetProperError(types.ErrTransactionSlipped)
+	amount, _ := value.Float64()
+	tx := &database.Transaction{
+		Signature:  signature,
+		PaymentID:  p.ID,
+		Address:    p.Address,
+		Sender:     sender,
+		Amount:     amount,
+		Commitment: string(status.ConfirmationStatus),
+		CreatedAt:  uint64(time.Now().Unix()),
 	}
 
-	SendWebhook(b.CallbackURI, response)
-	_ = c.db.Write(ctx, "transactions", response)
+	err = c.db.Atomic(ctx, func(ctx context.Context, db *database.Store) error {
+		// The signature is unique, whoever records it first processes the transaction
+		if err := db.Transactions.Insert(ctx, tx); err != nil {
+			return err
+		}
 
-	if err := c.ForwardFunds(ctx, pr); err != nil {
-		return false
+		query := database.Where(database.PaymentID.Eq(p.ID), database.PaymentStatus.In(database.PaymentPending, database.PaymentExpired))
+		return db.Payments.Update(ctx, query,
+			database.PaymentStatus.To(database.PaymentSeen),
+			database.PaymentSender.To(sender),
+			database.PaymentSignature.To(signature),
+			database.PaymentAmountReceived.To(amount),
+		)
+	})
+
+	switch {
+	case errors.Is(err, types.ErrDuplicate):
+		// Already processed after an earlier notification, or by another instance
+		return true, nil
+	case errors.Is(err, types.ErrNotFound):
+		// Already credited with another transaction
+		return true, nil
+	case err != nil:
+		return false, fmt.Errorf("could not credit payment %v: %w", p.ID, err)
 	}
 
-	return true
+	p.Status = database.PaymentSeen
+	p.Signature = signature
+	p.Sender = sender
+	p.AmountReceived = amount
+
+	c.track(func() { c.trackConfirmations(p, sig) })
+	return true, nil
 }
 
 func (c *Client) HandleCreatePayment(w http.ResponseWriter, r *http.Request, b *PaymentCreateBody) {
@@ -78,13 +232,13 @@ func (c *Client) HandleCreatePayment(w http.ResponseWriter, r *http.Request, b *
 		Success: true,
 		ID:      uuid.New().String(),
 		Amount:  b.Amount,
-		Expires: uint64(time.Now().Add(CryptoDeadline).Unix()),
+		Expires: uint64(time.Now().Add(time.Duration(c.config().Server.PaymentDeadline) * time.Second).Unix()),
 	}
 
 	front := c.sol.CreateWallet()
 	response.Address = front.PublicKey.String()
 
-	if _, err := front.WriteTemporary(); err != nil {
+	if _, err := c.sol.WriteTemporary(front); err != nil {
 		types.GInternalServerError(w)
 		return
 	}
@@ -96,17 +250,23 @@ func (c *Client) HandleCreatePayment(w http.ResponseWriter, r *http.Request, b *
 	}
 	response.QRCode = qr
 
-	go func() {
-		ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(CryptoDeadline))
-		defer cancel()
+	payment := &database.Payment{
+		ID:          response.ID,
+		MerchantID:  MerchantFrom(r.Context()).ID,
+		Mode:        KeyFrom(r.Context()).Mode,
+		Status:      database.PaymentPending,
+		Amount:      b.Amount,
+		CallbackURI: b.CallbackURI,
+		Address:     response.Address,
+		CreatedAt:   uint64(time.Now().Unix()),
+		Expires:     response.Expires,
+	}
 
-		c.sol.ListenForTX(ctx, response.Address, func(v *ws.LogResult) bool {
-			if v.Value.Err != nil {
-				return false
-			}
-			return c.HandleWebhookCall(ctx, b, v, response)
-		}, rpc.CommitmentConfirmed)
-	}()
+	if err := c.db.Payments.Insert(r.Context(), payment); err != nil {
+		types.GInternalServerError(w)
+		return
+	}
 
+	c.WatchPayment(payment)
 	SendJSON(w, response)
 }
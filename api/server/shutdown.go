@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/types"
+	sol "github.com/gagliardetto/solana-go"
+)
+
+// Shutdown stops taking payments and lets the requests and forwards in flight finish within the shutdown timeout
+// Watches and confirmation tracking are stopped and left in storage, the next instance resumes them on start
+func (c *Client) Shutdown() error {
+	timeout := time.Duration(c.config().Server.ShutdownTimeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultShutdownTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	c.draining.Store(true)
+	log.Printf("Shutting down, no longer taking payments")
+
+	var err error
+	if c.redirect != nil {
+		_ = c.redirect.Shutdown(ctx)
+	}
+	if c.server != nil {
+		if err = c.server.Shutdown(ctx); err != nil {
+			log.Printf("Could not finish every request: %v", err)
+		}
+	}
+
+	n := c.sol.Checkpoint()
+	if c.test != nil {
+		n += c.test.Checkpoint()
+	}
+	if n > 0 {
+		log.Printf("Checkpointed the cursors of %v addresses", n)
+	}
+	c.stop()
+
+	done := make(chan struct{})
+	go func() {
+		c.tracking.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Printf("Gave up waiting for forwards after %v, the next instance resumes them", timeout)
+		return ctx.Err()
+	}
+	return err
+}
+
+// stop cancels the background work, track runs nothing afterwards
+func (c *Client) stop() {
+	c.stopping.Lock()
+	defer c.stopping.Unlock()
+	c.cancel()
+}
+
+// resume picks up the payments a previous instance left unfinished
+// Pending payments are watched from their stored cursor, seen ones tracked and paid ones forwarded
+func (c *Client) resume(ctx context.Context) error {
+	query := database.Where(database.PaymentStatus.In(database.PaymentPending, database.PaymentSeen, database.PaymentPaid))
+	payments, err := c.db.Payments.Find(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, p := range payments {
+		if c.network(p.Mode) == nil {
+			log.Printf("Leaving test payment %v %v, test mode is disabled", p.ID, p.Status)
+			continue
+		}
+
+		switch p.Status {
+		case database.PaymentPending:
+			if now.Before(time.Unix(int64(p.Expires), 0)) {
+				c.WatchPayment(p)
+			} else {
+				c.track(func() { c.expireMissed(p) })
+			}
+		case database.PaymentSeen:
+			sig, err := sol.SignatureFromBase58(p.Signature)
+			if err != nil {
+				log.Printf("Could not resume payment %v: %v", p.ID, err)
+				continue
+			}
+			c.track(func() { c.trackConfirmations(p, sig) })
+		case database.PaymentPaid:
+			c.track(func() { c.forwardPaid(p) })
+		}
+	}
+
+	if len(payments) > 0 {
+		log.Printf("Resumed %v unfinished payments", len(payments))
+	}
+	return nil
+}
+
+// expireMissed rechecks a payment that expired while no instance was watching it, and expires it if nothing arrived
+func (c *Client) expireMissed(p *database.Payment) {
+	ctx, cancel := context.WithTimeout(c.ctx, DeadlineContext)
+	defer cancel()
+
+	if err := c.Recheck(ctx, p); err != nil {
+		log.Printf("Could not recheck expired payment %v: %v", p.ID, err)
+		return
+	}
+
+	query := database.Where(database.PaymentID.Eq(p.ID), database.PaymentStatus.Eq(database.PaymentPending))
+	if err := c.db.Payments.Update(ctx, query, database.PaymentStatus.To(database.PaymentExpired)); err != nil && !errors.Is(err, types.ErrNotFound) {
+		log.Printf("Could not expire payment %v: %v", p.ID, err)
+	}
+}
@@ -0,0 +1,269 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/solana"
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+// Sweeper accumulates paid payments and forwards them in batched transactions
+// Payments that fail to sweep are queued again and retried with backoff
+type Sweeper struct {
+	c        *Client
+	maxDelay time.Duration
+	minBatch int
+
+	mu    sync.Mutex
+	queue []*queued
+
+	flushing sync.Mutex
+}
+
+// queued is a payment waiting in the sweep queue
+type queued struct {
+	payment  *database.Payment
+	added    time.Time
+	attempts int       // Failed sweeps so far
+	retryAt  time.Time // Not swept before then after a failure
+}
+
+func NewSweeper(c *Client) *Sweeper {
+	s := &Sweeper{
+		c:        c,
+		maxDelay: time.Duration(c.config().Sweep.MaxDelay) * time.Second,
+		minBatch: c.config().Sweep.MinBatch,
+	}
+
+	if s.maxDelay <= 0 {
+		s.maxDelay = DefaultSweepDelay
+	}
+	if s.minBatch <= 0 {
+		s.minBatch = 1
+	}
+	return s
+}
+
+// Add queues a paid payment to be swept
+func (s *Sweeper) Add(p *database.Payment) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, &queued{payment: p, added: time.Now()})
+}
+
+// retry queues a payment again after a failed sweep, waiting twice as long after each failure
+func (s *Sweeper) retry(q *queued, reason string) {
+	q.attempts++
+	delay := min(SweepRetryDelay<<(q.attempts-1), MaxSweepRetryDelay)
+	if delay <= 0 {
+		delay = MaxSweepRetryDelay
+	}
+	q.retryAt = time.Now().Add(delay)
+	log.Printf("Could not sweep payment %v, retrying in %v: %v", q.payment.ID, delay, reason)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, q)
+}
+
+// due checks if enough payments are ready, or one has waited long enough or is being retried
+func (s *Sweeper) due() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	ready, urgent := 0, false
+	for _, q := range s.queue {
+		if q.retryAt.After(now) {
+			continue
+		}
+		ready++
+		urgent = urgent || q.attempts > 0 || now.Sub(q.added) >= s.maxDelay
+	}
+	return ready >= s.minBatch || (ready > 0 && urgent)
+}
+
+// take removes the payments that are ready from the queue, those waiting to be retried stay
+func (s *Sweeper) take(all bool) []*queued {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var batch, waiting []*queued
+	for _, q := range s.queue {
+		if all || !q.retryAt.After(now) {
+			batch = append(batch, q)
+		} else {
+			waiting = append(waiting, q)
+		}
+	}
+	s.queue = waiting
+	return batch
+}
+
+// Run flushes the queue once it holds enough payments or the oldest has waited long enough
+func (s *Sweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(SweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// A sweep that started finishes even if ctx is done meanwhile, shutdown waits for it
+			if s.due() && s.c.begin() {
+				s.Flush(context.WithoutCancel(ctx))
+				s.c.tracking.Done()
+			}
+		}
+	}
+}
+
+// Flush sweeps everything that is ready
+func (s *Sweeper) Flush(ctx context.Context) *SweepResponse {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	batch := s.take(false)
+	payments := make([]*database.Payment, 0, len(batch))
+	for _, q := range batch {
+		payments = append(payments, q.payment)
+	}
+	return s.sweep(ctx, batch, payments)
+}
+
+// FlushAll sweeps every paid payment, queued or not
+func (s *Sweeper) FlushAll(ctx context.Context) (*SweepResponse, error) {
+	s.flushing.Lock()
+	defer s.flushing.Unlock()
+
+	payments, err := s.c.db.Payments.Find(ctx, database.Where(database.PaymentStatus.Eq(database.PaymentPaid)))
+	if err != nil {
+		return nil, err
+	}
+	return s.sweep(ctx, s.take(true), payments), nil
+}
+
+// sweep forwards the payments and queues the ones that failed again, batch is what was taken from the queue
+func (s *Sweeper) sweep(ctx context.Context, batch []*queued, payments []*database.Payment) *SweepResponse {
+	response := s.c.sweepPayments(ctx, payments)
+
+	byID := make(map[string]*queued, len(payments))
+	for _, q := range batch {
+		byID[q.payment.ID] = q
+	}
+	for _, p := range payments {
+		reason, failed := response.Failed[p.ID]
+		if !failed {
+			continue
+		}
+
+		q, ok := byID[p.ID]
+		if !ok {
+			q = &queued{payment: p, added: time.Now()}
+		}
+		s.retry(q, reason)
+	}
+	return response
+}
+
+func (c *Client) sweepPayments(ctx context.Context, payments []*database.Payment) *SweepResponse {
+	response := &SweepResponse{Success: true, Failed: make(map[string]string)}
+	if len(payments) == 0 {
+		return response
+	}
+
+	// Payments may have been refunded or held since they were queued, only the ones still paid are claimed
+	claimed := make([]*database.Payment, 0, len(payments))
+	for _, q := range payments {
+		p, err := c.claim(ctx, q.ID, database.PaymentSending)
+		switch {
+		case errors.Is(err, types.ErrNotFound):
+		case err != nil:
+			response.Failed[q.ID] = err.Error()
+		default:
+			claimed = append(claimed, p)
+		}
+	}
+
+	// Each mode is swept on its own network to its own forward address
+	byMode := make(map[string][]*database.Payment)
+	for _, p := range claimed {
+		byMode[p.Mode] = append(byMode[p.Mode], p)
+	}
+	for mode, payments := range byMode {
+		network := c.network(mode)
+		if network == nil {
+			for _, p := range payments {
+				response.Failed[p.ID] = types.ErrTestModeDisabled.Error()
+				c.unclaim(ctx, p.ID, database.PaymentSending)
+			}
+			continue
+		}
+		c.sweepNetwork(ctx, network, c.forwardAddress(mode), payments, response)
+	}
+	return response
+}
+
+// sweepNetwork forwards the claimed payments of one network and records the outcome of each in response
+func (c *Client) sweepNetwork(ctx context.Context, network *solana.Client, forward string, payments []*database.Payment, response *SweepResponse) {
+	paths := make([]string, 0, len(payments))
+	byAddress := make(map[string]*database.Payment, len(payments))
+	for _, p := range payments {
+		paths = append(paths, walletPath(p.Address))
+		byAddress[p.Address] = p
+	}
+
+	// A wallet file that cannot be read only fails its own payment
+	wallets, unreadable := network.FromFiles(paths...)
+	for _, p := range payments {
+		if err, ok := unreadable[walletPath(p.Address)]; ok {
+			response.Failed[p.ID] = err.Error()
+			c.unclaim(ctx, p.ID, database.PaymentSending)
+		}
+	}
+	if len(wallets) == 0 {
+		return
+	}
+
+	for _, result := range network.SweepWallets(ctx, wallets, forward) {
+		for i, w := range result.Wallets {
+			p := byAddress[w.PublicKey.String()]
+			if result.Err != nil {
+				response.Failed[p.ID] = result.Err.Error()
+				c.unclaim(ctx, p.ID, database.PaymentSending)
+				continue
+			}
+
+			c.settle(ctx, p.ID, database.PaymentSending, database.PaymentStatus.To(database.PaymentForwarded), database.PaymentForwardSignature.To(result.Signature.String()))
+			if result.Fees != nil {
+				c.addFee(ctx, p.ID, result.Fees, feeShare(result.Fees.Lamports, len(result.Wallets), i))
+			}
+			if err := w.Dispose(ctx); err != nil {
+				log.Printf("Could not dispose wallet %v: %v", p.Address, err)
+			}
+			response.Swept = append(response.Swept, p.ID)
+		}
+
+		if result.Err != nil {
+			log.Printf("Could not sweep %v wallets to %v: %v", len(result.Wallets), forward, result.Err)
+			continue
+		}
+		log.Printf("Swept %v wallets to %v. Transaction: %v", len(result.Wallets), forward, result.Signature.String())
+	}
+}
+
+// feeShare splits a batch fee evenly, the first payment takes the remainder
+func feeShare(fee uint64, n, i int) uint64 {
+	share := fee / uint64(n)
+	if i == 0 {
+		share += fee % uint64(n)
+	}
+	return share
+}
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/database"
+	sol "github.com/Aran404/Forwarder/api/solana"
+	"github.com/gagliardetto/solana-go"
+)
+
+// fakeCluster answers the RPC calls of a sweep, confirming every transaction it is sent
+type fakeCluster struct {
+	server *httptest.Server
+	fee    uint64
+
+	mu        sync.Mutex
+	balances  map[string]uint64
+	transfers map[string]uint64 // Lamports sent from each address
+	reject    bool              // sendTransaction fails
+}
+
+func newFakeCluster(t *testing.T) *fakeCluster {
+	t.Helper()
+	f := &fakeCluster{fee: 5000, balances: make(map[string]uint64), transfers: make(map[string]uint64)}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func (f *fakeCluster) serve(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var first string
+	if len(req.Params) > 0 {
+		_ = json.Unmarshal(req.Params[0], &first)
+	}
+	value := func(v any) any { return map[string]any{"context": map[string]any{"slot": 100}, "value": v} }
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var result any
+	switch req.Method {
+	case "getHealth":
+		result = "ok"
+	case "getSlot":
+		result = 100
+	case "getBalance":
+		result = value(f.balances[first])
+	case "getLatestBlockhash":
+		result = value(map[string]any{"blockhash": solana.Hash{1}.String(), "lastValidBlockHeight": 1000})
+	case "getRecentPrioritizationFees":
+		result = []any{}
+	case "getFeeForMessage":
+		result = value(f.fee)
+	case "isBlockhashValid":
+		result = value(true)
+	case "getSignatureStatuses":
+		result = value([]any{map[string]any{"slot": 100, "err": nil, "confirmationStatus": "finalized"}})
+	case "sendTransaction":
+		if f.reject {
+			_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "error": map[string]any{"code": -32002, "message": "Transaction simulation failed"}})
+			return
+		}
+		tx, err := solana.TransactionFromBase64(first)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.record(tx)
+		result = tx.Signatures[0].String()
+	default:
+		http.Error(w, "unknown method "+req.Method, http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+// record keeps the lamports of every system transfer in tx, f.mu must be held
+func (f *fakeCluster) record(tx *solana.Transaction) {
+	for _, inst := range tx.Message.Instructions {
+		program := tx.Message.AccountKeys[inst.ProgramIDIndex]
+		if program != solana.SystemProgramID || len(inst.Data) != 12 || binary.LittleEndian.Uint32(inst.Data) != 2 {
+			continue
+		}
+		from := tx.Message.AccountKeys[inst.Accounts[0]].String()
+		f.transfers[from] += binary.LittleEndian.Uint64(inst.Data[4:])
+	}
+}
+
+// sweeping returns a client sweeping to a fake cluster, with wallet files kept in a temporary directory
+func sweeping(t *testing.T, f *fakeCluster) *Client {
+	t.Helper()
+	dir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(dir) })
+	if err := os.Mkdir("wal", 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	interval := sol.ConfirmPollInterval
+	sol.ConfirmPollInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sol.ConfirmPollInterval = interval })
+
+	cfg := config.Default()
+	cfg.RatelimitEvery = 0
+	cfg.Forwarder.ForwardAddress = solana.NewWallet().PublicKey().String()
+	cfg.RPC.Endpoints = []config.RPCEndpoint{{HTTP: f.server.URL}}
+
+	s, err := sol.NewClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+
+	c := &Client{sol: s, db: database.NewStore(database.NewMemory())}
+	c.cfg.Store(cfg)
+	if _, err := c.db.Migrate(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// paid stores a paid payment whose deposit wallet holds lamports, its wallet file is only written if saved
+func paid(t *testing.T, c *Client, f *fakeCluster, id string, lamports uint64, saved bool) *database.Payment {
+	t.Helper()
+	w := c.sol.CreateWallet()
+	p := &database.Payment{ID: id, MerchantID: "m1", Status: database.PaymentPaid, Address: w.PublicKey.String(), CreatedAt: uint64(time.Now().Unix())}
+	if err := c.db.Payments.Insert(context.Background(), p); err != nil {
+		t.Fatal(err)
+	}
+	if saved {
+		if err := c.sol.WriteWallet(w, walletPath(p.Address)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.balances[p.Address] = lamports
+	return p
+}
+
+func status(t *testing.T, c *Client, id string) *database.Payment {
+	t.Helper()
+	p, err := c.db.Payments.Get(context.Background(), database.Where(database.PaymentID.Eq(id)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSweeperDue(t *testing.T) {
+	c := &Client{}
+	cfg := config.Default()
+	cfg.Sweep.MinBatch, cfg.Sweep.MaxDelay = 3, 3600
+	c.cfg.Store(cfg)
+	s := NewSweeper(c)
+
+	s.Add(&database.Payment{ID: "a"})
+	s.Add(&database.Payment{ID: "b"})
+	if s.due() {
+		t.Error("due below the minimum batch")
+	}
+	s.Add(&database.Payment{ID: "c"})
+	if !s.due() {
+		t.Error("not due at the minimum batch")
+	}
+
+	// One payment is enough once it waited the max delay
+	s = NewSweeper(c)
+	s.queue = []*queued{{payment: &database.Payment{ID: "old"}, added: time.Now().Add(-2 * time.Hour)}}
+	if !s.due() {
+		t.Error("not due past the max delay")
+	}
+
+	// A payment waiting for its retry is neither due nor taken, unless everything is
+	s.queue = []*queued{{payment: &database.Payment{ID: "failed"}, added: time.Now(), attempts: 1, retryAt: time.Now().Add(time.Minute)}}
+	if s.due() || len(s.take(false)) != 0 {
+		t.Error("payment waiting to be retried was due")
+	}
+	if taken := s.take(true); len(taken) != 1 || len(s.queue) != 0 {
+		t.Errorf("took %v payments leaving %v, want the waiting one taken", len(taken), len(s.queue))
+	}
+}
+
+func TestSweeperRetryBacksOff(t *testing.T) {
+	c := &Client{}
+	c.cfg.Store(config.Default())
+	s := NewSweeper(c)
+
+	q := &queued{payment: &database.Payment{ID: "a"}, added: time.Now()}
+	for attempt, want := range []time.Duration{SweepRetryDelay, 2 * SweepRetryDelay, 4 * SweepRetryDelay} {
+		start := time.Now()
+		s.retry(q, "failed")
+		if wait := q.retryAt.Sub(start); wait < want || wait > want+time.Second {
+			t.Errorf("attempt %v waits %v, want %v", attempt+1, wait, want)
+		}
+	}
+
+	q.attempts = 40
+	s.retry(q, "failed")
+	if wait := time.Until(q.retryAt); wait > MaxSweepRetryDelay {
+		t.Errorf("waits %v, want at most %v", wait, MaxSweepRetryDelay)
+	}
+	if len(s.queue) != 4 {
+		t.Errorf("queued %v times, want every retry queued", len(s.queue))
+	}
+}
+
+func TestSweeperForwardsBatch(t *testing.T) {
+	f := newFakeCluster(t)
+	c := sweeping(t, f)
+	s := NewSweeper(c)
+
+	small := paid(t, c, f, "small", 1007919, true)
+	large := paid(t, c, f, "large", 2000000, true)
+	s.Add(small)
+	s.Add(large)
+
+	response := s.Flush(context.Background())
+	if len(response.Swept) != 2 || len(response.Failed) != 0 {
+		t.Fatalf("swept %v and failed %v, want both swept", response.Swept, response.Failed)
+	}
+
+	// The wallet with the highest balance pays the fee, every other one is sent in full
+	f.mu.Lock()
+	if f.transfers[small.Address] != 1007919 || f.transfers[large.Address] != 2000000-f.fee {
+		t.Errorf("sent %v and %v, want 1007919 and %v", f.transfers[small.Address], f.transfers[large.Address], 2000000-f.fee)
+	}
+	f.mu.Unlock()
+
+	for _, p := range []*database.Payment{small, large} {
+		got := status(t, c, p.ID)
+		if got.Status != database.PaymentForwarded || got.ForwardSignature == "" || got.FeeLamports != f.fee/2 {
+			t.Errorf("%v is %v with signature %q and fee %v, want forwarded with half the fee", p.ID, got.Status, got.ForwardSignature, got.FeeLamports)
+		}
+		if _, err := os.Stat(walletPath(p.Address)); !os.IsNotExist(err) {
+			t.Errorf("wallet of %v was not disposed: %v", p.ID, err)
+		}
+	}
+	if len(s.queue) != 0 {
+		t.Errorf("%v payments left queued", len(s.queue))
+	}
+}
+
+func TestSweeperRequeuesFailures(t *testing.T) {
+	f := newFakeCluster(t)
+	c := sweeping(t, f)
+	s := NewSweeper(c)
+
+	unreadable := paid(t, c, f, "unreadable", 1000000, false)
+	rejected := paid(t, c, f, "rejected", 1000000, true)
+	refunded := paid(t, c, f, "refunded", 1000000, true)
+	c.setPaymentStatus(context.Background(), refunded.ID, database.PaymentStatus.To(database.PaymentRefunded))
+	f.mu.Lock()
+	f.reject = true
+	f.mu.Unlock()
+
+	for _, p := range []*database.Payment{unreadable, rejected, refunded} {
+		s.Add(p)
+	}
+	response := s.Flush(context.Background())
+
+	if len(response.Swept) != 0 || len(response.Failed) != 2 {
+		t.Fatalf("swept %v and failed %v, want the unreadable and rejected payments failed", response.Swept, response.Failed)
+	}
+	for _, p := range []*database.Payment{unreadable, rejected} {
+		if got := status(t, c, p.ID); got.Status != database.PaymentPaid {
+			t.Errorf("%v is %v, want it paid again", p.ID, got.Status)
+		}
+	}
+	if got := status(t, c, refunded.ID); got.Status != database.PaymentRefunded {
+		t.Errorf("refunded payment is %v", got.Status)
+	}
+	if _, err := os.Stat(walletPath(rejected.Address)); err != nil {
+		t.Errorf("wallet of a failed sweep is gone: %v", err)
+	}
+
+	// Failures wait for their retry, the refunded payment is dropped
+	if len(s.queue) != 2 || s.due() {
+		t.Errorf("queue holds %v payments, due %v, want the 2 failures waiting", len(s.queue), s.due())
+	}
+	for _, q := range s.queue {
+		if q.attempts != 1 {
+			t.Errorf("%v has %v attempts, want 1", q.payment.ID, q.attempts)
+		}
+	}
+}
+
+func TestFeeShare(t *testing.T) {
+	tests := []struct {
+		fee  uint64
+		n    int
+		want []uint64
+	}{
+		{fee: 5000, n: 1, want: []uint64{5000}},
+		{fee: 5000, n: 4, want: []uint64{1250, 1250, 1250, 1250}},
+		{fee: 5003, n: 4, want: []uint64{1253, 1250, 1250, 1250}},
+		{fee: 2, n: 3, want: []uint64{2, 0, 0}},
+	}
+
+	for _, tt := range tests {
+		var total uint64
+		for i, want := range tt.want {
+			got := feeShare(tt.fee, tt.n, i)
+			if got != want {
+				t.Errorf("feeShare(%v, %v, %v) = %v, want %v", tt.fee, tt.n, i, got, want)
+			}
+			total += got
+		}
+		if total != tt.fee {
+			t.Errorf("shares of %v over %v add up to %v", tt.fee, tt.n, total)
+		}
+	}
+}
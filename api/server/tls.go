@@ -0,0 +1,177 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/database"
+	"github.com/Aran404/Forwarder/api/types"
+)
+
+// certificates serves the certificate and client CAs from their files, loading them again once a file changes
+// Rotating a certificate only needs its files replaced, no restart or reload
+type certificates struct {
+	cfg config.TLS
+
+	mu       sync.Mutex
+	current  *tls.Config
+	modified time.Time // Newest modification time of the files when they were loaded
+	checked  time.Time
+}
+
+func newCertificates(cfg config.TLS) (*certificates, error) {
+	c := &certificates{cfg: cfg}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// TLSConfig returns the config for the listener, every handshake asks the certificates for its own
+func (c *certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		GetConfigForClient: c.config,
+	}
+}
+
+func (c *certificates) paths() []string {
+	paths := []string{c.cfg.Cert, c.cfg.Key}
+	if c.cfg.ClientCA != "" {
+		paths = append(paths, c.cfg.ClientCA)
+	}
+	return paths
+}
+
+// modTime returns the newest modification time of the files
+func (c *certificates) modTime() (time.Time, error) {
+	var newest time.Time
+	for _, path := range c.paths() {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	return newest, nil
+}
+
+// load reads the files into a new config, c.mu must be held unless c is not shared yet
+func (c *certificates) load() error {
+	modified, err := c.modTime()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.cfg.Cert, c.cfg.Key)
+	if err != nil {
+		return err
+	}
+
+	conf := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if c.cfg.ClientCA != "" {
+		b, err := os.ReadFile(c.cfg.ClientCA)
+		if err != nil {
+			return err
+		}
+
+		conf.ClientCAs = x509.NewCertPool()
+		if !conf.ClientCAs.AppendCertsFromPEM(b) {
+			return fmt.Errorf("%v: no PEM certificates found", c.cfg.ClientCA)
+		}
+
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		if c.cfg.ClientAuth == "optional" {
+			conf.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	c.current, c.modified, c.checked = conf, modified, time.Now()
+	return nil
+}
+
+// config returns the config for a handshake, loading the files again if they changed since the last check
+// A failed load keeps the previous certificate, so a rotation caught halfway is picked up on a later check
+func (c *certificates) config(*tls.ClientHelloInfo) (*tls.Config, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if time.Since(c.checked) < CertCheckInterval {
+		return c.current, nil
+	}
+	c.checked = time.Now()
+
+	if modified, err := c.modTime(); err != nil || modified.Equal(c.modified) {
+		return c.current, nil
+	}
+
+	if err := c.load(); err != nil {
+		log.Printf("Could not reload TLS certificate, keeping the previous one: %v", err)
+		return c.current, nil
+	}
+	log.Printf("Reloaded TLS certificate from %v", c.cfg.Cert)
+	return c.current, nil
+}
+
+// certFingerprint returns the hex sha256 of a certificate
+func certFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// parseFingerprint returns the fingerprint of a PEM certificate
+func parseFingerprint(certificate string) (string, error) {
+	block, _ := pem.Decode([]byte(certificate))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", types.ErrInvalidCertificate
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", types.ErrInvalidCertificate
+	}
+	return certFingerprint(cert), nil
+}
+
+// clientCertBound checks the client certificate of a request against the one bound to the merchant
+// A merchant with a bound certificate needs it, and a certificate is only accepted for the merchant it is bound to
+// Without a certificate the key alone is enough, client_auth decides if such connections get this far
+func clientCertBound(r *http.Request, merchant *database.Merchant) bool {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return merchant.ClientCert == ""
+	}
+	return merchant.ClientCert != "" && certFingerprint(r.TLS.PeerCertificates[0]) == merchant.ClientCert
+}
+
+// redirectHTTPS answers plain HTTP with a redirect to the same URL over HTTPS on the port of address
+// The redirect is permanent and keeps the method, so payment requests are not turned into GETs
+func redirectHTTPS(address string) http.Handler {
+	_, port, _ := net.SplitHostPort(address)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/database"
+)
+
+// writeCertificate writes a self-signed certificate for name and its key, modified at modified
+func writeCertificate(t *testing.T, cfg config.TLS, name string, modified time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeModified(t, cfg.Cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), modified)
+	writeModified(t, cfg.Key, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: b}), modified)
+}
+
+func writeModified(t *testing.T, path string, content []byte, modified time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// served returns the common name of the certificate the next handshake gets
+func served(t *testing.T, c *certificates) string {
+	t.Helper()
+	conf, err := c.config(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(conf.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Subject.CommonName
+}
+
+// due makes the next handshake check the files again
+func due(c *certificates) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked = c.checked.Add(-CertCheckInterval)
+}
+
+func TestCertificatesReload(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLS{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")}
+	start := time.Now().Add(-time.Hour)
+	writeCertificate(t, cfg, "first", start)
+
+	c, err := newCertificates(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := served(t, c); name != "first" {
+		t.Fatalf("serving %v, want first", name)
+	}
+
+	// Files are only looked at once per interval
+	writeCertificate(t, cfg, "second", start.Add(time.Minute))
+	if name := served(t, c); name != "first" {
+		t.Errorf("serving %v before the check is due, want first", name)
+	}
+	due(c)
+	if name := served(t, c); name != "second" {
+		t.Errorf("serving %v after the files changed, want second", name)
+	}
+}
+
+func TestCertificatesKeepPreviousOnFailedLoad(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.TLS{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")}
+	start := time.Now().Add(-time.Hour)
+	writeCertificate(t, cfg, "first", start)
+
+	c, err := newCertificates(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotation caught halfway, the certificate is replaced but the key is not yet
+	writeModified(t, cfg.Cert, []byte("not a certificate"), start.Add(time.Minute))
+	due(c)
+	if name := served(t, c); name != "first" {
+		t.Errorf("serving %v after a failed load, want first", name)
+	}
+
+	writeCertificate(t, cfg, "second", start.Add(2*time.Minute))
+	due(c)
+	if name := served(t, c); name != "second" {
+		t.Errorf("serving %v once the rotation finished, want second", name)
+	}
+}
+
+func TestRedirectHTTPS(t *testing.T) {
+	tests := []struct {
+		address, host, want string
+	}{
+		{":8443", "example.com", "https://example.com:8443/payment/create?x=1"},
+		{":8443", "example.com:8080", "https://example.com:8443/payment/create?x=1"},
+		{":443", "example.com:8080", "https://example.com/payment/create?x=1"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/payment/create?x=1", nil)
+		r.Host = tt.host
+		w := httptest.NewRecorder()
+		redirectHTTPS(tt.address).ServeHTTP(w, r)
+
+		if w.Code != http.StatusPermanentRedirect || w.Header().Get("Location") != tt.want {
+			t.Errorf("%v via %v: got %v to %q, want 308 to %q", tt.host, tt.address, w.Code, w.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+// clientCert writes a certificate for name and returns it parsed, with its PEM
+func clientCert(t *testing.T, name string) (*x509.Certificate, string) {
+	t.Helper()
+	dir := t.TempDir()
+	cfg := config.TLS{Cert: filepath.Join(dir, "cert.pem"), Key: filepath.Join(dir, "key.pem")}
+	writeCertificate(t, cfg, name, time.Now())
+
+	b, err := os.ReadFile(cfg.Cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(b)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, string(b)
+}
+
+func TestClientCertBound(t *testing.T) {
+	acme, acmePEM := clientCert(t, "acme")
+	other, _ := clientCert(t, "other")
+
+	fingerprint, err := parseFingerprint(acmePEM)
+	if err != nil || fingerprint != certFingerprint(acme) {
+		t.Fatalf("fingerprint of the PEM: got %v, %v, want %v", fingerprint, err, certFingerprint(acme))
+	}
+	if _, err := parseFingerprint("not a certificate"); err == nil {
+		t.Error("a certificate that is not PEM was accepted")
+	}
+
+	bound := &database.Merchant{ClientCert: fingerprint}
+	unbound := &database.Merchant{}
+	tests := []struct {
+		name     string
+		cert     *x509.Certificate
+		merchant *database.Merchant
+		want     bool
+	}{
+		{"bound certificate", acme, bound, true},
+		{"certificate of another merchant", other, bound, false},
+		{"no certificate for a bound merchant", nil, bound, false},
+		{"certificate for an unbound merchant", acme, unbound, false},
+		{"no certificate for an unbound merchant", nil, unbound, true},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/payment/1", nil)
+		if tt.cert != nil {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+		}
+		if got := clientCertBound(r, tt.merchant); got != tt.want {
+			t.Errorf("%v: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
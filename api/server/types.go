@@ -1,30 +1,83 @@
 package server
 
 import (
+	"context"
 	"math/big"
+	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Aran404/Forwarder/api/config"
 	"github.com/Aran404/Forwarder/api/database"
 	"github.com/Aran404/Forwarder/api/solana"
-	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go/rpc"
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/httprate"
 	"github.com/gorilla/websocket"
 )
 
 var (
-	ALLOW_LOCAL_HOST = false
+	IgnoreIotaTxThreshold = big.NewFloat(0.02) // If the amount is 2% or less, ignore the transaction. This is to ignore bots.
 
-	CryptoDeadline        = time.Minute * 30
-	MinForward            = types.Config.Forwarder.MinForward                             // Minimum amount to forward in SOL
-	TransactionThreshold  = big.NewFloat(1 - types.Config.Forwarder.TransactionThreshold) // Threshold for transaction values
-	IgnoreIotaTxThreshold = big.NewFloat(0.02)                                            // If the amount is 2% or less, ignore the transaction. This is to ignore bots.
+	KeyPrefix        = "fwd_"
+	KeyRotationGrace = time.Hour * 24 // How long a rotated key keeps working
+	DefaultKeyLimit  = 100            // Requests per minute when none is configured
+
+	IdempotencyHeader = "Idempotency-Key"
+	IdempotencyWindow = time.Hour * 24 // How long the response to an idempotent request is replayed
+	IdempotencyLease  = time.Minute    // How long a request holds its key without a response, after that it counts as crashed
+	MaxIdempotencyKey = 255
+	MaxIdempotentBody = 1 << 20 // Bytes of a request body read to hash it, larger requests are refused
+
+	ScopeCreate = "create"
+	ScopeRead   = "read"
+	ScopeRefund = "refund"
+	AllScopes   = []string{ScopeCreate, ScopeRead, ScopeRefund}
+
+	AdminMode         = "admin" // Admin tokens look like fwd_admin_<base58>
+	RecheckDepth      = 25      // How many signatures of a deposit address are looked at on recheck
+	MaxAdminListLimit = int64(100)
+
+	SweepInterval      = time.Second * 5  // How often the sweeper checks if its queue is due
+	DefaultSweepDelay  = time.Minute * 5  // Longest a paid payment waits in the sweep queue
+	SweepRetryDelay    = time.Second * 30 // Wait before a payment that failed to sweep is tried again, doubled after each failure
+	MaxSweepRetryDelay = time.Minute * 30 // Cap of the doubling wait between sweep attempts
+	PruneInterval      = time.Hour        // How often webhooks and idempotency keys past their retention are deleted
+
+	DetectSubscribe = "subscribe" // Log subscriptions only
+	DetectPoll      = "poll"      // getSignaturesForAddress only
+	DetectFallback  = "fallback"  // Subscriptions, polling while the websocket is down
+	DetectBoth      = "both"      // Subscriptions and polling at once
+
+	DefaultCommitment   = rpc.CommitmentConfirmed
+	CommitmentDropped   = "dropped"        // Webhook commitment of a transaction that never landed
+	DropTimeout         = time.Second * 90 // How long a seen transaction may be missing before it counts as dropped
+	ConfirmationTimeout = time.Minute * 2  // How long a transaction is checked every poll before it is checked less often
+	ConfirmationBackoff = time.Second * 30 // How often a transaction is checked once past the confirmation timeout
+	ForwardTimeout      = time.Minute * 5  // Budget for forwarding a payment including confirmation
+
+	DefaultShutdownTimeout = time.Second * 30 // How long requests and forwards may take to finish on shutdown when none is configured
+	CertCheckInterval      = time.Second * 10 // How often handshakes check if the certificate files changed
 )
 
 type Client struct {
-	upgrader *websocket.Upgrader
-	http     *chi.Mux
-	sol      *solana.Client
-	db       *database.Connection
+	cfg       atomic.Pointer[config.Config] // Swapped whole on reload
+	reloading sync.Mutex
+	tracking  sync.WaitGroup // Confirmation tracking and forwarding in flight
+	stopping  sync.RWMutex   // Held by track so nothing is added once stopped
+	draining  atomic.Bool    // Set on shutdown, new payments are refused
+	ctx       context.Context
+	cancel    context.CancelFunc // Stops watches and confirmation tracking
+	upgrader  *websocket.Upgrader
+	server    *http.Server
+	redirect  *http.Server // Plain HTTP to HTTPS, nil unless configured
+	http      *chi.Mux
+	limiter   *httprate.RateLimiter
+	sweeper   *Sweeper
+	sol       *solana.Client
+	test      *solana.Client // Test network, nil unless test mode is enabled
+	db        *database.Store
 }
 
 type PaymentCreateBody struct {
@@ -41,14 +94,120 @@ type PaymentCreateResponse struct {
 	Expires uint64  `json:"expires"`
 }
 
-type WebhookResponse struct {
-	Success        bool    `json:"success" bson:"success"`
-	ID             string  `json:"id" bson:"id"`
-	Error          any     `json:"error" bson:"error"`
-	DesiredAmount  float64 `json:"desired_amount" bson:"desired_amount"`
-	AmountSent     float64 `json:"amount_sent" bson:"amount_sent"`
-	TransactionID  string  `json:"transaction_id" bson:"transaction_id"`
-	Address        string  `json:"address" bson:"address"`
-	TimeSent       uint64  `json:"time_sent" bson:"time_sent"`
-	PercentOfTotal float64 `json:"percent_of_total" bson:"percent_of_total"`
+type KeyRotateResponse struct {
+	Success bool             `json:"success"`
+	Key     string           `json:"key"`
+	Info    *database.APIKey `json:"info"`
+}
+
+type RefundResponse struct {
+	Success   bool   `json:"success"`
+	ID        string `json:"id"`
+	Signature string `json:"signature"`
+}
+
+type SuccessResponse struct {
+	Success bool `json:"success"`
+}
+
+type ReloadResponse struct {
+	Success bool            `json:"success"`
+	Applied []config.Change `json:"applied"` // Settings in effect from now on
+	Pending []config.Change `json:"pending"` // Changed settings only read on startup
+}
+
+type HealthResponse struct {
+	Success         bool    `json:"success"`
+	Database        string  `json:"database"`
+	Solana          string  `json:"solana"`
+	Slot            uint64  `json:"slot"`
+	PendingPayments int64   `json:"pending_payments"`
+	FeePayer        string  `json:"fee_payer,omitempty"`
+	FeePayerBalance float64 `json:"fee_payer_balance,omitempty"`
+
+	Endpoints []solana.EndpointStatus `json:"endpoints"`
+	Watcher   solana.WatcherStats     `json:"watcher"`
+}
+
+type AdminPaymentResponse struct {
+	Payment      *database.Payment       `json:"payment"`
+	Transactions []*database.Transaction `json:"transactions"`
+	Webhooks     []*database.Webhook     `json:"webhooks"`
+}
+
+type WalletResponse struct {
+	Address   string  `json:"address"`
+	Balance   float64 `json:"balance"`
+	PaymentID string  `json:"payment_id,omitempty"`
+	Status    string  `json:"status,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type SweepResponse struct {
+	Success bool              `json:"success"`
+	Swept   []string          `json:"swept"`
+	Failed  map[string]string `json:"failed"`
+}
+
+type ReconcileResponse struct {
+	Success     bool              `json:"success"`
+	Wallets     int               `json:"wallets"`     // Deposit wallets looked at
+	Recovered   []string          `json:"recovered"`   // Payments credited with a transaction that had been missed
+	Unmatched   []string          `json:"unmatched"`   // Pending or expired payments holding funds no transaction accounts for
+	Unforwarded []string          `json:"unforwarded"` // Paid payments whose funds wait to be forwarded
+	Leftover    []string          `json:"leftover"`    // Forwarded or refunded payments whose wallet still holds funds
+	Sending     []string          `json:"sending"`     // Payments whose forward or refund stopped midway, their wallet still holds funds
+	Orphaned    []string          `json:"orphaned"`    // Wallets without a payment
+	Missing     []string          `json:"missing"`     // Payments expecting or holding funds whose wallet file is gone
+	Failed      map[string]string `json:"failed"`      // Wallets and payments that could not be checked
+}
+
+type ReplayResponse struct {
+	Success bool `json:"success"`
+	Sent    int  `json:"sent"`
+}
+
+type MerchantSettingsBody struct {
+	NotifyCommitment  string `json:"notify_commitment"`
+	ForwardCommitment string `json:"forward_commitment"`
+}
+
+type AdminMerchantBody struct {
+	Name string `json:"name"`
+}
+
+type AdminCertBody struct {
+	Certificate string `json:"certificate"` // PEM client certificate, empty to unbind
+}
+
+type AdminMerchantResponse struct {
+	Success  bool               `json:"success"`
+	Merchant *database.Merchant `json:"merchant"`
+	Keys     map[string]string  `json:"keys"`
+}
+
+type AdminKeyBody struct {
+	Mode      string   `json:"mode"`
+	Scopes    []string `json:"scopes"`
+	RateLimit int      `json:"ratelimit"`
+}
+
+type AdminAccountBody struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+type AdminLookupTableBody struct {
+	Addresses []string `json:"addresses"` // Added on top of the configured addresses
+}
+
+type AdminPrepareBody struct {
+	Kind string `json:"kind"`
+	To   string `json:"to"` // Only used by payouts, refunds go back to the sender
+}
+
+type AdminAccountResponse struct {
+	Success bool            `json:"success"`
+	Token   string          `json:"token"`
+	Admin   *database.Admin `json:"admin"`
 }
@@ -0,0 +1,128 @@
+package solana
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+var (
+	ConfirmPollInterval = time.Second * 2 // How often signature statuses are polled
+	RebroadcastInterval = time.Second * 4 // How often an unconfirmed transaction is resent
+	MaxRebuilds         = 3               // Fresh blockhashes tried before giving up
+	SendCommitment      = rpc.CommitmentConfirmed
+)
+
+var commitmentRanks = map[rpc.ConfirmationStatusType]int{
+	rpc.ConfirmationStatusProcessed: 1,
+	rpc.ConfirmationStatusConfirmed: 2,
+	rpc.ConfirmationStatusFinalized: 3,
+}
+
+// Reached checks if a confirmation status satisfies the commitment
+func Reached(status rpc.ConfirmationStatusType, commitment rpc.CommitmentType) bool {
+	return commitmentRanks[status] >= commitmentRanks[rpc.ConfirmationStatusType(commitment)]
+}
+
+// SignatureStatus returns the status of a signature, nil if the cluster has not seen it
+func (c Client) SignatureStatus(ctx context.Context, sig solana.Signature) (*rpc.SignatureStatusesResult, error) {
+	statuses, err := c.rpc.GetSignatureStatuses(ctx, true, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(statuses.Value) == 0 {
+		return nil, nil
+	}
+	return statuses.Value[0], nil
+}
+
+// SendAndConfirm sends the transaction returned by build and waits until it reaches the commitment
+// The transaction is rebroadcast while its blockhash is valid and rebuilt with a fresh one once it expires
+func (c Client) SendAndConfirm(ctx context.Context, build func(context.Context) (*solana.Transaction, error), commitment rpc.CommitmentType) (*solana.Signature, error) {
+	for attempt := 0; attempt < MaxRebuilds; attempt++ {
+		tx, err := build(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		sig, err := c.confirm(ctx, tx, commitment, c.blockhashExpired(tx))
+		if err == types.ErrTransactionExpired {
+			log.Printf("Transaction %v expired before confirmation, rebuilding (%d/%d)", sig, attempt+1, MaxRebuilds)
+			continue
+		}
+		return sig, err
+	}
+	return nil, types.ErrTransactionExpired
+}
+
+func (c Client) blockhashExpired(tx *solana.Transaction) func(context.Context) bool {
+	return func(ctx context.Context) bool {
+		valid, err := c.rpc.IsBlockhashValid(ctx, tx.Message.RecentBlockhash, rpc.CommitmentProcessed)
+		return err == nil && !valid.Value
+	}
+}
+
+// confirm sends the transaction and polls its status until it reaches the commitment or expired reports true
+func (c Client) confirm(ctx context.Context, tx *solana.Transaction, commitment rpc.CommitmentType, expired func(context.Context) bool) (*solana.Signature, error) {
+	opts := rpc.TransactionOpts{
+		SkipPreflight:       false,
+		PreflightCommitment: rpc.CommitmentConfirmed,
+	}
+
+	sig, err := c.rpc.SendTransactionWithOpts(ctx, tx, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	retries := uint(0)
+	rebroadcast := rpc.TransactionOpts{SkipPreflight: true, MaxRetries: &retries}
+	lastSent := time.Now()
+
+	ticker := time.NewTicker(ConfirmPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return &sig, ctx.Err()
+		case <-ticker.C:
+		}
+
+		status, err := c.SignatureStatus(ctx, sig)
+		if err != nil {
+			log.Printf("Could not get status of %v: %v", sig, err)
+			continue
+		}
+
+		if status != nil {
+			if status.Err != nil {
+				return &sig, fmt.Errorf("%w: %v", types.ErrTransactionFailed, status.Err)
+			}
+			if Reached(status.ConfirmationStatus, commitment) {
+				return &sig, nil
+			}
+			continue
+		}
+
+		if expired(ctx) {
+			// One last look, the transaction may have landed right before it expired
+			if status, err := c.SignatureStatus(ctx, sig); err == nil && status != nil {
+				continue
+			}
+			return &sig, types.ErrTransactionExpired
+		}
+
+		if time.Since(lastSent) >= RebroadcastInterval {
+			if _, err := c.rpc.SendTransactionWithOpts(ctx, tx, rebroadcast); err != nil {
+				log.Printf("Could not rebroadcast %v: %v", sig, err)
+			}
+			lastSent = time.Now()
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package solana
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	EncryptedPrefix = "encrypted:" // Marks a wallet file sealed with a passphrase
+	saltSize        = 16
+)
+
+// sealKey derives the AES key of a wallet file from the passphrase
+func sealKey(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptKey seals an encoded private key with the passphrase
+func EncryptKey(key, passphrase string) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	aead, err := sealKey(passphrase, salt)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := append(append(salt, nonce...), aead.Seal(nil, nonce, []byte(key), nil)...)
+	return EncryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptKey opens a private key sealed by EncryptKey
+func DecryptKey(data, passphrase string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(data, EncryptedPrefix))
+	if err != nil || len(sealed) < saltSize {
+		return "", fmt.Errorf("invalid encrypted wallet")
+	}
+
+	aead, err := sealKey(passphrase, sealed[:saltSize])
+	if err != nil {
+		return "", err
+	}
+
+	sealed = sealed[saltSize:]
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted wallet")
+	}
+
+	key, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", types.ErrWrongPassphrase
+	}
+	return string(key), nil
+}
+
+// Encrypted reports whether the contents of a wallet file are sealed with a passphrase
+func Encrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(EncryptedPrefix))
+}
+
+// WriteWallet writes the wallet to path, sealed when a passphrase is configured
+func (c Client) WriteWallet(w *walletPair, path string) error {
+	data := w.Encode()
+	if passphrase := c.Config().Wallet.Passphrase; passphrase != "" {
+		var err error
+		if data, err = EncryptKey(data, passphrase); err != nil {
+			return err
+		}
+	}
+	return writeFile(path, []byte(data))
+}
+
+// EncryptFile seals a plain wallet file in place with the configured passphrase
+// It returns false for a file that is already encrypted
+func (c Client) EncryptFile(path string) (bool, error) {
+	passphrase := c.Config().Wallet.Passphrase
+	if passphrase == "" {
+		return false, types.ErrWalletLocked
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	if Encrypted(b) {
+		return false, nil
+	}
+
+	key := strings.TrimSpace(string(b))
+	if _, err := solana.PrivateKeyFromBase58(key); err != nil {
+		return false, fmt.Errorf("%v is not a wallet file: %w", path, err)
+	}
+
+	data, err := EncryptKey(key, passphrase)
+	if err != nil {
+		return false, err
+	}
+	return true, writeFile(path, []byte(data))
+}
+
+// writeFile replaces the file through a rename, so a wallet is never left half written
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".wallet-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
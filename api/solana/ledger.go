@@ -3,127 +3,214 @@ package solana
 import (
 	"context"
 	"encoding/json"
-	"math"
 	"math/big"
 
 	"github.com/Aran404/Forwarder/api/types"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
-	"github.com/gagliardetto/solana-go/rpc/ws"
 )
 
-// ListenForEvent listens for a solana event
-func (c Client) ListenForTX(ctx context.Context, address string, callback func(*ws.LogResult) bool, event ...rpc.CommitmentType) error {
-	rpcEvent := rpc.CommitmentType("")
-	if len(event) > 0 {
-		rpcEvent = event[0]
-	}
+var (
+	SignaturePageSize = 1000 // Most signatures getSignaturesForAddress returns at once
+	MaxSignaturePages = 10   // Pages read by one call to SignaturesUntil, a longer history is read over several
+)
 
-	signature := solana.MustPublicKeyFromBase58(address)
-	sub, err := c.ws.LogsSubscribeMentions(signature, rpcEvent)
+// GetTransaction returns the raw transaction
+func (c Client) GetTransaction(ctx context.Context, txID string) (*ledgerResult, error) {
+	version := uint64(0)
+	tx, err := c.rpc.GetParsedTransaction(
+		ctx,
+		solana.MustSignatureFromBase58(txID),
+		&rpc.GetParsedTransactionOpts{
+			Commitment:                     rpc.CommitmentConfirmed,
+			MaxSupportedTransactionVersion: &version,
+		},
+	)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer sub.Unsubscribe()
+	return &ledgerResult{tx}, nil
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
+// Received returns how much the address gained in the transaction in SOL
+// It is read from the balance changes so transfers made through programs or smart wallets count as well
+func (tx ledgerResult) Received(address string) (*big.Float, error) {
+	if tx.Meta == nil {
+		return nil, types.ErrNoMetadata
+	}
+
+	for i, k := range tx.Transaction.Message.AccountKeys {
+		if k.PublicKey.String() != address {
+			continue
 		}
 
-		v, err := sub.Recv(ctx)
-		if err != nil {
-			return err
+		if i >= len(tx.Meta.PreBalances) || i >= len(tx.Meta.PostBalances) {
+			return nil, types.ErrNoMetadata
 		}
 
-		if callback(v) {
-			return nil
+		pre, post := tx.Meta.PreBalances[i], tx.Meta.PostBalances[i]
+		if post <= pre {
+			return new(big.Float), nil
 		}
+		return ConvertLamportToSol(post - pre), nil
 	}
+	return new(big.Float), nil
 }
 
-// GetConfirmations returns the number of confirmations
-func (c Client) GetConfirmations(ctx context.Context, txID string) (int16, error) {
-	signature := solana.MustSignatureFromBase58(txID)
-	statuses, err := c.rpc.GetSignatureStatuses(ctx, true, signature)
-	if err != nil {
-		return -1, err
+// From returns the account that funded the address, found in the outer or inner instructions
+// It falls back to the fee payer when no system transfer to the address is found
+func (tx ledgerResult) From(address string) string {
+	instructions := tx.Transaction.Message.Instructions
+	if tx.Meta != nil {
+		for _, inner := range tx.Meta.InnerInstructions {
+			instructions = append(instructions[:len(instructions):len(instructions)], inner.Instructions...)
+		}
 	}
 
-	if len(statuses.Value) > 0 && statuses.Value[0] != nil {
-		status := statuses.Value[0]
-		if status.ConfirmationStatus == "finalized" || status.ConfirmationStatus == "confirmed" {
-			return math.MaxInt16, nil
+	var (
+		sender  string
+		largest float64
+	)
+	for _, k := range instructions {
+		if k.ProgramId != solana.SystemProgramID {
+			continue
+		}
+
+		parsed, err := parseInstruction(k)
+		if err != nil || parsed == nil {
+			continue
 		}
 
-		if status.Confirmations == nil {
-			return 0, types.ErrInvalidStatus
+		var destination string
+		switch parsed.InstructionType {
+		case "transfer", "transferWithSeed":
+			destination, _ = parsed.Info["destination"].(string)
+		case "createAccount", "createAccountWithSeed":
+			destination, _ = parsed.Info["newAccount"].(string)
+		default:
+			continue
+		}
+
+		lamports, _ := parsed.Info["lamports"].(float64)
+		if destination != address || lamports <= largest {
+			continue
 		}
 
-		return int16(*status.Confirmations), nil
+		if source, ok := parsed.Info["source"].(string); ok {
+			sender, largest = source, lamports
+		}
 	}
 
-	return 0, nil
+	if sender != "" {
+		return sender
+	}
+	return tx.Transaction.Message.AccountKeys[0].PublicKey.String()
 }
 
-// GetTransaction returns the raw transaction
-func (c Client) GetTransaction(ctx context.Context, txID string) (*ledgerResult, error) {
-	version := uint64(0)
-	tx, err := c.rpc.GetParsedTransaction(
-		ctx,
-		solana.MustSignatureFromBase58(txID),
-		&rpc.GetParsedTransactionOpts{
-			Commitment:                     rpc.CommitmentConfirmed,
-			MaxSupportedTransactionVersion: &version,
-		},
-	)
+func parseInstruction(k *rpc.ParsedInstruction) (*rpc.InstructionInfo, error) {
+	if k.Parsed == nil {
+		return nil, nil
+	}
+
+	raw, err := k.Parsed.MarshalJSON()
 	if err != nil {
 		return nil, err
 	}
-	return &ledgerResult{tx}, nil
+
+	var parsed *rpc.InstructionInfo
+	if err := json.Unmarshal(raw, &parsed); err != nil {
+		return nil, err
+	}
+	return parsed, nil
 }
 
-// GetTransactionValue returns the value of the transaction in SOL
-func (tx ledgerResult) Value() (*big.Float, error) {
-	for _, k := range tx.Transaction.Message.Instructions {
-		if k.Parsed == nil {
-			continue
+// Fee returns the transaction fee
+func (tx ledgerResult) Fee() float64 {
+	return float64(tx.Meta.Fee) / float64(solana.LAMPORTS_PER_SOL)
+}
+
+// listable returns the commitment signatures can be listed at, getSignaturesForAddress does not take processed
+func listable(commitment rpc.CommitmentType) rpc.CommitmentType {
+	if commitment == rpc.CommitmentFinalized {
+		return commitment
+	}
+	return rpc.CommitmentConfirmed
+}
+
+// SignaturesUntil returns the successful signatures involving an address newer than until and older than before, oldest first
+// It also returns the newest signature seen, failed or not, to be used as the next cursor
+// Pages are read with before until the cursor is reached, without a cursor only the newest page is read
+// At most MaxSignaturePages are read, if the cursor was not reached by then more is the oldest signature seen
+// Passing it back as before reads the next stretch, the cursor should only move once more comes back empty
+func (c Client) SignaturesUntil(ctx context.Context, address, until, before string, commitment rpc.CommitmentType) (sigs []string, next, more string, err error) {
+	key, err := solana.PublicKeyFromBase58(address)
+	if err != nil {
+		return nil, "", "", types.ErrInvalidAddress
+	}
+
+	limit := SignaturePageSize
+	opts := &rpc.GetSignaturesForAddressOpts{Limit: &limit, Commitment: listable(commitment)}
+	if until != "" {
+		if opts.Until, err = solana.SignatureFromBase58(until); err != nil {
+			return nil, "", "", err
 		}
+	}
+	if before != "" {
+		if opts.Before, err = solana.SignatureFromBase58(before); err != nil {
+			return nil, "", "", err
+		}
+	}
 
-		raw, err := k.Parsed.MarshalJSON()
+	var out []*rpc.TransactionSignature
+	for pages := 1; ; pages++ {
+		page, err := c.rpc.GetSignaturesForAddressWithOpts(ctx, key, opts)
 		if err != nil {
-			return nil, err
+			return nil, "", "", err
 		}
+		out = append(out, page...)
 
-		var parsed *rpc.InstructionInfo
-		if err := json.Unmarshal(raw, &parsed); err != nil {
-			return nil, err
+		if until == "" || len(page) < limit {
+			break
 		}
-
-		if parsed.InstructionType == "transfer" {
-			// Lamports can overflow float64
-			if lamports, ok := parsed.Info["lamports"].(float64); ok {
-				return ConvertLamportToSol(lamports), nil
-			}
+		opts.Before = page[len(page)-1].Signature
+		if pages >= MaxSignaturePages {
+			more = opts.Before.String()
+			break
 		}
 	}
 
-	return nil, nil
-}
+	if len(out) == 0 {
+		return nil, until, "", nil
+	}
 
-// From returns the address of the sender
-func (tx ledgerResult) From() string {
-	return tx.Transaction.Message.AccountKeys[0].PublicKey.String()
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i].Err == nil {
+			sigs = append(sigs, out[i].Signature.String())
+		}
+	}
+	return sigs, out[0].Signature.String(), more, nil
 }
 
-// To returns the address of the receiver
-func (tx ledgerResult) To() string {
-	return tx.Transaction.Message.AccountKeys[1].PublicKey.String()
-}
+// Signatures returns the most recent successful signatures involving an address, oldest first
+func (c Client) Signatures(ctx context.Context, address string, limit int, commitment rpc.CommitmentType) ([]string, error) {
+	out, err := c.rpc.GetSignaturesForAddressWithOpts(
+		ctx,
+		solana.MustPublicKeyFromBase58(address),
+		&rpc.GetSignaturesForAddressOpts{
+			Limit:      &limit,
+			Commitment: listable(commitment),
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
 
-// Fee returns the transaction fee
-func (tx ledgerResult) Fee() float64 {
-	return float64(tx.Meta.Fee) / float64(solana.LAMPORTS_PER_SOL)
+	var sigs []string
+	for i := len(out) - 1; i >= 0; i-- {
+		if out[i].Err != nil {
+			continue
+		}
+		sigs = append(sigs, out[i].Signature.String())
+	}
+	return sigs, nil
 }
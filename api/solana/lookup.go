@@ -0,0 +1,164 @@
+package solana
+
+import (
+	"context"
+	"encoding/binary"
+	"sync"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go"
+	addresslookuptable "github.com/gagliardetto/solana-go/programs/address-lookup-table"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+const MaxLookupExtend = 20 // Addresses added per extend instruction so the transaction stays under the size limit
+
+var LookupTableProgramID = solana.MustPublicKeyFromBase58("AddressLookupTab1e1111111111111111111111111")
+
+// lookupTables holds the address lookup tables used when building transactions
+type lookupTables struct {
+	mu     sync.RWMutex
+	tables map[solana.PublicKey]solana.PublicKeySlice
+}
+
+func (l *lookupTables) get() map[solana.PublicKey]solana.PublicKeySlice {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.tables
+}
+
+func (l *lookupTables) set(table solana.PublicKey, addresses solana.PublicKeySlice) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	tables := make(map[solana.PublicKey]solana.PublicKeySlice, len(l.tables)+1)
+	for k, v := range l.tables {
+		tables[k] = v
+	}
+	tables[table] = addresses
+	l.tables = tables
+}
+
+// CreateLookupTable creates an address lookup table owned and paid for by the fee payer
+func (c Client) CreateLookupTable(ctx context.Context) (string, *solana.Signature, error) {
+	if c.feePayer == nil {
+		return "", nil, types.ErrNoFeePayer
+	}
+
+	slot, err := c.rpc.GetSlot(ctx, rpc.CommitmentFinalized)
+	if err != nil {
+		return "", nil, err
+	}
+
+	seed := binary.LittleEndian.AppendUint64(nil, slot)
+	table, bump, err := solana.FindProgramAddress([][]byte{c.feePayer.PublicKey[:], seed}, LookupTableProgramID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	data := binary.LittleEndian.AppendUint32(nil, 0)
+	data = append(append(data, seed...), bump)
+
+	instructions := []solana.Instruction{c.lookupInstruction(table, data)}
+	sig, err := c.SendAndConfirm(ctx, func(ctx context.Context) (*solana.Transaction, error) {
+		return c.signWithBlockhash(ctx, instructions)
+	}, SendCommitment)
+	return table.String(), sig, err
+}
+
+// ExtendLookupTable appends addresses to a lookup table owned by the fee payer
+func (c Client) ExtendLookupTable(ctx context.Context, table string, addresses []string) error {
+	if c.feePayer == nil {
+		return types.ErrNoFeePayer
+	}
+
+	key, err := solana.PublicKeyFromBase58(table)
+	if err != nil {
+		return err
+	}
+
+	keys := make(solana.PublicKeySlice, 0, len(addresses))
+	for _, a := range addresses {
+		k, err := solana.PublicKeyFromBase58(a)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, k)
+	}
+
+	for start := 0; start < len(keys); start += MaxLookupExtend {
+		chunk := keys[start:min(start+MaxLookupExtend, len(keys))]
+
+		data := binary.LittleEndian.AppendUint32(nil, 2)
+		data = binary.LittleEndian.AppendUint64(data, uint64(len(chunk)))
+		for _, k := range chunk {
+			data = append(data, k[:]...)
+		}
+
+		instructions := []solana.Instruction{c.lookupInstruction(key, data)}
+		if _, err := c.SendAndConfirm(ctx, func(ctx context.Context) (*solana.Transaction, error) {
+			return c.signWithBlockhash(ctx, instructions)
+		}, SendCommitment); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lookupInstruction builds a lookup table program instruction with the fee payer as authority and payer
+func (c Client) lookupInstruction(table solana.PublicKey, data []byte) solana.Instruction {
+	return solana.NewInstruction(
+		LookupTableProgramID,
+		solana.AccountMetaSlice{
+			solana.Meta(table).WRITE(),
+			solana.Meta(c.feePayer.PublicKey).SIGNER(),
+			solana.Meta(c.feePayer.PublicKey).WRITE().SIGNER(),
+			solana.Meta(solana.SystemProgramID),
+		},
+		data,
+	)
+}
+
+// LookupTable returns the addresses stored in a lookup table
+func (c Client) LookupTable(ctx context.Context, table string) ([]string, error) {
+	key, err := solana.PublicKeyFromBase58(table)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := addresslookuptable.GetAddressLookupTableStateWithOpts(ctx, c.rpc, key, &rpc.GetAccountInfoOpts{Commitment: rpc.CommitmentConfirmed})
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := make([]string, 0, len(state.Addresses))
+	for _, a := range state.Addresses {
+		addresses = append(addresses, a.String())
+	}
+	return addresses, nil
+}
+
+// UseLookupTable loads a lookup table so transactions with several transfers are built as v0 messages using it
+func (c Client) UseLookupTable(ctx context.Context, table string) error {
+	addresses, err := c.LookupTable(ctx, table)
+	if err != nil {
+		return err
+	}
+
+	keys := make(solana.PublicKeySlice, 0, len(addresses))
+	for _, a := range addresses {
+		keys = append(keys, solana.MustPublicKeyFromBase58(a))
+	}
+	c.lookups.set(solana.MustPublicKeyFromBase58(table), keys)
+	return nil
+}
+
+// transactionOptions returns the options for a transaction of the bundles paid by payer
+// Lookup tables are only worth it once a transaction carries more than one transfer
+func (c Client) transactionOptions(tb []*TransactionBundle, payer *walletPair) []solana.TransactionOption {
+	opts := []solana.TransactionOption{solana.TransactionPayer(payer.PublicKey)}
+	if tables := c.lookups.get(); len(tables) > 0 && len(tb) > 1 {
+		opts = append(opts, solana.TransactionAddressTables(tables))
+	}
+	return opts
+}
@@ -0,0 +1,22 @@
+package solana
+
+import (
+	"context"
+
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+// Health returns the health reported by the rpc node
+func (c Client) Health(ctx context.Context) (string, error) {
+	return c.rpc.GetHealth(ctx)
+}
+
+// Endpoints returns the state of every RPC endpoint
+func (c Client) Endpoints() []EndpointStatus {
+	return c.pool.Statuses()
+}
+
+// Slot returns the latest confirmed slot
+func (c Client) Slot(ctx context.Context) (uint64, error) {
+	return c.rpc.GetSlot(ctx, rpc.CommitmentConfirmed)
+}
@@ -0,0 +1,175 @@
+package solana
+
+import (
+	"context"
+
+	"github.com/Aran404/Forwarder/api/types"
+	bin "github.com/gagliardetto/binary"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/programs/system"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+const NonceAccountSize = 80
+
+// Nonce is the state of a durable nonce account
+type Nonce struct {
+	Account   solana.PublicKey
+	Authority solana.PublicKey
+	Value     solana.Hash
+}
+
+// CreateNonceAccount creates a nonce account funded and authorized by the fee payer
+func (c Client) CreateNonceAccount(ctx context.Context) (string, *solana.Signature, error) {
+	if c.feePayer == nil {
+		return "", nil, types.ErrNoFeePayer
+	}
+
+	rent, err := c.rpc.GetMinimumBalanceForRentExemption(ctx, NonceAccountSize, rpc.CommitmentConfirmed)
+	if err != nil {
+		return "", nil, err
+	}
+
+	account := c.CreateWallet()
+	instructions := []solana.Instruction{
+		system.NewCreateAccountInstruction(rent, NonceAccountSize, solana.SystemProgramID, c.feePayer.PublicKey, account.PublicKey).Build(),
+		system.NewInitializeNonceAccountInstruction(c.feePayer.PublicKey, account.PublicKey, solana.SysVarRecentBlockHashesPubkey, solana.SysVarRentPubkey).Build(),
+	}
+
+	sig, err := c.SendAndConfirm(ctx, func(ctx context.Context) (*solana.Transaction, error) {
+		return c.signWithBlockhash(ctx, instructions, account)
+	}, SendCommitment)
+	return account.PublicKey.String(), sig, err
+}
+
+// GetNonce returns the current state of a nonce account
+func (c Client) GetNonce(ctx context.Context, address string) (*Nonce, error) {
+	key, err := solana.PublicKeyFromBase58(address)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := c.rpc.GetAccountInfoWithOpts(ctx, key, &rpc.GetAccountInfoOpts{Commitment: rpc.CommitmentConfirmed})
+	if err != nil {
+		return nil, err
+	}
+
+	var account system.NonceAccount
+	if err := bin.NewBinDecoder(info.Value.Data.GetBinary()).Decode(&account); err != nil {
+		return nil, err
+	}
+
+	if account.State != 1 {
+		return nil, types.ErrNonceUninitialized
+	}
+
+	return &Nonce{
+		Account:   key,
+		Authority: account.AuthorizedPubkey,
+		Value:     solana.Hash(account.Nonce),
+	}, nil
+}
+
+// PrepareSendAll builds a durable transaction sending the entire balance of a wallet
+// The fee payer pays the fee, and the transaction stays valid until the nonce is advanced
+func (c Client) PrepareSendAll(ctx context.Context, from *walletPair, to string, nonce *Nonce) (*solana.Transaction, error) {
+	balance, err := c.LamportsAt(ctx, from.PublicKey.String(), rpc.CommitmentConfirmed)
+	if err != nil {
+		return nil, err
+	}
+	return c.BuildDurable(ctx, []*TransactionBundle{{From: from, To: to, Lamports: balance}}, nonce)
+}
+
+// BuildDurable builds a signed transaction that uses a nonce instead of a recent blockhash
+func (c Client) BuildDurable(ctx context.Context, tb []*TransactionBundle, nonce *Nonce) (*solana.Transaction, error) {
+	if c.feePayer == nil || nonce.Authority != c.feePayer.PublicKey {
+		return nil, types.ErrNoFeePayer
+	}
+
+	instructions, err := c.instructions(tb, c.PriorityFee(ctx, tb, c.feePayer))
+	if err != nil {
+		return nil, err
+	}
+
+	advance := system.NewAdvanceNonceAccountInstruction(nonce.Account, solana.SysVarRecentBlockHashesPubkey, nonce.Authority).Build()
+	tx, err := solana.NewTransaction(
+		append([]solana.Instruction{advance}, instructions...),
+		nonce.Value,
+		c.transactionOptions(tb, c.feePayer)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := TransactionSize(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > MaxTransactionSize {
+		return nil, types.ErrTransactionOverboard
+	}
+
+	mappedWallets := c.mapWallets(tb, c.feePayer)
+	_, err = tx.Sign(func(key solana.PublicKey) *solana.PrivateKey {
+		return mappedWallets[key]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// SubmitDurable sends a durable transaction and waits until it reaches the commitment
+// It fails with ErrTransactionExpired if the nonce was advanced without the transaction landing
+func (c Client) SubmitDurable(ctx context.Context, tx *solana.Transaction, nonce string, commitment rpc.CommitmentType) (*solana.Signature, error) {
+	return c.confirm(ctx, tx, commitment, func(ctx context.Context) bool {
+		current, err := c.GetNonce(ctx, nonce)
+		return err == nil && current.Value != tx.Message.RecentBlockhash
+	})
+}
+
+// AdvanceNonce advances a nonce, invalidating every transaction prepared with its current value
+func (c Client) AdvanceNonce(ctx context.Context, nonce string) (*solana.Signature, error) {
+	if c.feePayer == nil {
+		return nil, types.ErrNoFeePayer
+	}
+
+	key, err := solana.PublicKeyFromBase58(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	instructions := []solana.Instruction{
+		system.NewAdvanceNonceAccountInstruction(key, solana.SysVarRecentBlockHashesPubkey, c.feePayer.PublicKey).Build(),
+	}
+	return c.SendAndConfirm(ctx, func(ctx context.Context) (*solana.Transaction, error) {
+		return c.signWithBlockhash(ctx, instructions)
+	}, SendCommitment)
+}
+
+// signWithBlockhash builds a transaction paid by the fee payer with a recent blockhash and signs it
+func (c Client) signWithBlockhash(ctx context.Context, instructions []solana.Instruction, signers ...*walletPair) (*solana.Transaction, error) {
+	recent, err := c.rpc.GetLatestBlockhash(ctx, rpc.CommitmentConfirmed)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := solana.NewTransaction(instructions, recent.Value.Blockhash, solana.TransactionPayer(c.feePayer.PublicKey))
+	if err != nil {
+		return nil, err
+	}
+
+	keys := map[solana.PublicKey]*solana.PrivateKey{c.feePayer.PublicKey: &c.feePayer.PrivateKey}
+	for _, s := range signers {
+		keys[s.PublicKey] = &s.PrivateKey
+	}
+
+	_, err = tx.Sign(func(key solana.PublicKey) *solana.PrivateKey {
+		return keys[key]
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,90 @@
+package solana
+
+import (
+	"context"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go"
+)
+
+type BundleResult struct {
+	Bundle    *TransactionBundle
+	Signature *solana.Signature
+	Err       error
+}
+
+// plannedSize returns the serialized size of the bundles as one transaction
+// The blockhash does not change the size so none is fetched
+func (c Client) plannedSize(tb []*TransactionBundle, payer *walletPair) (int, error) {
+	instructions, err := c.instructions(tb, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if payer == nil {
+		payer = c.feePayer
+	}
+	if payer == nil {
+		payer = tb[0].From
+	}
+
+	tx, err := solana.NewTransaction(instructions, solana.Hash{}, c.transactionOptions(tb, payer)...)
+	if err != nil {
+		return 0, err
+	}
+	return TransactionSize(tx)
+}
+
+// PlanTransactions packs the bundles into as few valid transactions as possible
+// Bundles that cannot fit in a transaction on their own are returned separately
+func (c Client) PlanTransactions(tb []*TransactionBundle, payer *walletPair) ([][]*TransactionBundle, []*TransactionBundle) {
+	var (
+		plan      [][]*TransactionBundle
+		oversized []*TransactionBundle
+	)
+
+next:
+	for _, t := range tb {
+		for i, group := range plan {
+			candidate := append(group[:len(group):len(group)], t)
+			if size, err := c.plannedSize(candidate, payer); err == nil && size <= MaxTransactionSize {
+				plan[i] = candidate
+				continue next
+			}
+		}
+
+		if size, err := c.plannedSize([]*TransactionBundle{t}, payer); err != nil || size > MaxTransactionSize {
+			oversized = append(oversized, t)
+			continue
+		}
+		plan = append(plan, []*TransactionBundle{t})
+	}
+	return plan, oversized
+}
+
+// SendPlanned plans the bundles, sends each transaction and returns a result per bundle in order
+func (c Client) SendPlanned(ctx context.Context, tb []*TransactionBundle, payer *walletPair, simulate bool) []*BundleResult {
+	results := make(map[*TransactionBundle]*BundleResult, len(tb))
+
+	plan, oversized := c.PlanTransactions(tb, payer)
+	for _, t := range oversized {
+		results[t] = &BundleResult{Bundle: t, Err: types.ErrTransactionOverboard}
+	}
+
+	for _, group := range plan {
+		sig, err := c.CreateMTransactions(ctx, group, payer, simulate)
+		if err != nil {
+			sig = nil
+		}
+
+		for _, t := range group {
+			results[t] = &BundleResult{Bundle: t, Signature: sig, Err: err}
+		}
+	}
+
+	ordered := make([]*BundleResult, 0, len(tb))
+	for _, t := range tb {
+		ordered = append(ordered, results[t])
+	}
+	return ordered
+}
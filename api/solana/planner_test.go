@@ -0,0 +1,91 @@
+package solana
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/Aran404/Forwarder/api/config"
+)
+
+func testClient(t *testing.T) Client {
+	t.Helper()
+	c := Client{cfg: new(atomic.Pointer[config.Config]), lookups: &lookupTables{}}
+	c.cfg.Store(config.Default())
+	return c
+}
+
+func bundles(c Client, n int) []*TransactionBundle {
+	to := c.CreateWallet().PublicKey.String()
+	tb := make([]*TransactionBundle, 0, n)
+	for i := 0; i < n; i++ {
+		tb = append(tb, &TransactionBundle{From: c.CreateWallet(), To: to, Lamports: 10_000_000})
+	}
+	return tb
+}
+
+func TestPlanTransactionsPacksBySize(t *testing.T) {
+	c := testClient(t)
+	tb := bundles(c, 40)
+
+	plan, oversized := c.PlanTransactions(tb, nil)
+	if len(oversized) != 0 {
+		t.Fatalf("got %v oversized bundles, want none", len(oversized))
+	}
+	if len(plan) < 2 {
+		t.Fatalf("40 signers fit in %v transactions, want them split", len(plan))
+	}
+
+	planned := make(map[*TransactionBundle]int)
+	for i, group := range plan {
+		size, err := c.plannedSize(group, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size > MaxTransactionSize {
+			t.Errorf("transaction %v is %v bytes, over %v", i, size, MaxTransactionSize)
+		}
+
+		// Every transaction but the last is full, the next bundle would not have fit
+		if i < len(plan)-1 {
+			next := plan[i+1][0]
+			if size, err := c.plannedSize(append(group[:len(group):len(group)], next), nil); err == nil && size <= MaxTransactionSize {
+				t.Errorf("transaction %v has room for another bundle at %v bytes", i, size)
+			}
+		}
+
+		for _, b := range group {
+			planned[b]++
+		}
+	}
+
+	for i, b := range tb {
+		if planned[b] != 1 {
+			t.Errorf("bundle %v planned %v times, want once", i, planned[b])
+		}
+	}
+}
+
+func TestPlanTransactionsKeepsSmallBundlesTogether(t *testing.T) {
+	c := testClient(t)
+	tb := bundles(c, 3)
+
+	plan, oversized := c.PlanTransactions(tb, nil)
+	if len(oversized) != 0 || len(plan) != 1 || len(plan[0]) != 3 {
+		t.Fatalf("got %v transactions and %v oversized, want one transaction of 3", len(plan), len(oversized))
+	}
+}
+
+func TestPlanTransactionsSeparatesUnplannable(t *testing.T) {
+	c := testClient(t)
+	tb := bundles(c, 2)
+	bad := &TransactionBundle{From: c.CreateWallet(), To: "not an address", Lamports: 10_000_000}
+	tb = append(tb, bad)
+
+	plan, oversized := c.PlanTransactions(tb, nil)
+	if len(oversized) != 1 || oversized[0] != bad {
+		t.Fatalf("got oversized %v, want only the bundle that cannot be built", oversized)
+	}
+	if len(plan) != 1 || len(plan[0]) != 2 {
+		t.Fatalf("got %v transactions, want the other two bundles together", len(plan))
+	}
+}
@@ -0,0 +1,386 @@
+package solana
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/jsonrpc"
+	"github.com/gagliardetto/solana-go/rpc/ws"
+	"golang.org/x/time/rate"
+)
+
+const (
+	DefaultHealthInterval   = time.Second * 10
+	HealthTimeout           = time.Second * 5 // Longest each health and slot call of a check may take
+	DefaultMaxSlotLag       = 50
+	DefaultBreakerThreshold = 5
+	DefaultBreakerCooldown  = time.Second * 30
+)
+
+// mutatingMethods are never retried on another endpoint, the first attempt may have reached the cluster
+var mutatingMethods = map[string]bool{
+	"sendTransaction": true,
+	"requestAirdrop":  true,
+}
+
+// unhealthyCodes are JSON-RPC errors caused by the node rather than the request
+var unhealthyCodes = map[int]bool{
+	-32005: true, // Node is unhealthy
+	-32004: true, // Block not available for slot
+}
+
+type endpoint struct {
+	config config.RPCEndpoint
+	client rpc.JSONRPCClient
+	node   *rpc.Client // Health checks go straight to the endpoint
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	healthy   bool
+	lagging   bool
+	slot      uint64
+}
+
+// EndpointStatus describes an endpoint for health reporting
+type EndpointStatus struct {
+	HTTP     string `json:"http"`
+	Weight   int    `json:"weight"`
+	Healthy  bool   `json:"healthy"`
+	Lagging  bool   `json:"lagging"`
+	Open     bool   `json:"open"` // Circuit breaker is open and the endpoint is skipped
+	Failures int    `json:"failures"`
+	Slot     uint64 `json:"slot"`
+}
+
+// Pool spreads requests over several RPC endpoints by weight and fails over between them
+// It implements rpc.JSONRPCClient so it can back an rpc.Client
+type Pool struct {
+	endpoints []*endpoint
+	interval  time.Duration
+	maxLag    uint64
+	threshold int
+	cooldown  time.Duration
+
+	wsMu sync.Mutex
+	ws   *ws.Client
+}
+
+func NewPool(cfg *config.Config) *Pool {
+	p := &Pool{
+		interval:  time.Duration(cfg.RPC.HealthInterval) * time.Second,
+		maxLag:    cfg.RPC.MaxSlotLag,
+		threshold: cfg.RPC.BreakerThreshold,
+		cooldown:  time.Duration(cfg.RPC.BreakerCooldown) * time.Second,
+	}
+
+	if p.interval <= 0 {
+		p.interval = DefaultHealthInterval
+	}
+	if p.maxLag == 0 {
+		p.maxLag = DefaultMaxSlotLag
+	}
+	if p.threshold <= 0 {
+		p.threshold = DefaultBreakerThreshold
+	}
+	if p.cooldown <= 0 {
+		p.cooldown = DefaultBreakerCooldown
+	}
+
+	for _, e := range cfg.RPC.Endpoints {
+		if e.Weight <= 0 {
+			e.Weight = 1
+		}
+
+		client := rpc.NewWithLimiter(
+			e.HTTP,
+			rate.Every(time.Duration(cfg.RatelimitEvery)*time.Second),
+			cfg.RatelimitReset,
+		)
+		p.endpoints = append(p.endpoints, &endpoint{
+			config:  e,
+			client:  client,
+			node:    rpc.NewWithCustomRPCClient(client),
+			healthy: true,
+		})
+	}
+	return p
+}
+
+func (e *endpoint) open() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Now().Before(e.openUntil)
+}
+
+func (e *endpoint) available() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.healthy && !e.lagging && !time.Now().Before(e.openUntil)
+}
+
+func (e *endpoint) success() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.failures = 0
+}
+
+// failure counts a failed request and opens the circuit once there are too many in a row
+func (e *endpoint) failure(threshold int, cooldown time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures++
+	if e.failures >= threshold {
+		e.openUntil = time.Now().Add(cooldown)
+	}
+}
+
+// order returns the endpoints to try, available ones first in a weighted random order
+func (p *Pool) order() []*endpoint {
+	var available, closed, rest []*endpoint
+	for _, e := range p.endpoints {
+		switch {
+		case e.available():
+			available = append(available, e)
+		case !e.open():
+			closed = append(closed, e)
+		default:
+			rest = append(rest, e)
+		}
+	}
+
+	// Nothing is skipped entirely, a degraded endpoint beats no endpoint
+	return append(append(shuffleByWeight(available), closed...), rest...)
+}
+
+func shuffleByWeight(endpoints []*endpoint) []*endpoint {
+	remaining := append([]*endpoint(nil), endpoints...)
+	ordered := make([]*endpoint, 0, len(remaining))
+
+	for len(remaining) > 0 {
+		total := 0
+		for _, e := range remaining {
+			total += e.config.Weight
+		}
+
+		pick, i := rand.Intn(total), 0
+		for ; pick >= remaining[i].config.Weight; i++ {
+			pick -= remaining[i].config.Weight
+		}
+
+		ordered = append(ordered, remaining[i])
+		remaining = append(remaining[:i], remaining[i+1:]...)
+	}
+	return ordered
+}
+
+// endpointFailure checks if an error should count against the endpoint and be retried elsewhere
+func endpointFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+
+	var rpcErr *jsonrpc.RPCError
+	if errors.As(err, &rpcErr) {
+		return unhealthyCodes[rpcErr.Code]
+	}
+	return true
+}
+
+// call runs fn against the endpoints in order until one succeeds
+// Mutating methods are only attempted once
+func (p *Pool) call(ctx context.Context, method string, mutating bool, fn func(rpc.JSONRPCClient) error) error {
+	candidates := p.order()
+	if len(candidates) == 0 {
+		return types.ErrNoEndpoint
+	}
+	if mutating {
+		candidates = candidates[:1]
+	}
+
+	var err error
+	for _, e := range candidates {
+		if err = fn(e.client); !endpointFailure(ctx, err) {
+			e.success()
+			return err
+		}
+
+		e.failure(p.threshold, p.cooldown)
+		log.Printf("RPC %v failed on %v: %v", method, config.RedactURL(e.config.HTTP), err)
+	}
+	return err
+}
+
+func (p *Pool) CallForInto(ctx context.Context, out interface{}, method string, params []interface{}) error {
+	return p.call(ctx, method, mutatingMethods[method], func(client rpc.JSONRPCClient) error {
+		return client.CallForInto(ctx, out, method, params)
+	})
+}
+
+func (p *Pool) CallWithCallback(ctx context.Context, method string, params []interface{}, callback func(*http.Request, *http.Response) error) error {
+	return p.call(ctx, method, mutatingMethods[method], func(client rpc.JSONRPCClient) error {
+		return client.CallWithCallback(ctx, method, params, callback)
+	})
+}
+
+func (p *Pool) CallBatch(ctx context.Context, requests jsonrpc.RPCRequests) (jsonrpc.RPCResponses, error) {
+	mutating := false
+	for _, r := range requests {
+		mutating = mutating || mutatingMethods[r.Method]
+	}
+
+	var responses jsonrpc.RPCResponses
+	err := p.call(ctx, "batch", mutating, func(client rpc.JSONRPCClient) (err error) {
+		responses, err = client.CallBatch(ctx, requests)
+		return err
+	})
+	return responses, err
+}
+
+// Run checks the health and slot of every endpoint until the context is done
+func (p *Pool) Run(ctx context.Context) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.check(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check marks endpoints that report themselves unhealthy or trail the highest slot by more than the maximum lag
+// Endpoints are asked at once, so one hanging endpoint does not hold up the others
+func (p *Pool) check(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.checkEndpoint(ctx, e)
+		}()
+	}
+	wg.Wait()
+
+	var highest uint64
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		highest = max(highest, e.slot)
+		e.mu.Unlock()
+	}
+
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		e.lagging = highest > e.slot+p.maxLag
+		e.mu.Unlock()
+	}
+}
+
+// checkEndpoint records the health and slot of an endpoint, each call is bounded by HealthTimeout
+// A call that times out counts as a failure, unless ctx itself is done
+func (p *Pool) checkEndpoint(ctx context.Context, e *endpoint) {
+	healthCtx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	health, err := e.node.GetHealth(healthCtx)
+	cancel()
+
+	slotCtx, cancel := context.WithTimeout(ctx, HealthTimeout)
+	slot, slotErr := e.node.GetSlot(slotCtx, rpc.CommitmentConfirmed)
+	cancel()
+
+	healthy := err == nil && health == rpc.HealthOk && slotErr == nil
+	if healthy {
+		e.success()
+	} else if endpointFailure(ctx, errors.Join(err, slotErr)) {
+		e.failure(p.threshold, p.cooldown)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.healthy != healthy {
+		log.Printf("RPC endpoint %v healthy: %v", config.RedactURL(e.config.HTTP), healthy)
+	}
+	e.healthy = healthy
+	if slotErr == nil {
+		e.slot = slot
+	}
+}
+
+// Statuses reports the state of every endpoint
+func (p *Pool) Statuses() []EndpointStatus {
+	statuses := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		statuses = append(statuses, EndpointStatus{
+			HTTP:     config.RedactURL(e.config.HTTP),
+			Weight:   e.config.Weight,
+			Healthy:  e.healthy,
+			Lagging:  e.lagging,
+			Open:     time.Now().Before(e.openUntil),
+			Failures: e.failures,
+			Slot:     e.slot,
+		})
+		e.mu.Unlock()
+	}
+	return statuses
+}
+
+// WS returns the websocket connection, dialing the first reachable endpoint when there is none
+func (p *Pool) WS(ctx context.Context) (*ws.Client, error) {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+
+	if p.ws != nil {
+		return p.ws, nil
+	}
+
+	err := types.ErrNoEndpoint
+	for _, e := range p.order() {
+		if e.config.WS == "" {
+			continue
+		}
+
+		var client *ws.Client
+		if client, err = ws.Connect(ctx, e.config.WS); err == nil {
+			p.ws = client
+			return client, nil
+		}
+
+		e.failure(p.threshold, p.cooldown)
+		log.Printf("Could not connect to %v: %v", config.RedactURL(e.config.WS), err)
+	}
+	return nil, err
+}
+
+// Close closes the websocket connection, WS dials a new one if called again
+func (p *Pool) Close() {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+
+	if p.ws != nil {
+		p.ws.Close()
+		p.ws = nil
+	}
+}
+
+// DropWS closes a broken websocket connection so the next call to WS dials again
+func (p *Pool) DropWS(client *ws.Client) {
+	p.wsMu.Lock()
+	defer p.wsMu.Unlock()
+
+	if p.ws == client && client != nil {
+		client.Close()
+		p.ws = nil
+	}
+}
@@ -0,0 +1,94 @@
+package solana
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/gagliardetto/solana-go"
+	computebudget "github.com/gagliardetto/solana-go/programs/compute-budget"
+)
+
+const (
+	ComputeUnitsPerInstruction = 450       // Generous budget for a system transfer
+	DefaultFeePercentile       = 75        // Percentile of recent prioritization fees to pay
+	DefaultMaxPriorityFee      = 1_000_000 // Micro-lamports per compute unit
+)
+
+// Fees describes what a transaction paid to land
+type Fees struct {
+	Lamports      uint64 `json:"lamports" bson:"lamports"` // Total network fee including the priority fee
+	ComputeUnits  uint32 `json:"compute_units" bson:"compute_units"`
+	MicroLamports uint64 `json:"micro_lamports" bson:"micro_lamports"` // Priority fee per compute unit
+}
+
+func computeUnits(tb []*TransactionBundle) uint32 {
+	return uint32(ComputeUnitsPerInstruction * (len(tb) + 2))
+}
+
+// PriorityFee returns the compute unit price for a transaction touching the bundles' accounts
+// It is derived from recent prioritization fees and capped by the configuration
+func (c Client) PriorityFee(ctx context.Context, tb []*TransactionBundle, payer *walletPair) uint64 {
+	cfg := c.Config().PriorityFee
+	if !cfg.Enabled {
+		return 0
+	}
+
+	seen := make(map[solana.PublicKey]bool)
+	accounts := solana.PublicKeySlice{payer.PublicKey}
+	for _, t := range tb {
+		to, err := solana.PublicKeyFromBase58(t.To)
+		if err != nil {
+			continue
+		}
+		for _, k := range []solana.PublicKey{t.From.PublicKey, to} {
+			if !seen[k] {
+				seen[k] = true
+				accounts = append(accounts, k)
+			}
+		}
+	}
+
+	recent, err := c.rpc.GetRecentPrioritizationFees(ctx, accounts)
+	if err != nil {
+		log.Printf("Could not get recent prioritization fees: %v", err)
+		return 0
+	}
+
+	if len(recent) == 0 {
+		return 0
+	}
+
+	fees := make([]uint64, 0, len(recent))
+	for _, v := range recent {
+		fees = append(fees, v.PrioritizationFee)
+	}
+	sort.Slice(fees, func(i, j int) bool { return fees[i] < fees[j] })
+
+	percentile := cfg.Percentile
+	if percentile <= 0 || percentile > 100 {
+		percentile = DefaultFeePercentile
+	}
+
+	maximum := cfg.MaxMicroLamports
+	if maximum == 0 {
+		maximum = DefaultMaxPriorityFee
+	}
+
+	price := fees[(len(fees)-1)*percentile/100]
+	if price > maximum {
+		price = maximum
+	}
+	return price
+}
+
+func (c Client) budgetInstructions(tb []*TransactionBundle, price uint64) []solana.Instruction {
+	if !c.Config().PriorityFee.Enabled {
+		return nil
+	}
+
+	return []solana.Instruction{
+		computebudget.NewSetComputeUnitLimitInstruction(computeUnits(tb)).Build(),
+		computebudget.NewSetComputeUnitPriceInstruction(price).Build(),
+	}
+}
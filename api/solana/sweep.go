@@ -0,0 +1,115 @@
+package solana
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Aran404/Forwarder/api/types"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+)
+
+type SweepResult struct {
+	Wallets   []*walletPair
+	Signature *solana.Signature
+	Fees      *Fees
+	Err       error
+}
+
+// SendAllBalances sends the entire balance of every wallet in a single transaction and returns the fees paid
+// It only returns once the transaction is confirmed, so the wallets can be disposed of afterwards
+// The configured fee payer pays the fee if there is one, otherwise the wallet with the highest balance does
+func (c Client) SendAllBalances(ctx context.Context, from []*walletPair, to string, simulate bool) (*solana.Signature, *Fees, error) {
+	if len(from) == 0 {
+		return nil, nil, nil
+	}
+
+	var (
+		tb    []*TransactionBundle
+		payer int
+	)
+	for i, w := range from {
+		balance, err := c.LamportsAt(ctx, w.PublicKey.String(), rpc.CommitmentConfirmed)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tb = append(tb, &TransactionBundle{From: w, To: to, Lamports: balance})
+		if balance > tb[payer].Lamports {
+			payer = i
+		}
+	}
+
+	feePayer := c.feePayer
+	if feePayer == nil {
+		feePayer = from[payer]
+	}
+
+	price := c.PriorityFee(ctx, tb, feePayer)
+	tx, fees, err := c.buildWithPriority(ctx, tb, feePayer, price)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fee, err := c.rpc.GetFeeForMessage(ctx, tx.Message.ToBase64(), rpc.CommitmentConfirmed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get transaction fee: %v", err)
+	}
+
+	if fee == nil || fee.Value == nil || *fee.Value <= 0 {
+		return nil, nil, fmt.Errorf("failed to get transaction fee")
+	}
+
+	if c.feePayer == nil {
+		totalLamports := tb[payer].Lamports
+		if totalLamports <= *fee.Value {
+			return nil, nil, fmt.Errorf("insufficient funds to cover transaction fee: balance=%d, fee=%d", totalLamports, *fee.Value)
+		}
+
+		tb[payer].Lamports = totalLamports - *fee.Value
+		if tx, fees, err = c.buildWithPriority(ctx, tb, feePayer, price); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if simulate {
+		sim, err := c.SimulateTransaction(ctx, tx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sim.Overboard() {
+			return nil, nil, types.ErrTransactionOverboard
+		}
+	}
+
+	fees.Lamports = *fee.Value
+	sig, err := c.SendAndConfirm(ctx, c.rebuilder(tx, tb, feePayer, price), SendCommitment)
+	return sig, fees, err
+}
+
+// SweepWallets sends the entire balance of every wallet to one address
+// Wallets are packed into as few transactions as the size limit allows
+func (c Client) SweepWallets(ctx context.Context, wallets []*walletPair, to string) []*SweepResult {
+	tb := make([]*TransactionBundle, 0, len(wallets))
+	for _, w := range wallets {
+		tb = append(tb, &TransactionBundle{From: w, To: to})
+	}
+
+	plan, oversized := c.PlanTransactions(tb, c.feePayer)
+
+	var results []*SweepResult
+	for _, t := range oversized {
+		results = append(results, &SweepResult{Wallets: []*walletPair{t.From}, Err: types.ErrTransactionOverboard})
+	}
+
+	for _, group := range plan {
+		batch := make([]*walletPair, 0, len(group))
+		for _, t := range group {
+			batch = append(batch, t.From)
+		}
+
+		sig, fees, err := c.SendAllBalances(ctx, batch, to, false)
+		results = append(results, &SweepResult{Wallets: batch, Signature: sig, Fees: fees, Err: err})
+	}
+	return results
+}
@@ -5,22 +5,27 @@ import (
 	"fmt"
 
 	"github.com/Aran404/Forwarder/api/types"
+	bin "github.com/gagliardetto/binary"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/programs/system"
 	"github.com/gagliardetto/solana-go/rpc"
 )
 
-// CreateMTransactions creates multiple atomic transactions that are bundled together
+// CreateMTransactions creates multiple atomic transactions that are bundled together and waits for confirmation
 // If one of the transactions fails, the entire bundle fails
+// Bundles that do not fit in one transaction return ErrTransactionOverboard, use SendPlanned to split them
 func (c Client) CreateMTransactions(ctx context.Context, tb []*TransactionBundle, payer *walletPair, simulate bool) (*solana.Signature, error) {
 	if len(tb) == 0 {
 		return nil, nil
 	}
+	if payer == nil {
+		payer = c.feePayer
+	}
 	if payer == nil {
 		payer = tb[0].From
 	}
 
-	tx, err := c.buildTransactions(ctx, tb, payer)
+	tx, fees, err := c.buildTransactions(ctx, tb, payer)
 	if err != nil {
 		return nil, err
 	}
@@ -35,12 +40,21 @@ func (c Client) CreateMTransactions(ctx context.Context, tb []*TransactionBundle
 		}
 	}
 
-	opts := rpc.TransactionOpts{
-		SkipPreflight:       false,
-		PreflightCommitment: rpc.CommitmentConfirmed,
+	return c.SendAndConfirm(ctx, c.rebuilder(tx, tb, payer, fees.MicroLamports), SendCommitment)
+}
+
+// rebuilder returns the already built transaction first and rebuilds it with a fresh blockhash after
+func (c Client) rebuilder(tx *solana.Transaction, tb []*TransactionBundle, payer *walletPair, price uint64) func(context.Context) (*solana.Transaction, error) {
+	return func(ctx context.Context) (*solana.Transaction, error) {
+		if tx != nil {
+			first := tx
+			tx = nil
+			return first, nil
+		}
+
+		rebuilt, _, err := c.buildWithPriority(ctx, tb, payer, price)
+		return rebuilt, err
 	}
-	sig, err := c.rpc.SendTransactionWithOpts(ctx, tx, opts)
-	return &sig, err
 }
 
 // CreateTransaction creates a singly atomic transaction
@@ -48,63 +62,16 @@ func (c Client) CreateTransaction(ctx context.Context, from *walletPair, to stri
 	return c.CreateMTransactions(
 		ctx,
 		[]*TransactionBundle{
-			{From: from, To: to, Amount: amount},
+			{From: from, To: to, Lamports: ConvertSolToLamport(amount)},
 		},
 		nil,
 		simulate,
 	)
 }
 
-// SendAllBalance sends the entire balance of a wallet
-func (c Client) SendAllBalance(ctx context.Context, from *walletPair, to string, simulate bool) (*solana.Signature, error) {
-	bal, err := c.WalletBalance(ctx, from.PublicKey.String())
-	if err != nil {
-		return nil, err
-	}
-
-	balance, _ := bal.Float64()
-	tb := []*TransactionBundle{{From: from, To: to, Amount: balance}}
-	tx, err := c.buildTransactions(ctx, tb, from)
-	if err != nil {
-		return nil, err
-	}
-
-	fee, err := c.rpc.GetFeeForMessage(ctx, tx.Message.ToBase64(), rpc.CommitmentConfirmed)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get transaction fee: %v", err)
-	}
-
-	if fee == nil || fee.Value == nil || *fee.Value <= 0 {
-		return nil, fmt.Errorf("failed to get transaction fee")
-	}
-
-	totalLamports := uint64(balance * float64(solana.LAMPORTS_PER_SOL))
-	if totalLamports <= *fee.Value {
-		return nil, fmt.Errorf("insufficient funds to cover transaction fee: balance=%d, fee=%d", totalLamports, fee)
-	}
-
-	tb[0].Amount = float64(totalLamports-*fee.Value) / float64(solana.LAMPORTS_PER_SOL)
-	tx, err = c.buildTransactions(ctx, tb, from)
-	if err != nil {
-		return nil, err
-	}
-
-	if simulate {
-		sim, err := c.SimulateTransaction(ctx, tx)
-		if err != nil {
-			return nil, err
-		}
-		if sim.Overboard() {
-			return nil, types.ErrTransactionOverboard
-		}
-	}
-
-	opts := rpc.TransactionOpts{
-		SkipPreflight:       false,
-		PreflightCommitment: rpc.CommitmentConfirmed,
-	}
-	sig, err := c.rpc.SendTransactionWithOpts(ctx, tx, opts)
-	return &sig, err
+// SendAllBalance sends the entire balance of a wallet and returns the fees paid
+func (c Client) SendAllBalance(ctx context.Context, from *walletPair, to string, simulate bool) (*solana.Signature, *Fees, error) {
+	return c.SendAllBalances(ctx, []*walletPair{from}, to, simulate)
 }
 
 // SimulateTransaction simulates a transaction
@@ -134,58 +101,78 @@ func (s simulationResult) Overboard() bool {
 	return s.Error() != nil || s.UnitsConsumed != nil && *s.UnitsConsumed > 1400000
 }
 
-func (c Client) mapWallets(tb []*TransactionBundle) map[solana.PublicKey]*solana.PrivateKey {
-	m := make(map[solana.PublicKey]*solana.PrivateKey)
+func (c Client) mapWallets(tb []*TransactionBundle, payer *walletPair) map[solana.PublicKey]*solana.PrivateKey {
+	m := map[solana.PublicKey]*solana.PrivateKey{payer.PublicKey: &payer.PrivateKey}
 	for _, t := range tb {
 		m[t.From.PublicKey] = &t.From.PrivateKey
 	}
 	return m
 }
 
-func (c Client) buildTransactions(ctx context.Context, tb []*TransactionBundle, payer *walletPair) (*solana.Transaction, error) {
-	recent, err := c.rpc.GetLatestBlockhash(ctx, rpc.CommitmentConfirmed)
-	if err != nil {
-		return nil, err
-	}
-
-	var (
-		instructions []solana.Instruction
-		consumed     uint64
-	)
+func (c Client) instructions(tb []*TransactionBundle, price uint64) ([]solana.Instruction, error) {
+	instructions := c.budgetInstructions(tb, price)
 	for _, t := range tb {
-		singly := system.NewTransferInstruction(
-			uint64(t.Amount*float64(solana.LAMPORTS_PER_SOL)),
-			t.From.PublicKey,
-			solana.MustPublicKeyFromBase58(t.To),
-		).Build()
-
-		b, err := singly.Data()
+		to, err := solana.PublicKeyFromBase58(t.To)
 		if err != nil {
 			return nil, err
 		}
-		instructions = append(instructions, singly)
-		consumed += uint64(len(b))
+
+		instructions = append(instructions, system.NewTransferInstruction(
+			t.Lamports,
+			t.From.PublicKey,
+			to,
+		).Build())
+	}
+	return instructions, nil
+}
+
+// TransactionSize returns the size of the transaction once signed and serialized
+func TransactionSize(tx *solana.Transaction) (int, error) {
+	msg, err := tx.Message.MarshalBinary()
+	if err != nil {
+		return 0, err
+	}
+
+	var count []byte
+	signers := int(tx.Message.Header.NumRequiredSignatures)
+	bin.EncodeCompactU16Length(&count, signers)
+	return len(count) + signers*64 + len(msg), nil
+}
+
+func (c Client) buildTransactions(ctx context.Context, tb []*TransactionBundle, payer *walletPair) (*solana.Transaction, *Fees, error) {
+	return c.buildWithPriority(ctx, tb, payer, c.PriorityFee(ctx, tb, payer))
+}
+
+func (c Client) buildWithPriority(ctx context.Context, tb []*TransactionBundle, payer *walletPair, price uint64) (*solana.Transaction, *Fees, error) {
+	recent, err := c.rpc.GetLatestBlockhash(ctx, rpc.CommitmentConfirmed)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	if consumed > 1232 || len(instructions) > 30 {
-		return nil, types.ErrTransactionOverboard
+	instructions, err := c.instructions(tb, price)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	tx, err := solana.NewTransaction(
 		instructions,
 		recent.Value.Blockhash,
-		solana.TransactionPayer(payer.PublicKey),
+		c.transactionOptions(tb, payer)...,
 	)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	size, err := TransactionSize(tx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	mappedWallets := c.mapWallets(tb)
-	if len(mappedWallets) > 18 {
-		return nil, types.ErrTransactionOverboard
+	if size > MaxTransactionSize {
+		return nil, nil, types.ErrTransactionOverboard
 	}
 
+	mappedWallets := c.mapWallets(tb, payer)
 	_, err = tx.Sign(
 		func(key solana.PublicKey) *solana.PrivateKey {
 			if pk, ok := mappedWallets[key]; ok {
@@ -195,7 +182,12 @@ func (c Client) buildTransactions(ctx context.Context, tb []*TransactionBundle,
 		},
 	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	fees := &Fees{MicroLamports: price}
+	if c.Config().PriorityFee.Enabled {
+		fees.ComputeUnits = computeUnits(tb)
 	}
-	return tx, nil
+	return tx, fees, nil
 }
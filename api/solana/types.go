@@ -2,20 +2,20 @@ package solana
 
 import (
 	"context"
-	"log"
-	"time"
+	"fmt"
+	"sync/atomic"
 
-	"github.com/Aran404/Forwarder/api/types"
+	"github.com/Aran404/Forwarder/api/config"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
-	"github.com/gagliardetto/solana-go/rpc/ws"
-	"golang.org/x/time/rate"
 )
 
+const MaxTransactionSize = 1232 // Largest serialized transaction accepted by the network
+
 type TransactionBundle struct {
-	From   *walletPair
-	To     string  // address
-	Amount float64 // in SOL
+	From     *walletPair
+	To       string // address
+	Lamports uint64
 }
 
 type ledgerResult struct {
@@ -37,27 +37,55 @@ type jsonWallet struct {
 }
 
 type Client struct {
-	httpNode string
-	wsNode   string
-
-	rpc *rpc.Client
-	ws  *ws.Client
-}
-
-func NewClient(ctx context.Context) *Client {
-	rpc := rpc.NewWithCustomRPCClient(rpc.NewWithLimiter(
-		types.Env.SOLANA_NET_HTTP,
-		rate.Every(time.Duration(types.Config.RatelimitEvery)*time.Second),
-		types.Config.RatelimitReset,
-	))
-	wsClient, err := ws.Connect(ctx, types.Env.SOLANA_NET_WS)
-	if err != nil {
-		log.Fatal(err)
+	cfg     *atomic.Pointer[config.Config] // Shared by the copies value receivers make
+	rpc     *rpc.Client
+	pool    *Pool // Endpoints behind rpc and the websocket connection
+	watcher *watcher
+	stop    context.CancelFunc // Stops the health checks and the watcher
+
+	feePayer *walletPair   // Pays network fees for sweeps and refunds when set
+	lookups  *lookupTables // Address lookup tables for transactions with several transfers
+}
+
+func NewClient(ctx context.Context, cfg *config.Config) (*Client, error) {
+	ctx, stop := context.WithCancel(ctx)
+	pool := NewPool(cfg)
+	go pool.Run(ctx)
+
+	c := &Client{
+		stop:    stop,
+		rpc:     rpc.NewWithCustomRPCClient(pool),
+		pool:    pool,
+		lookups: &lookupTables{},
+		cfg:     new(atomic.Pointer[config.Config]),
 	}
-	return &Client{
-		rpc:      rpc,
-		ws:       wsClient,
-		httpNode: types.Env.SOLANA_NET_HTTP,
-		wsNode:   types.Env.SOLANA_NET_WS,
+	c.cfg.Store(cfg)
+	c.watcher = newWatcher(ctx, c)
+	go c.watcher.run(ctx)
+
+	if path := cfg.Forwarder.FeePayer; path != "" {
+		var err error
+		if c.feePayer, err = c.FromFile(path); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("could not load fee payer: %w", err)
+		}
 	}
+	return c, nil
+}
+
+// Close stops the watcher and the endpoint health checks, then closes the websocket connection
+// Watches stop finding transactions, so their state should be checkpointed first
+func (c Client) Close() {
+	c.stop()
+	c.pool.Close()
+}
+
+// Config returns the config in use, it is replaced as a whole on reload
+func (c Client) Config() *config.Config {
+	return c.cfg.Load()
+}
+
+// SetConfig swaps the config used from the next transaction on
+func (c Client) SetConfig(cfg *config.Config) {
+	c.cfg.Store(cfg)
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
 
 	"github.com/gagliardetto/solana-go"
@@ -29,6 +30,11 @@ func ConvertLamportToSol[T Number](lamports T) *big.Float {
 	return lmps
 }
 
+// ConvertSolToLamport returns the lamports closest to an amount in SOL
+func ConvertSolToLamport(sol float64) uint64 {
+	return uint64(math.Round(sol * float64(solana.LAMPORTS_PER_SOL)))
+}
+
 func createQR(data string) (string, error) {
 	qrc, err := qrcode.New(data)
 	if err != nil {
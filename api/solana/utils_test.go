@@ -0,0 +1,19 @@
+package solana
+
+import "testing"
+
+func TestConvertSolToLamportRoundTrips(t *testing.T) {
+	for _, lamports := range []uint64{0, 1, 890880, 1007919, 5000000000, 18446744073} {
+		sol, _ := ConvertLamportToSol(lamports).Float64()
+		if got := ConvertSolToLamport(sol); got != lamports {
+			t.Errorf("%v lamports came back as %v", lamports, got)
+		}
+	}
+
+	for lamports := uint64(1000000); lamports < 1030000; lamports++ {
+		sol, _ := ConvertLamportToSol(lamports).Float64()
+		if got := ConvertSolToLamport(sol); got != lamports {
+			t.Fatalf("%v lamports came back as %v", lamports, got)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math/big"
 	"os"
 
+	"github.com/Aran404/Forwarder/api/types"
 	"github.com/gagliardetto/solana-go"
 	"github.com/gagliardetto/solana-go/rpc"
 )
@@ -21,12 +22,39 @@ func (Client) CreateWallet() *walletPair {
 
 // WalletBalance returns the balance of a wallet in SOL
 func (c Client) WalletBalance(ctx context.Context, address string) (*big.Float, error) {
-	signature := solana.MustPublicKeyFromBase58(address)
-	bal, err := c.rpc.GetBalance(ctx, signature, rpc.CommitmentConfirmed)
+	return c.BalanceAt(ctx, address, rpc.CommitmentConfirmed)
+}
+
+// BalanceAt returns the balance of a wallet in SOL as seen at the commitment
+func (c Client) BalanceAt(ctx context.Context, address string, commitment rpc.CommitmentType) (*big.Float, error) {
+	lamports, err := c.LamportsAt(ctx, address, commitment)
 	if err != nil {
 		return nil, err
 	}
-	return ConvertLamportToSol(bal.Value), nil
+	return ConvertLamportToSol(lamports), nil
+}
+
+// LamportsAt returns the balance of a wallet in lamports as seen at the commitment
+// Amounts that are sent on should use it, a round trip through SOL can lose a lamport
+func (c Client) LamportsAt(ctx context.Context, address string, commitment rpc.CommitmentType) (uint64, error) {
+	key, err := solana.PublicKeyFromBase58(address)
+	if err != nil {
+		return 0, types.ErrInvalidAddress
+	}
+
+	bal, err := c.rpc.GetBalance(ctx, key, commitment)
+	if err != nil {
+		return 0, err
+	}
+	return bal.Value, nil
+}
+
+// FeePayer returns the address of the configured fee payer, if any
+func (c Client) FeePayer() string {
+	if c.feePayer == nil {
+		return ""
+	}
+	return c.feePayer.PublicKey.String()
 }
 
 // RequestAirdrop requests an airdrop used for testing
@@ -41,10 +69,9 @@ func (w walletPair) Encode() string {
 }
 
 // WriteTemporary writes the wallet to a temporary file and returns the path
-func (w walletPair) WriteTemporary() (string, error) {
-	b := w.Encode()
+func (c Client) WriteTemporary(w *walletPair) (string, error) {
 	tmp := fmt.Sprintf("wal/%v.dat", w.PublicKey.String())
-	if err := os.WriteFile(tmp, []byte(b), 0600); err != nil {
+	if err := c.WriteWallet(w, tmp); err != nil {
 		return "", err
 	}
 	return tmp, nil
@@ -74,9 +101,22 @@ func (c Client) FromFile(path string) (*walletPair, error) {
 		return nil, err
 	}
 
-	priv := solana.MustPrivateKeyFromBase58(string(b))
-	if priv == nil {
-		return nil, fmt.Errorf("invalid private key")
+	if Encrypted(b) {
+		passphrase := c.Config().Wallet.Passphrase
+		if passphrase == "" {
+			return nil, types.ErrWalletLocked
+		}
+
+		key, err := DecryptKey(string(b), passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %w", path, err)
+		}
+		b = []byte(key)
+	}
+
+	priv, err := solana.PrivateKeyFromBase58(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("%v: %w", path, err)
 	}
 
 	// kp := strings.Split(path, "/")
@@ -87,3 +127,18 @@ func (c Client) FromFile(path string) (*walletPair, error) {
 		PrivateKey: priv,
 	}, nil
 }
+
+// FromFiles decodes a wallet from each file that can be read, failed holds the error of every other path
+func (c Client) FromFiles(paths ...string) (wallets []*walletPair, failed map[string]error) {
+	wallets = make([]*walletPair, 0, len(paths))
+	failed = make(map[string]error)
+	for _, path := range paths {
+		w, err := c.FromFile(path)
+		if err != nil {
+			failed[path] = err
+			continue
+		}
+		wallets = append(wallets, w)
+	}
+	return wallets, failed
+}
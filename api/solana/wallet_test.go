@@ -0,0 +1,34 @@
+package solana
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFromFilesSkipsUnreadableWallets(t *testing.T) {
+	c := testClient(t)
+	dir := t.TempDir()
+
+	w := c.CreateWallet()
+	good := filepath.Join(dir, "good.dat")
+	if err := c.WriteWallet(w, good); err != nil {
+		t.Fatal(err)
+	}
+
+	truncated := filepath.Join(dir, "truncated.dat")
+	corrupt := filepath.Join(dir, "corrupt.dat")
+	for path, content := range map[string]string{truncated: w.Encode()[:20], corrupt: "not base58 0OIl"} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wallets, failed := c.FromFiles(good, truncated, corrupt, filepath.Join(dir, "missing.dat"))
+	if len(wallets) != 1 || wallets[0].PublicKey != w.PublicKey {
+		t.Errorf("got %v wallets, want only the good one", len(wallets))
+	}
+	if len(failed) != 3 {
+		t.Errorf("got %v failures, want the truncated, corrupt and missing files", failed)
+	}
+}
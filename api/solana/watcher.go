@@ -0,0 +1,713 @@
+package solana
+
+import (
+	"context"
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gagliardetto/solana-go/rpc/ws"
+)
+
+var (
+	BackfillDepth           = 25               // Signatures looked up for an address after a reconnect
+	MinReconnectDelay       = time.Second      // First wait before dialing again
+	MaxReconnectDelay       = time.Second * 30 // Cap of the doubling wait between dials
+	DefaultPollInterval     = time.Second * 5  // Time between polls of an address
+	DefaultWatchWorkers     = 16
+	DefaultMaxSubscriptions = 1000
+)
+
+// commitmentLevels are ordered from the least to the most strict
+var commitmentLevels = []rpc.CommitmentType{rpc.CommitmentProcessed, rpc.CommitmentConfirmed, rpc.CommitmentFinalized}
+
+// Watch is an address to look for transactions on
+type Watch struct {
+	Address    string
+	Commitment rpc.CommitmentType
+	Subscribe  bool   // Open a log subscription, the address is polled instead while too many are open
+	Poll       bool   // Poll getSignaturesForAddress even while subscribed
+	Fallback   bool   // Poll while the websocket is disconnected
+	Cursor     string // Newest signature already polled
+
+	OnCursor func(cursor string) // Called when polling moves the cursor forward
+
+	// Handle is called with each successful transaction and returns true when the watch is done
+	// An error means the signature could not be decided yet, it is offered again and polling does not move past it
+	Handle func(signature string) (bool, error)
+}
+
+// WatcherStats reports what the watcher is doing
+type WatcherStats struct {
+	Watches       int    `json:"watches"`
+	Addresses     int    `json:"addresses"`
+	Subscriptions int    `json:"subscriptions"`
+	Polled        int    `json:"polled"` // Addresses currently found by polling
+	Queued        int    `json:"queued"` // Lookups and polls waiting for a worker
+	Workers       int    `json:"workers"`
+	Connected     bool   `json:"connected"`
+	Processed     uint64 `json:"processed"` // Signatures handed to watches
+	Reconnects    uint64 `json:"reconnects"`
+}
+
+// entry multiplexes every watch of an address onto one subscription
+type entry struct {
+	address    solana.PublicKey
+	commitment rpc.CommitmentType // The strictest level any watch asked for
+	watches    map[*Watch]bool
+
+	subscribe bool
+	poll      bool
+	fallback  bool
+
+	sub         *ws.LogSubscription // nil while unsubscribed
+	subscribing bool                // A subscription slot is reserved and being opened
+	cursor      string
+	before      string          // Where polling resumes paging back to the cursor, set while a long history is read over several polls
+	head        string          // Newest signature of that history, the cursor moves to it once the cursor is reached
+	polling     bool            // A poll is queued or running
+	seen        map[string]bool // Signatures handed to the watches or on their way to them
+	pending     map[string]bool // Signatures not decided on yet, on their way or waiting to be offered again
+
+	handling sync.Mutex // Handlers of an address run one at a time
+}
+
+type job struct {
+	entry      *entry
+	signatures []string
+	poll       bool
+	backfill   bool
+}
+
+// watcher owns the websocket and every subscription, and runs lookups on a bounded pool of workers
+type watcher struct {
+	ctx      context.Context // Ends with the client
+	c        *Client
+	workers  int
+	maxSubs  int
+	interval time.Duration
+
+	mu         sync.Mutex
+	client     *ws.Client
+	entries    map[string]*entry
+	subscribed int
+
+	jobs      chan job
+	reconnect chan struct{}
+
+	processed  atomic.Uint64
+	reconnects atomic.Uint64
+}
+
+func newWatcher(ctx context.Context, c *Client) *watcher {
+	w := &watcher{
+		ctx:       ctx,
+		c:         c,
+		workers:   c.Config().Watcher.Workers,
+		maxSubs:   c.Config().Watcher.MaxSubscriptions,
+		interval:  time.Duration(c.Config().Detection.PollInterval) * time.Second,
+		entries:   make(map[string]*entry),
+		reconnect: make(chan struct{}, 1),
+	}
+
+	if w.workers <= 0 {
+		w.workers = DefaultWatchWorkers
+	}
+	if w.maxSubs <= 0 {
+		w.maxSubs = DefaultMaxSubscriptions
+	}
+	if w.interval <= 0 {
+		w.interval = DefaultPollInterval
+	}
+
+	w.jobs = make(chan job, w.workers*64)
+	w.reconnect <- struct{}{}
+	return w
+}
+
+// add registers a watch until ctx is done or its handler returns true
+func (w *watcher) add(ctx context.Context, watch *Watch) {
+	w.mu.Lock()
+	e, ok := w.entries[watch.Address]
+	if !ok {
+		e = &entry{
+			address:    solana.MustPublicKeyFromBase58(watch.Address),
+			commitment: watch.Commitment,
+			watches:    make(map[*Watch]bool),
+			cursor:     watch.Cursor,
+			seen:       make(map[string]bool),
+			pending:    make(map[string]bool),
+		}
+		w.entries[watch.Address] = e
+	}
+
+	e.watches[watch] = true
+	e.subscribe = e.subscribe || watch.Subscribe
+	e.poll = e.poll || watch.Poll
+	e.fallback = e.fallback || watch.Fallback
+
+	// A watch asking for a stricter level than the address is watched at upgrades the address
+	// An open subscription would still notify at the old level, so it is replaced
+	var stale *ws.LogSubscription
+	if stricter(watch.Commitment, e.commitment) {
+		e.commitment = watch.Commitment
+		if e.sub != nil {
+			stale, e.sub = e.sub, nil
+			w.subscribed--
+		}
+	}
+
+	var reserved []*entry
+	if w.reserve(e) {
+		reserved = append(reserved, e)
+	}
+	client := w.client
+	w.mu.Unlock()
+
+	if stale != nil {
+		stale.Unsubscribe()
+	}
+	w.subscribe(client, reserved)
+	context.AfterFunc(ctx, func() { w.remove(e, watch) })
+}
+
+// stricter checks if commitment a waits for more confirmations than b
+func stricter(a, b rpc.CommitmentType) bool {
+	return slices.Index(commitmentLevels, a) > slices.Index(commitmentLevels, b)
+}
+
+// remove drops a watch, closing the subscription of the address once nothing watches it
+func (w *watcher) remove(e *entry, watch *Watch) {
+	w.mu.Lock()
+	delete(e.watches, watch)
+	if len(e.watches) > 0 || w.entries[e.address.String()] != e {
+		w.mu.Unlock()
+		return
+	}
+
+	delete(w.entries, e.address.String())
+	var promoted []*entry
+	sub := e.sub
+	if sub != nil {
+		e.sub = nil
+		w.subscribed--
+		promoted = w.promote()
+	}
+	client := w.client
+	w.mu.Unlock()
+
+	if sub != nil {
+		sub.Unsubscribe()
+	}
+	w.subscribe(client, promoted)
+}
+
+// reserve claims a subscription slot for an address that should be subscribed, w.mu must be held
+func (w *watcher) reserve(e *entry) bool {
+	if !e.subscribe || e.sub != nil || e.subscribing || w.client == nil || w.subscribed >= w.maxSubs {
+		return false
+	}
+	e.subscribing = true
+	w.subscribed++
+	return true
+}
+
+// promote reserves slots for addresses that were polled because the subscription limit was reached, w.mu must be held
+func (w *watcher) promote() []*entry {
+	var promoted []*entry
+	for _, e := range w.entries {
+		if w.reserve(e) {
+			promoted = append(promoted, e)
+		}
+	}
+	return promoted
+}
+
+// subscribe opens the log subscriptions of addresses with a reserved slot
+// It talks to the node, so w.mu must not be held, and gives up once the connection is replaced or breaks
+func (w *watcher) subscribe(client *ws.Client, entries []*entry) {
+	for i := 0; i < len(entries); i++ {
+		e := entries[i]
+		w.mu.Lock()
+		commitment := e.commitment
+		w.mu.Unlock()
+
+		sub, err := client.LogsSubscribeMentions(e.address, commitment)
+
+		w.mu.Lock()
+		current := e.subscribing && w.client == client
+		if err == nil && current && e.commitment != commitment && w.entries[e.address.String()] == e {
+			// Upgraded while subscribing, the slot is kept for a subscription at the new level
+			w.mu.Unlock()
+			sub.Unsubscribe()
+			entries = append(entries, e)
+			continue
+		}
+		if current {
+			e.subscribing = false
+		}
+
+		if err != nil {
+			var closeAll func()
+			if current {
+				w.subscribed--
+				log.Printf("Could not subscribe to %v: %v", e.address, err)
+				closeAll = w.disconnect(client)
+			}
+			w.mu.Unlock()
+			if closeAll != nil {
+				closeAll()
+			}
+			return
+		}
+
+		// Nothing watches the address anymore, or the slot went with the connection it was reserved on
+		if !current || w.entries[e.address.String()] != e {
+			if current {
+				w.subscribed--
+			}
+			w.mu.Unlock()
+			sub.Unsubscribe()
+			continue
+		}
+
+		e.sub = sub
+		w.mu.Unlock()
+		go w.read(e, sub, client)
+	}
+}
+
+func (w *watcher) read(e *entry, sub *ws.LogSubscription, client *ws.Client) {
+	for {
+		v, err := sub.Recv(w.ctx)
+		if w.ctx.Err() != nil {
+			return
+		}
+		if err != nil || v == nil {
+			var closeAll func()
+			w.mu.Lock()
+			if e.sub == sub {
+				log.Printf("Subscription to %v dropped: %v", e.address, err)
+				closeAll = w.disconnect(client)
+			}
+			w.mu.Unlock()
+			if closeAll != nil {
+				closeAll()
+			}
+			return
+		}
+
+		if v.Value.Err != nil {
+			continue
+		}
+
+		if fresh := w.unseen(e, v.Value.Signature.String()); len(fresh) > 0 {
+			w.enqueue(w.ctx, []*entry{e}, job{signatures: fresh})
+		}
+	}
+}
+
+// disconnect drops a broken connection and wakes run to dial again, w.mu must be held
+// The returned func closes its subscriptions and the connection, it talks to the node so it is called once w.mu is released
+func (w *watcher) disconnect(client *ws.Client) func() {
+	if client == nil || w.client != client {
+		return nil
+	}
+
+	w.client = nil
+	var subs []*ws.LogSubscription
+	for _, e := range w.entries {
+		e.subscribing = false
+		if e.sub != nil {
+			subs = append(subs, e.sub)
+			e.sub = nil
+		}
+	}
+	w.subscribed = 0
+
+	select {
+	case w.reconnect <- struct{}{}:
+	default:
+	}
+
+	return func() {
+		for _, sub := range subs {
+			sub.Unsubscribe()
+		}
+		w.c.pool.DropWS(client)
+	}
+}
+
+// run starts the workers and poller, then re-dials with backoff whenever the connection drops
+func (w *watcher) run(ctx context.Context) {
+	for i := 0; i < w.workers; i++ {
+		go w.work(ctx)
+	}
+	go w.pollLoop(ctx)
+
+	for first := true; ; first = false {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.reconnect:
+		}
+
+		delay := MinReconnectDelay
+		for {
+			client, err := w.c.pool.WS(ctx)
+			if err == nil {
+				if restored, ok := w.restore(client); ok {
+					if !first {
+						w.reconnects.Add(1)
+					}
+					w.enqueue(ctx, restored, job{backfill: true})
+					break
+				}
+			} else {
+				log.Printf("Could not connect websocket, retrying in %v: %v", delay, err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay = min(delay*2, MaxReconnectDelay)
+		}
+	}
+}
+
+// restore resubscribes addresses on a fresh connection and returns every address to backfill
+func (w *watcher) restore(client *ws.Client) ([]*entry, bool) {
+	w.mu.Lock()
+	w.client = client
+	promoted := w.promote()
+	w.mu.Unlock()
+
+	w.subscribe(client, promoted)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.client != client {
+		return nil, false
+	}
+
+	restored := make([]*entry, 0, len(w.entries))
+	for _, e := range w.entries {
+		restored = append(restored, e)
+	}
+
+	if len(restored) > 0 {
+		log.Printf("Websocket connected, watching %v addresses with %v subscriptions", len(restored), w.subscribed)
+	}
+	return restored, true
+}
+
+func (w *watcher) enqueue(ctx context.Context, entries []*entry, j job) {
+	for _, e := range entries {
+		j.entry = e
+		if !w.send(ctx, j) {
+			return
+		}
+	}
+}
+
+// send queues a job for the workers, it returns false if ctx is done first
+func (w *watcher) send(ctx context.Context, j job) bool {
+	select {
+	case w.jobs <- j:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (w *watcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-w.jobs:
+			switch {
+			case j.poll:
+				w.poll(ctx, j.entry)
+			case j.backfill:
+				w.backfill(ctx, j.entry)
+			default:
+				w.handle(j.entry, j.signatures)
+			}
+		}
+	}
+}
+
+// unseen marks signatures of an address as seen and pending, and returns the ones that were not seen yet
+func (w *watcher) unseen(e *entry, sigs ...string) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var fresh []string
+	for _, sig := range sigs {
+		if !e.seen[sig] {
+			e.seen[sig] = true
+			e.pending[sig] = true
+			fresh = append(fresh, sig)
+		}
+	}
+	return fresh
+}
+
+func (w *watcher) watchesOf(e *entry) []*Watch {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	watches := make([]*Watch, 0, len(e.watches))
+	for watch := range e.watches {
+		watches = append(watches, watch)
+	}
+	return watches
+}
+
+// handle passes signatures to every watch of the address
+// Signatures a watch could not decide on are released to be offered again
+func (w *watcher) handle(e *entry, sigs []string) {
+	e.handling.Lock()
+	defer e.handling.Unlock()
+
+	for _, sig := range sigs {
+		w.processed.Add(1)
+		decided := true
+		for _, watch := range w.watchesOf(e) {
+			done, err := watch.Handle(sig)
+			if err != nil {
+				log.Printf("Could not handle %v on %v, retrying: %v", sig, e.address, err)
+				decided = false
+				continue
+			}
+			if done {
+				w.remove(e, watch)
+			}
+		}
+		w.decide(e, sig, decided)
+	}
+}
+
+// decide records the outcome of a signature, one that was not decided on is offered again the next poll interval
+func (w *watcher) decide(e *entry, sig string, decided bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if decided {
+		delete(e.pending, sig)
+	} else {
+		delete(e.seen, sig)
+	}
+}
+
+// settled returns the newest signature polling may move the cursor to
+// sigs are oldest first, the cursor stops before the oldest one not decided on yet
+func (w *watcher) settled(e *entry, sigs []string, cursor, next string) string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, sig := range sigs {
+		if !e.pending[sig] {
+			continue
+		}
+		if i == 0 {
+			return cursor
+		}
+		return sigs[i-1]
+	}
+	return next
+}
+
+func (w *watcher) backfill(ctx context.Context, e *entry) {
+	w.mu.Lock()
+	commitment := e.commitment
+	w.mu.Unlock()
+
+	sigs, err := w.c.Signatures(ctx, e.address.String(), BackfillDepth, commitment)
+	if err != nil {
+		log.Printf("Could not backfill %v: %v", e.address, err)
+		return
+	}
+	w.handle(e, w.unseen(e, sigs...))
+}
+
+func (w *watcher) poll(ctx context.Context, e *entry) {
+	w.mu.Lock()
+	cursor, commitment, before, head := e.cursor, e.commitment, e.before, e.head
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		e.polling = false
+		w.mu.Unlock()
+	}()
+
+	sigs, next, more, err := w.c.SignaturesUntil(ctx, e.address.String(), cursor, before, commitment)
+	if err != nil {
+		log.Printf("Could not poll %v: %v", e.address, err)
+		return
+	}
+	w.handle(e, w.unseen(e, sigs...))
+
+	// The history goes further back than one poll reads, the next poll carries on from where this one stopped
+	if head != "" {
+		next = head
+	}
+	w.mu.Lock()
+	if more != "" {
+		e.before, e.head = more, next
+		w.mu.Unlock()
+		return
+	}
+	e.before, e.head = "", ""
+	w.mu.Unlock()
+
+	if next = w.settled(e, sigs, cursor, next); next == cursor {
+		return
+	}
+
+	w.mu.Lock()
+	e.cursor = next
+	w.mu.Unlock()
+
+	for _, watch := range w.watchesOf(e) {
+		if watch.OnCursor != nil {
+			watch.OnCursor(next)
+		}
+	}
+}
+
+// polled checks if an address is currently found by polling, w.mu must be held
+func (w *watcher) polled(e *entry) bool {
+	if w.client == nil {
+		return e.poll || e.fallback
+	}
+	return e.poll || e.sub == nil
+}
+
+// pollLoop queues a poll of every address that needs one each interval
+func (w *watcher) pollLoop(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var due []*entry
+		var retries []job
+		w.mu.Lock()
+		for _, e := range w.entries {
+			if !e.polling && w.polled(e) {
+				e.polling = true
+				due = append(due, e)
+			}
+			if j, ok := w.retry(e); ok {
+				retries = append(retries, j)
+			}
+		}
+		w.mu.Unlock()
+
+		w.enqueue(ctx, due, job{poll: true})
+		for _, j := range retries {
+			w.send(ctx, j)
+		}
+	}
+}
+
+// retry returns a job offering the signatures of an address that were not decided on again, w.mu must be held
+func (w *watcher) retry(e *entry) (job, bool) {
+	j := job{entry: e}
+	for sig := range e.pending {
+		if !e.seen[sig] {
+			e.seen[sig] = true
+			j.signatures = append(j.signatures, sig)
+		}
+	}
+	return j, len(j.signatures) > 0
+}
+
+// checkpoint calls OnCursor with the cursor of every address, so the last position is stored even if an earlier call failed
+func (w *watcher) checkpoint() int {
+	type call struct {
+		watch  *Watch
+		cursor string
+	}
+
+	w.mu.Lock()
+	var calls []call
+	addresses := 0
+	for _, e := range w.entries {
+		if e.cursor == "" {
+			continue
+		}
+		addresses++
+		for watch := range e.watches {
+			if watch.OnCursor != nil {
+				calls = append(calls, call{watch, e.cursor})
+			}
+		}
+	}
+	w.mu.Unlock()
+
+	for _, c := range calls {
+		c.watch.OnCursor(c.cursor)
+	}
+	return addresses
+}
+
+func (w *watcher) stats() WatcherStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	stats := WatcherStats{
+		Addresses:     len(w.entries),
+		Subscriptions: w.subscribed,
+		Queued:        len(w.jobs),
+		Workers:       w.workers,
+		Connected:     w.client != nil,
+		Processed:     w.processed.Load(),
+		Reconnects:    w.reconnects.Load(),
+	}
+	for _, e := range w.entries {
+		stats.Watches += len(e.watches)
+		if w.polled(e) {
+			stats.Polled++
+		}
+	}
+	return stats
+}
+
+// Watch looks for transactions on an address until ctx is done or the watch's handler returns true
+// Watches of the same address share one log subscription
+func (c Client) Watch(ctx context.Context, watch *Watch) {
+	c.watcher.add(ctx, watch)
+}
+
+// Checkpoint passes the cursor of every polled address to its watches, returning how many addresses had one
+// Addresses only found by subscription have no cursor, the next instance backfills them instead
+func (c Client) Checkpoint() int {
+	return c.watcher.checkpoint()
+}
+
+// WatcherStats reports the active watches and subscriptions
+func (c Client) WatcherStats() WatcherStats {
+	return c.watcher.stats()
+}
+
+// Connected checks if the shared websocket is currently connected
+func (c Client) Connected() bool {
+	c.watcher.mu.Lock()
+	defer c.watcher.mu.Unlock()
+	return c.watcher.client != nil
+}
@@ -0,0 +1,466 @@
+package solana
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Aran404/Forwarder/api/config"
+	"github.com/gagliardetto/solana-go"
+	"github.com/gagliardetto/solana-go/rpc"
+	"github.com/gorilla/websocket"
+)
+
+// fakeNode answers the JSON-RPC and websocket calls the watcher makes
+// Every address shares one history of signatures, newest last
+type fakeNode struct {
+	server *httptest.Server
+
+	mu      sync.Mutex
+	history []string
+	failed  map[string]bool
+	subs    map[*websocket.Conn]uint64
+	nextSub uint64
+	levels  []string // Commitment of every logsSubscribe, in order
+	down    bool     // Websocket upgrades are refused
+}
+
+type rpcRequest struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+func newFakeNode(t *testing.T) *fakeNode {
+	t.Helper()
+	n := &fakeNode{failed: make(map[string]bool), subs: make(map[*websocket.Conn]uint64)}
+	n.server = httptest.NewServer(http.HandlerFunc(n.serve))
+	t.Cleanup(n.server.Close)
+	return n
+}
+
+func (n *fakeNode) serve(w http.ResponseWriter, r *http.Request) {
+	if websocket.IsWebSocketUpgrade(r) {
+		n.serveWS(w, r)
+		return
+	}
+
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var result any
+	switch req.Method {
+	case "getHealth":
+		result = "ok"
+	case "getSlot":
+		result = 100
+	case "getSignaturesForAddress":
+		var opts struct {
+			Limit  int    `json:"limit"`
+			Before string `json:"before"`
+			Until  string `json:"until"`
+		}
+		if len(req.Params) > 1 {
+			_ = json.Unmarshal(req.Params[1], &opts)
+		}
+		result = n.signatures(opts.Limit, opts.Before, opts.Until)
+	default:
+		http.Error(w, "unknown method "+req.Method, http.StatusBadRequest)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+}
+
+// signatures lists the history newest first like getSignaturesForAddress, between before and until
+func (n *fakeNode) signatures(limit int, before, until string) []map[string]any {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	out := []map[string]any{}
+	skipping := before != ""
+	for i := len(n.history) - 1; i >= 0 && len(out) < limit; i-- {
+		sig := n.history[i]
+		if skipping {
+			skipping = sig != before
+			continue
+		}
+		if sig == until {
+			break
+		}
+
+		var err any
+		if n.failed[sig] {
+			err = map[string]any{"InstructionError": []any{0, "Custom"}}
+		}
+		out = append(out, map[string]any{"signature": sig, "slot": 100, "err": err, "confirmationStatus": "confirmed"})
+	}
+	return out
+}
+
+func (n *fakeNode) serveWS(w http.ResponseWriter, r *http.Request) {
+	n.mu.Lock()
+	down := n.down
+	n.mu.Unlock()
+	if down {
+		http.Error(w, "down", http.StatusServiceUnavailable)
+		return
+	}
+
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	for {
+		var req rpcRequest
+		if err := conn.ReadJSON(&req); err != nil {
+			n.mu.Lock()
+			delete(n.subs, conn)
+			n.mu.Unlock()
+			return
+		}
+
+		n.mu.Lock()
+		var result any = true
+		if req.Method == "logsSubscribe" {
+			var opts struct {
+				Commitment string `json:"commitment"`
+			}
+			if len(req.Params) > 1 {
+				_ = json.Unmarshal(req.Params[1], &opts)
+			}
+			n.levels = append(n.levels, opts.Commitment)
+			n.nextSub++
+			n.subs[conn] = n.nextSub
+			result = n.nextSub
+		}
+		_ = conn.WriteJSON(map[string]any{"jsonrpc": "2.0", "id": req.ID, "result": result})
+		n.mu.Unlock()
+	}
+}
+
+// add appends a transaction to the history and notifies every subscription
+func (n *fakeNode) add(failed bool) string {
+	b := make([]byte, 64)
+	_, _ = rand.Read(b)
+	sig := solana.SignatureFromBytes(b).String()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.history = append(n.history, sig)
+	n.failed[sig] = failed
+
+	var err any
+	if failed {
+		err = map[string]any{"InstructionError": []any{0, "Custom"}}
+	}
+	for conn, sub := range n.subs {
+		_ = conn.WriteJSON(map[string]any{
+			"jsonrpc": "2.0",
+			"method":  "logsNotification",
+			"params": map[string]any{
+				"subscription": sub,
+				"result": map[string]any{
+					"context": map[string]any{"slot": 100},
+					"value":   map[string]any{"signature": sig, "err": err, "logs": []string{}},
+				},
+			},
+		})
+	}
+	return sig
+}
+
+func (n *fakeNode) subscribed() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subs) > 0
+}
+
+// subscribedAt checks if the newest subscription was opened at the commitment
+func (n *fakeNode) subscribedAt(commitment rpc.CommitmentType) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.subs) > 0 && len(n.levels) > 0 && n.levels[len(n.levels)-1] == string(commitment)
+}
+
+// setDown drops every websocket connection and refuses new ones while down
+func (n *fakeNode) setDown(down bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.down = down
+	if down {
+		for conn := range n.subs {
+			_ = conn.Close()
+		}
+	}
+}
+
+func (n *fakeNode) client(t *testing.T) *Client {
+	t.Helper()
+	cfg := config.Default()
+	cfg.RatelimitEvery = 0
+	cfg.Detection.PollInterval = 1
+	cfg.RPC.Endpoints = []config.RPCEndpoint{{
+		HTTP: n.server.URL,
+		WS:   "ws" + strings.TrimPrefix(n.server.URL, "http"),
+	}}
+
+	c, err := NewClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	eventually(t, "the websocket connects", c.Connected)
+	return c
+}
+
+// recorder keeps what a watch was handed, in order
+type recorder struct {
+	mu      sync.Mutex
+	handled []string
+	cursors []string
+	fail    map[string]int // Times a signature fails to be handled before it succeeds
+}
+
+func (r *recorder) watch(address string) *Watch {
+	return &Watch{
+		Address:    address,
+		Commitment: rpc.CommitmentConfirmed,
+		OnCursor: func(cursor string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.cursors = append(r.cursors, cursor)
+		},
+		Handle: func(sig string) (bool, error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			r.handled = append(r.handled, sig)
+			if r.fail[sig] > 0 {
+				r.fail[sig]--
+				return false, context.DeadlineExceeded
+			}
+			return false, nil
+		},
+	}
+}
+
+func (r *recorder) count(sig string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, s := range r.handled {
+		if s == sig {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *recorder) cursor() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cursors) == 0 {
+		return ""
+	}
+	return r.cursors[len(r.cursors)-1]
+}
+
+func eventually(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !fn() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func address() string {
+	return solana.NewWallet().PublicKey().String()
+}
+
+func TestWatchHandlesSignaturesOnce(t *testing.T) {
+	node := newFakeNode(t)
+	c := node.client(t)
+
+	r := &recorder{}
+	watch := r.watch(address())
+	watch.Subscribe, watch.Poll = true, true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Watch(ctx, watch)
+	eventually(t, "the address is subscribed", node.subscribed)
+
+	// Found by the subscription first, then by polling
+	sig := node.add(false)
+	eventually(t, "polling moves the cursor", func() bool { return r.cursor() == sig })
+
+	if n := r.count(sig); n != 1 {
+		t.Errorf("signature was handled %v times, want once", n)
+	}
+}
+
+func TestWatchRetriesUndecidedSignatures(t *testing.T) {
+	node := newFakeNode(t)
+	c := node.client(t)
+
+	sig := node.add(false)
+	r := &recorder{fail: map[string]int{sig: 1}}
+	watch := r.watch(address())
+	watch.Poll = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Watch(ctx, watch)
+
+	eventually(t, "the signature is handled again", func() bool { return r.count(sig) == 2 })
+	eventually(t, "polling moves the cursor", func() bool { return r.cursor() == sig })
+
+	// The cursor did not move past the signature before it was decided
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cursors) != 1 {
+		t.Errorf("cursor moved to %v, want only once after the retry", r.cursors)
+	}
+}
+
+func TestWatchStartsAtCursor(t *testing.T) {
+	node := newFakeNode(t)
+	c := node.client(t)
+
+	old := node.add(false)
+	fresh := node.add(false)
+	failed := node.add(true)
+
+	r := &recorder{}
+	watch := r.watch(address())
+	watch.Poll, watch.Cursor = true, old
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Watch(ctx, watch)
+
+	// Failed transactions are not handled but the cursor moves past them
+	eventually(t, "polling moves the cursor", func() bool { return r.cursor() == failed })
+	if r.count(old) != 0 || r.count(fresh) != 1 || r.count(failed) != 0 {
+		t.Errorf("handled old %v, fresh %v and failed %v times, want only fresh once", r.count(old), r.count(fresh), r.count(failed))
+	}
+}
+
+func TestWatchBackfillsAfterReconnect(t *testing.T) {
+	node := newFakeNode(t)
+	c := node.client(t)
+
+	r := &recorder{}
+	watch := r.watch(address())
+	watch.Subscribe = true
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Watch(ctx, watch)
+	eventually(t, "the address is subscribed", node.subscribed)
+
+	before := node.add(false)
+	eventually(t, "the subscription finds the signature", func() bool { return r.count(before) == 1 })
+
+	// Sent while disconnected, so only the backfill after reconnecting finds it
+	node.setDown(true)
+	eventually(t, "the websocket disconnects", func() bool { return !c.Connected() })
+	missed := node.add(false)
+	node.setDown(false)
+
+	eventually(t, "the backfill finds the missed signature", func() bool { return r.count(missed) == 1 })
+	if n := r.count(before); n != 1 {
+		t.Errorf("signature seen before the reconnect was handled %v times, want once", n)
+	}
+	if stats := c.WatcherStats(); stats.Reconnects != 1 || stats.Subscriptions != 1 {
+		t.Errorf("got %v reconnects and %v subscriptions, want 1 and 1", stats.Reconnects, stats.Subscriptions)
+	}
+}
+
+func TestWatchUpgradesCommitment(t *testing.T) {
+	node := newFakeNode(t)
+	c := node.client(t)
+
+	addr := address()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	confirmed := &recorder{}
+	watch := confirmed.watch(addr)
+	watch.Subscribe = true
+	c.Watch(ctx, watch)
+	eventually(t, "the address is subscribed", func() bool { return node.subscribedAt(rpc.CommitmentConfirmed) })
+
+	// A later watch asking for finalized replaces the subscription, one at confirmed would notify too early
+	finalized := &recorder{}
+	strict := finalized.watch(addr)
+	strict.Subscribe, strict.Commitment = true, rpc.CommitmentFinalized
+	c.Watch(ctx, strict)
+	eventually(t, "the address is subscribed at finalized", func() bool { return node.subscribedAt(rpc.CommitmentFinalized) })
+
+	// A looser watch keeps the stricter level
+	processed := &recorder{}
+	loose := processed.watch(addr)
+	loose.Subscribe, loose.Commitment = true, rpc.CommitmentProcessed
+	c.Watch(ctx, loose)
+
+	sig := node.add(false)
+	eventually(t, "every watch handles the signature", func() bool {
+		return confirmed.count(sig) == 1 && finalized.count(sig) == 1 && processed.count(sig) == 1
+	})
+	if !node.subscribedAt(rpc.CommitmentFinalized) {
+		t.Error("a looser watch downgraded the subscription")
+	}
+	if stats := c.WatcherStats(); stats.Addresses != 1 || stats.Subscriptions != 1 {
+		t.Errorf("got %v addresses and %v subscriptions, want 1 and 1", stats.Addresses, stats.Subscriptions)
+	}
+}
+
+func TestWatchPagesLongHistoryOverSeveralPolls(t *testing.T) {
+	pageSize, maxPages := SignaturePageSize, MaxSignaturePages
+	SignaturePageSize, MaxSignaturePages = 2, 2
+	t.Cleanup(func() { SignaturePageSize, MaxSignaturePages = pageSize, maxPages })
+
+	node := newFakeNode(t)
+	c := node.client(t)
+
+	cursor := node.add(false)
+	var history []string
+	for i := 0; i < 9; i++ {
+		history = append(history, node.add(false))
+	}
+
+	r := &recorder{}
+	watch := r.watch(address())
+	watch.Poll, watch.Cursor = true, cursor
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Watch(ctx, watch)
+
+	// Four signatures are read per poll, so the cursor is reached on the third
+	newest := history[len(history)-1]
+	eventually(t, "polling moves the cursor", func() bool { return r.cursor() == newest })
+	for _, sig := range history {
+		if n := r.count(sig); n != 1 {
+			t.Errorf("signature was handled %v times, want once", n)
+		}
+	}
+
+	// The cursor only moved once the whole history was read
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.cursors) != 1 {
+		t.Errorf("cursor moved to %v, want only to the newest signature", r.cursors)
+	}
+}
@@ -12,28 +12,101 @@ var (
 	ErrInvalidStatus        = errors.New("invalid status")
 	ErrNoMetadata           = errors.New("no metadata")
 	ErrTransactionOverboard = errors.New("transaction has gone overboard")
+	ErrTransactionExpired   = errors.New("transaction expired before confirmation")
+	ErrTransactionFailed    = errors.New("transaction failed")
+	ErrNoFeePayer           = errors.New("no fee payer")
+	ErrNonceUninitialized   = errors.New("nonce account not initialized")
+	ErrLookupTableFull      = errors.New("lookup table full")
+	ErrNoEndpoint           = errors.New("no rpc endpoint")
+	ErrTransactionDropped   = errors.New("transaction dropped")
+	ErrTransactionUnknown   = errors.New("transaction unknown")
+	ErrWalletLocked         = errors.New("wallet locked")
+	ErrWrongPassphrase      = errors.New("wrong passphrase")
 
 	// Database Errors
-	ErrMustBePointer   = errors.New("must be a pointer")
-	ErrNotFound        = errors.New("no matches found")
-	ErrFilterCollision = errors.New("collision on filter")
+	ErrNotFound      = errors.New("no matches found")
+	ErrUnknownDriver = errors.New("unknown database driver")
+	ErrMissingURI    = errors.New("missing database uri")
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrDuplicate     = errors.New("duplicate key")
 
 	// HTTP Errors
 	ErrNotJSON            = errors.New("not json")
 	ErrInvalidCallbackURI = errors.New("invalid callback uri")
 	ErrInvalidAmount      = errors.New("invalid amount")
 	ErrTransactionSlipped = errors.New("transaction has slipped")
+	ErrMissingAPIKey      = errors.New("missing api key")
+	ErrInvalidAPIKey      = errors.New("invalid api key")
+	ErrInsufficientScope  = errors.New("insufficient scope")
+	ErrClientCertMismatch = errors.New("client certificate mismatch")
+	ErrInvalidCertificate = errors.New("invalid certificate")
+	ErrAlreadyForwarded   = errors.New("payment already forwarded")
+	ErrNotRefundable      = errors.New("payment not refundable")
+	ErrNotPaid            = errors.New("payment not paid")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrInvalidMode        = errors.New("invalid mode")
+	ErrTestModeDisabled   = errors.New("test mode disabled")
+	ErrLiveOnly           = errors.New("live payments only")
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrInvalidName        = errors.New("invalid name")
+	ErrInvalidKind        = errors.New("invalid kind")
+	ErrInvalidAddress     = errors.New("invalid address")
+	ErrNotPending         = errors.New("prepared transaction not pending")
+	ErrSelfApproval       = errors.New("self approval")
+	ErrInvalidCommitment  = errors.New("invalid commitment")
+	ErrInvalidIdempotency = errors.New("invalid idempotency key")
+	ErrBodyTooLarge       = errors.New("request body too large")
+	ErrIdempotencyReused  = errors.New("idempotency key reused")
+	ErrIdempotencyPending = errors.New("idempotent request in progress")
+	ErrShuttingDown       = errors.New("shutting down")
 
 	ProperErrors = map[error]string{
 		ErrInvalidStatus:        "Invalid confirmation status.",
 		ErrNoMetadata:           "No metadata in transaction.",
-		ErrTransactionOverboard: "Transaction has gone overboard, retry with bonded transactions.",
+		ErrTransactionOverboard: "Transaction exceeds the network size limit, split the bundle into several transactions.",
+		ErrTransactionExpired:   "Transaction expired before it was confirmed.",
+		ErrTransactionFailed:    "Transaction failed on chain.",
+		ErrNoFeePayer:           "Durable transactions require a configured fee payer that is the nonce authority.",
+		ErrNonceUninitialized:   "Nonce account is not initialized.",
+		ErrLookupTableFull:      "Lookup table cannot hold more than 256 addresses.",
+		ErrNoEndpoint:           "No Solana RPC endpoint is reachable.",
+		ErrTransactionDropped:   "Transaction was seen but dropped before it was confirmed, the payment is pending again.",
+		ErrTransactionUnknown:   "Transaction is not known to the RPC endpoint yet.",
+		ErrWalletLocked:         "Wallet file is encrypted. Please set wallet.passphrase.",
+		ErrWrongPassphrase:      "Wallet file could not be decrypted with the configured passphrase.",
 		ErrNotFound:             "No matches found in database.",
-		ErrFilterCollision:      "Collision on filter query.",
+		ErrUnknownDriver:        "Unknown database driver. Please use mongo, sqlite, postgres or memory.",
+		ErrMissingURI:           "The postgres driver requires a database uri.",
+		ErrInvalidCursor:        "Invalid cursor. Please pass the next cursor of a previous page.",
+		ErrDuplicate:            "A record with the same key already exists.",
 		ErrNotJSON:              "Request contains invalid JSON. Please use application/json.",
 		ErrInvalidCallbackURI:   "Invalid callback uri.",
 		ErrInvalidAmount:        "Invalid amount to forward. Please provide a higher amount.",
 		ErrTransactionSlipped:   "Transaction has slipped threshold, user has not sent enough funds.",
+		ErrMissingAPIKey:        "Missing API key. Please provide one in the Authorization header.",
+		ErrInvalidAPIKey:        "Invalid, expired or revoked API key.",
+		ErrInsufficientScope:    "API key does not have the required scope for this action.",
+		ErrClientCertMismatch:   "Client certificate is not the one bound to this merchant.",
+		ErrInvalidCertificate:   "Invalid certificate. Please provide a PEM encoded certificate.",
+		ErrAlreadyForwarded:     "Payment has already been forwarded and can no longer be refunded.",
+		ErrNotRefundable:        "Payment has not received any funds to refund.",
+		ErrNotPaid:              "Payment is no longer paid, its funds are already being forwarded or refunded.",
+		ErrInvalidRole:          "Invalid role. Please use viewer, operator or admin.",
+		ErrInvalidMode:          "Invalid mode. Please use live or test.",
+		ErrTestModeDisabled:     "Test mode is not enabled on this server. Please use a live key.",
+		ErrLiveOnly:             "Prepared transactions are only available for live payments.",
+		ErrInvalidScope:         "Invalid scope. Please use create, read or refund.",
+		ErrInvalidName:          "Invalid name. Please provide a non-empty name.",
+		ErrInvalidKind:          "Invalid kind. Please use refund or payout.",
+		ErrInvalidAddress:       "Invalid address. Please provide a base58 Solana address.",
+		ErrNotPending:           "Prepared transaction is no longer awaiting approval.",
+		ErrSelfApproval:         "Prepared transactions must be approved by a different admin.",
+		ErrInvalidCommitment:    "Invalid commitment. Notify at processed, confirmed or finalized and forward at confirmed or finalized.",
+		ErrInvalidIdempotency:   "Invalid Idempotency-Key. Please use at most 255 characters.",
+		ErrBodyTooLarge:         "Request body is too large.",
+		ErrIdempotencyReused:    "Idempotency-Key was already used with a different request.",
+		ErrIdempotencyPending:   "A request with this Idempotency-Key is still being processed. Please retry shortly.",
+		ErrShuttingDown:         "Server is shutting down and not taking new payments. Please retry shortly.",
 	}
 )
 
@@ -91,3 +164,15 @@ func Unauthorized(w http.ResponseWriter, reason error) {
 func Forbidden(w http.ResponseWriter, reason error) {
 	HandleError(w, http.StatusForbidden, reason)
 }
+
+func Conflict(w http.ResponseWriter, reason error) {
+	HandleError(w, http.StatusConflict, reason)
+}
+
+func RequestTooLarge(w http.ResponseWriter, reason error) {
+	HandleError(w, http.StatusRequestEntityTooLarge, reason)
+}
+
+func ServiceUnavailable(w http.ResponseWriter, reason error) {
+	HandleError(w, http.StatusServiceUnavailable, reason)
+}